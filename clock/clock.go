@@ -0,0 +1,133 @@
+// Package clock abstracts the passing of time for the cache engine. The
+// engine's TTL and eviction logic only ever reads time through a Clock, so
+// tests can swap the real one for a Manual clock and advance it at will.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time and creates tickers.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks at intervals, like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the Clock backed by package time.
+type Real struct{}
+
+func (_ Real) Now() time.Time {
+	return time.Now()
+}
+
+func (_ Real) NewTicker(d time.Duration) Ticker {
+	return &realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (rt *realTicker) C() <-chan time.Time {
+	return rt.t.C
+}
+
+func (rt *realTicker) Stop() {
+	rt.t.Stop()
+}
+
+// Manual is a Clock whose time only moves when Advance is called. It is safe
+// for concurrent use.
+type Manual struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers map[*manualTicker]struct{}
+}
+
+// NewManual returns a Manual clock set to t.
+func NewManual(t time.Time) *Manual {
+	return &Manual{now: t, tickers: map[*manualTicker]struct{}{}}
+}
+
+func (m *Manual) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+// NewTicker returns a Ticker firing every d of manual time. It panics if d is
+// not positive, like time.NewTicker.
+func (m *Manual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for Manual.NewTicker")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mt := &manualTicker{
+		m:      m,
+		c:      make(chan time.Time),
+		period: d,
+		next:   m.now.Add(d),
+		stop:   make(chan struct{}),
+	}
+	m.tickers[mt] = struct{}{}
+	return mt
+}
+
+// Advance moves the clock forward by d and fires every ticker which became
+// due, at most once per ticker (missed ticks are dropped as with
+// time.Ticker). Ticks carry the new time. Advance blocks until each tick has
+// been received, or its ticker stopped, so by the time it returns every
+// consumer has started handling its tick.
+func (m *Manual) Advance(d time.Duration) {
+
+	m.mu.Lock()
+	m.now = m.now.Add(d)
+	now := m.now
+	var due []*manualTicker
+	for mt := range m.tickers {
+		if !now.Before(mt.next) {
+			due = append(due, mt)
+			for !now.Before(mt.next) {
+				mt.next = mt.next.Add(mt.period)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	for _, mt := range due {
+		select {
+		case mt.c <- now:
+		case <-mt.stop:
+		}
+	}
+}
+
+type manualTicker struct {
+	m      *Manual
+	c      chan time.Time
+	period time.Duration
+	next   time.Time // guarded by m.mu
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func (mt *manualTicker) C() <-chan time.Time {
+	return mt.c
+}
+
+func (mt *manualTicker) Stop() {
+	mt.once.Do(func() {
+		mt.m.mu.Lock()
+		delete(mt.m.tickers, mt)
+		mt.m.mu.Unlock()
+		close(mt.stop)
+	})
+}
@@ -0,0 +1,59 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManual(t *testing.T) {
+
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewManual(start)
+	assert.Equal(t, start, m.Now())
+
+	tk := m.NewTicker(10 * time.Millisecond)
+	ticks := make(chan time.Time, 10)
+	go func() {
+		for now := range tk.C() {
+			ticks <- now
+		}
+	}()
+
+	m.Advance(9 * time.Millisecond)
+	assert.Equal(t, 0, len(ticks))
+
+	m.Advance(1 * time.Millisecond)
+	assert.Equal(t, start.Add(10*time.Millisecond), <-ticks)
+
+	// missed ticks are dropped
+	m.Advance(35 * time.Millisecond)
+	assert.Equal(t, start.Add(45*time.Millisecond), <-ticks)
+	m.Advance(4 * time.Millisecond)
+	assert.Equal(t, 0, len(ticks))
+	m.Advance(1 * time.Millisecond)
+	assert.Equal(t, start.Add(50*time.Millisecond), <-ticks)
+
+	// stopped tickers neither fire nor block Advance
+	tk.Stop()
+	tk.Stop()
+	m.Advance(time.Second)
+	assert.Equal(t, 0, len(ticks))
+	assert.Equal(t, start.Add(1050*time.Millisecond), m.Now())
+
+	unread := m.NewTicker(time.Millisecond)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		unread.Stop()
+	}()
+	m.Advance(time.Millisecond) // returns once stopped
+}
+
+func TestReal(t *testing.T) {
+	var c Clock = Real{}
+	tk := c.NewTicker(time.Millisecond)
+	defer tk.Stop()
+	before := c.Now()
+	assert.True(t, (<-tk.C()).After(before.Add(-time.Millisecond)))
+}
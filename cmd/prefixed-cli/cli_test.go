@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/clock"
+	"github.com/wv0m56/prefixed/engine"
+	"github.com/wv0m56/prefixed/plugin/client/resp"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+func newTestConn(t *testing.T) (*conn, *engine.Engine, func()) {
+
+	opts := engine.OptionsDefault
+	opts.O = &fake.NoDelayOrigin{}
+	opts.Clock = clock.NewManual(time.Now()) // TTL replies are exact
+	e, err := engine.NewEngine(&opts)
+	assert.Nil(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s := resp.NewServer("")
+	go s.ServeListener(context.Background(), e, ln)
+
+	c, err := dial(ln.Addr().String(), 5*time.Second)
+	assert.Nil(t, err)
+	return c, e, func() {
+		c.Close()
+		s.Close()
+		e.Close()
+	}
+}
+
+func TestPipeline(t *testing.T) {
+
+	c, _, done := newTestConn(t)
+	defer done()
+
+	in := strings.NewReader(`set a 1
+set "b c" 'x y' 1h
+
+get "b c"
+get user:1
+ttl "b c"
+ttl a
+ttl nobody
+del a nobody
+scan "" 10
+bogus
+set a
+get "bench error"
+stats keyspace
+`)
+	var out bytes.Buffer
+	assert.Equal(t, errFailed, pipeline(c, in, &out))
+	assert.Equal(t, `{"result":"OK"}
+{"result":"OK"}
+{"result":"x y"}
+{"result":"user:1"}
+{"result":3600}
+{"result":-1}
+{"result":-2}
+{"result":1}
+{"result":["b c","user:1"]}
+{"error":"unknown command \"bogus\", try help"}
+{"error":"usage: set <key> <value> [ttl, e.g. 30s]"}
+{"error":"ERR fake bench error"}
+{"result":{"db0":"keys=2,expires=1"}}
+`, out.String())
+}
+
+func TestExportImport(t *testing.T) {
+
+	c, e, done := newTestConn(t)
+	defer done()
+
+	for _, k := range []string{"user:1", "user:2", "session:1"} {
+		e.Set(k, []byte("v:"+k))
+	}
+	e.SetWithTTL("user:3", []byte{0, 1, 2, 255}, time.Hour)
+
+	var buf bytes.Buffer
+	n, err := export(c, "user:", &buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+
+	c2, e2, done2 := newTestConn(t)
+	defer done2()
+	n, err = restore(c2, &buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+
+	assert.Equal(t, []string{"user:1", "user:2", "user:3"}, e2.KeysByPrefix("", 0, 0))
+	b, err := e2.GetCopy("user:3")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 1, 2, 255}, b)
+	ttl, ok := e2.TTL("user:3")
+	assert.True(t, ok)
+	assert.InDelta(t, time.Hour.Seconds(), ttl.Seconds(), 5)
+
+	// snapshots of the engine itself can be imported too
+	buf.Reset()
+	_, err = e.Snapshot(&buf)
+	assert.Nil(t, err)
+	n, err = restore(c2, &buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+	assert.True(t, e2.Has("session:1"))
+
+	_, err = restore(c2, strings.NewReader("garbage\n"))
+	assert.NotNil(t, err)
+}
+
+func TestSplitArgs(t *testing.T) {
+
+	args, err := splitArgs(` set  "a \"b\"\n" 'c d'	e `)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"set", "a \"b\"\n", "c d", "e"}, args)
+
+	_, err = splitArgs(`get "a`)
+	assert.NotNil(t, err)
+	_, err = splitArgs(`get 'a`)
+	assert.NotNil(t, err)
+}
+
+func TestComplete(t *testing.T) {
+
+	keys := func(prefix string) []string {
+		var ks []string
+		for _, k := range []string{"user:1", "user:2", "users", "session 1"} {
+			if strings.HasPrefix(k, prefix) {
+				ks = append(ks, k)
+			}
+		}
+		return ks
+	}
+
+	line, cands := complete("g", keys)
+	assert.Equal(t, "get ", line)
+	assert.Nil(t, cands)
+
+	line, _ = complete("st", keys)
+	assert.Equal(t, "stats ", line)
+
+	line, cands = complete("get u", keys)
+	assert.Equal(t, "get user", line)
+	assert.Nil(t, cands)
+
+	line, cands = complete("get user", keys)
+	assert.Equal(t, "get user", line)
+	assert.Equal(t, []string{"user:1", "user:2", "users"}, cands)
+
+	line, _ = complete("get user:2", keys)
+	assert.Equal(t, "get user:2 ", line)
+
+	line, _ = complete("del s", keys)
+	assert.Equal(t, `del "session 1" `, line)
+
+	line, cands = complete("get x", keys)
+	assert.Equal(t, "get x", line)
+	assert.Nil(t, cands)
+}
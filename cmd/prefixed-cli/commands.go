@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// command is a CLI command. run returns a value which is printed as text in
+// the REPL and as JSON in pipeline mode.
+type command struct {
+	usage    string
+	min, max int // number of arguments, max < 0 means unlimited
+	run      func(c *conn, args []string) (interface{}, error)
+}
+
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		"get":   {"get <key>", 1, 1, get},
+		"set":   {"set <key> <value> [ttl, e.g. 30s]", 2, 3, set},
+		"del":   {"del <key>...", 1, -1, del},
+		"ttl":   {"ttl <key>", 1, 1, ttl},
+		"scan":  {"scan <prefix> [limit, default 100]", 1, 2, scanPrefix},
+		"stats": {"stats [section]", 0, 1, stats},
+		"help":  {"help", 0, 0, help},
+	}
+}
+
+// exec runs the command line args. The error is meant for the user, be it a
+// usage, server or network error.
+func exec(c *conn, args []string) (interface{}, error) {
+
+	cmd, ok := commands[strings.ToLower(args[0])]
+	if !ok {
+		return nil, fmt.Errorf("unknown command %q, try help", args[0])
+	}
+	if n := len(args) - 1; n < cmd.min || (cmd.max >= 0 && n > cmd.max) {
+		return nil, errors.New("usage: " + cmd.usage)
+	}
+	return cmd.run(c, args[1:])
+}
+
+// doErr is conn.do with error replies turned into errors.
+func doErr(c *conn, args ...string) (interface{}, error) {
+	r, err := c.do(args...)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := r.(replyError); ok {
+		return nil, e
+	}
+	return r, nil
+}
+
+// get returns nil for a key its origin doesn't know, the value as a string
+// otherwise.
+func get(c *conn, args []string) (interface{}, error) {
+	r, err := doErr(c, "GET", args[0])
+	if b, ok := r.([]byte); ok {
+		return string(b), nil
+	}
+	return nil, err
+}
+
+func set(c *conn, args []string) (interface{}, error) {
+
+	cmd := []string{"SET", args[0], args[1]}
+	if len(args) == 3 {
+		d, err := time.ParseDuration(args[2])
+		if err != nil || d < time.Millisecond {
+			return nil, fmt.Errorf("invalid ttl %q", args[2])
+		}
+		cmd = append(cmd, "PX", strconv.FormatInt(int64(d/time.Millisecond), 10))
+	}
+	return doErr(c, cmd...)
+}
+
+func del(c *conn, args []string) (interface{}, error) {
+	return doErr(c, append([]string{"DEL"}, args...)...)
+}
+
+// ttlValue is a PTTL reply: milliseconds, -1 without TTL, -2 for a missing
+// key. Its JSON form is in seconds, with the same negative values.
+type ttlValue int64
+
+func (t ttlValue) String() string {
+	switch {
+	case t == -1:
+		return "no ttl"
+	case t < 0:
+		return "(no such key)"
+	}
+	return (time.Duration(t) * time.Millisecond).String()
+}
+
+func (t ttlValue) MarshalJSON() ([]byte, error) {
+	if t < 0 {
+		return json.Marshal(int64(t))
+	}
+	return json.Marshal(float64(t) / 1000)
+}
+
+func ttl(c *conn, args []string) (interface{}, error) {
+	r, err := doErr(c, "PTTL", args[0])
+	if n, ok := r.(int64); ok {
+		return ttlValue(n), nil
+	}
+	if err == nil {
+		err = errBadReply
+	}
+	return nil, err
+}
+
+// scanPrefix returns up to limit keys having prefix, in key order.
+func scanPrefix(c *conn, args []string) (interface{}, error) {
+
+	limit := 100
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid limit %q", args[1])
+		}
+		limit = n
+	}
+	return scanKeys(c, args[0], limit)
+}
+
+// scanKeys iterates SCAN until limit keys having prefix are found, or all of
+// them if limit is 0.
+func scanKeys(c *conn, prefix string, limit int) ([]string, error) {
+
+	keys := []string{}
+	cursor := "0"
+	for {
+		count := 500
+		if limit > 0 && limit-len(keys) < count {
+			count = limit - len(keys)
+		}
+
+		r, err := doErr(c, "SCAN", cursor, "MATCH", prefix+"*", "COUNT", strconv.Itoa(count))
+		if err != nil {
+			return nil, err
+		}
+		page, ok := r.([]interface{})
+		if !ok || len(page) != 2 {
+			return nil, errBadReply
+		}
+		next, _ := page[0].([]byte)
+		ks, _ := page[1].([]interface{})
+		for _, k := range ks {
+			if b, ok := k.([]byte); ok {
+				keys = append(keys, string(b))
+			}
+		}
+
+		cursor = string(next)
+		if cursor == "0" || cursor == "" || (limit > 0 && len(keys) >= limit) {
+			return keys, nil
+		}
+	}
+}
+
+// stats returns the INFO fields of the server.
+func stats(c *conn, args []string) (interface{}, error) {
+
+	r, err := doErr(c, append([]string{"INFO"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := r.([]byte)
+	if !ok {
+		return nil, errBadReply
+	}
+
+	m := map[string]string{}
+	for _, line := range strings.Split(string(b), "\r\n") {
+		if i := strings.IndexByte(line, ':'); i > 0 && line[0] != '#' {
+			m[line[:i]] = line[i+1:]
+		}
+	}
+	return m, nil
+}
+
+func help(_ *conn, _ []string) (interface{}, error) {
+	var us []string
+	for _, cmd := range commands {
+		us = append(us, cmd.usage)
+	}
+	sort.Strings(us)
+	return us, nil
+}
+
+// format renders v, as returned by a command, as text.
+func format(v interface{}) string {
+
+	switch v := v.(type) {
+
+	case nil:
+		return "(nil)"
+
+	case string:
+		return strconv.Quote(v)
+
+	case int64:
+		return "(integer) " + strconv.FormatInt(v, 10)
+
+	case []string:
+		if len(v) == 0 {
+			return "(empty)"
+		}
+		var b strings.Builder
+		for i, s := range v {
+			if i > 0 {
+				b.WriteByte('\n')
+			}
+			fmt.Fprintf(&b, "%d) %s", i+1, s)
+		}
+		return b.String()
+
+	case map[string]string:
+		ks := make([]string, 0, len(v))
+		for k := range v {
+			ks = append(ks, k)
+		}
+		sort.Strings(ks)
+		var b strings.Builder
+		for i, k := range ks {
+			if i > 0 {
+				b.WriteByte('\n')
+			}
+			fmt.Fprintf(&b, "%s: %s", k, v[k])
+		}
+		return b.String()
+
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	return fmt.Sprint(v)
+}
+
+// splitArgs splits line on blanks. Double quoted arguments hold Go escape
+// sequences, single quoted ones are taken literally.
+func splitArgs(line string) ([]string, error) {
+
+	var args []string
+	for i := 0; i < len(line); {
+
+		switch c := line[i]; {
+
+		case c == ' ' || c == '\t':
+			i++
+
+		case c == '"':
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(line) {
+				return nil, errors.New("unterminated quote")
+			}
+			s, err := strconv.Unquote(line[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted argument %s", line[i:j+1])
+			}
+			args = append(args, s)
+			i = j + 1
+
+		case c == '\'':
+			j := strings.IndexByte(line[i+1:], '\'')
+			if j < 0 {
+				return nil, errors.New("unterminated quote")
+			}
+			args = append(args, line[i+1:i+1+j])
+			i += j + 2
+
+		default:
+			j := strings.IndexAny(line[i:], " \t")
+			if j < 0 {
+				j = len(line) - i
+			}
+			args = append(args, line[i:i+j])
+			i += j
+		}
+	}
+	return args, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// replyError is an error reply of the server.
+type replyError string
+
+func (e replyError) Error() string {
+	return string(e)
+}
+
+// conn is a RESP2 connection to the server. Replies are decoded into
+// strings (simple strings), []bytes (bulk strings), int64s, nils,
+// []interface{}s and replyErrors.
+type conn struct {
+	nc      net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+}
+
+func dial(addr string, timeout time.Duration) (*conn, error) {
+	nc, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{nc, bufio.NewReader(nc), bufio.NewWriter(nc), timeout}, nil
+}
+
+func (c *conn) Close() error {
+	return c.nc.Close()
+}
+
+// do sends a command and returns its reply. err is only set upon network or
+// protocol failure, error replies are returned as replyErrors.
+func (c *conn) do(args ...string) (interface{}, error) {
+	rs, err := c.pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	return rs[0], nil
+}
+
+// pipeline sends all cmds at once and returns their replies, like do.
+func (c *conn) pipeline(cmds [][]string) ([]interface{}, error) {
+
+	if err := c.nc.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+
+	for _, args := range cmds {
+		fmt.Fprintf(c.w, "*%d\r\n", len(args))
+		for _, a := range args {
+			fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(a), a)
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	rs := make([]interface{}, len(cmds))
+	for i := range rs {
+		r, err := c.reply()
+		if err != nil {
+			return nil, err
+		}
+		rs[i] = r
+	}
+	return rs, nil
+}
+
+var errBadReply = errors.New("invalid reply from server")
+
+// isNetErr reports whether err broke the connection, as opposed to a command
+// failing.
+func isNetErr(err error) bool {
+	_, ok := err.(net.Error)
+	return ok || err == io.EOF || err == io.ErrUnexpectedEOF || err == errBadReply
+}
+
+func (c *conn) reply() (interface{}, error) {
+
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errBadReply
+	}
+	body := line[1 : len(line)-2]
+
+	switch line[0] {
+
+	case '+':
+		return body, nil
+
+	case '-':
+		return replyError(body), nil
+
+	case ':':
+		n, err := strconv.ParseInt(body, 10, 64)
+		if err != nil {
+			return nil, errBadReply
+		}
+		return n, nil
+
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < -1 {
+			return nil, errBadReply
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < -1 {
+			return nil, errBadReply
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		vs := make([]interface{}, n)
+		for i := range vs {
+			if vs[i], err = c.reply(); err != nil {
+				return nil, err
+			}
+		}
+		return vs, nil
+	}
+
+	return nil, errBadReply
+}
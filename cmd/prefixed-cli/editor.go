@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// editor reads lines from a terminal in raw mode, supporting backspace,
+// ctrl-C (discard the line), ctrl-U (clear the line), ctrl-D (EOF on an empty
+// line) and tab completion. Other control keys and escape sequences such as
+// arrows are ignored. If in is not a terminal, editor reads plain lines.
+type editor struct {
+	r        *bufio.Reader
+	out      io.Writer
+	complete func(line string) (string, []string)
+	restore  func() // nil if not in raw mode
+}
+
+func newEditor(in *os.File, out io.Writer, complete func(string) (string, []string)) *editor {
+	restore, err := makeRaw(in.Fd())
+	if err != nil {
+		restore = nil
+	}
+	return &editor{bufio.NewReader(in), out, complete, restore}
+}
+
+func (ed *editor) close() {
+	if ed.restore != nil {
+		ed.restore()
+	}
+}
+
+const (
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyBackspace = 8
+	keyTab       = 9
+	keyCtrlU     = 21
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+func (ed *editor) readLine(prompt string) (string, error) {
+
+	fmt.Fprint(ed.out, prompt)
+
+	if ed.restore == nil {
+		line, err := ed.r.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+
+	var buf []byte
+	redraw := func() {
+		fmt.Fprintf(ed.out, "\r\x1b[K%s%s", prompt, buf)
+	}
+
+	for {
+		b, err := ed.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		switch b {
+
+		case '\r', '\n':
+			fmt.Fprint(ed.out, "\n")
+			return string(buf), nil
+
+		case keyCtrlC:
+			fmt.Fprint(ed.out, "^C\n")
+			buf = buf[:0]
+			fmt.Fprint(ed.out, prompt)
+
+		case keyCtrlD:
+			if len(buf) == 0 {
+				fmt.Fprint(ed.out, "\n")
+				return "", io.EOF
+			}
+
+		case keyCtrlU:
+			buf = buf[:0]
+			redraw()
+
+		case keyBackspace, keyDelete:
+			if len(buf) > 0 {
+				_, n := utf8.DecodeLastRune(buf)
+				buf = buf[:len(buf)-n]
+				redraw()
+			}
+
+		case keyTab:
+			line, cands := ed.complete(string(buf))
+			if len(cands) > 0 {
+				fmt.Fprintf(ed.out, "\n%s\n", strings.Join(cands, "  "))
+			}
+			buf = append(buf[:0], line...)
+			redraw()
+
+		case keyEscape:
+			// skip CSI sequences, e.g. ESC [ A for the up arrow
+			if next, err := ed.r.ReadByte(); err == nil && next == '[' {
+				for {
+					c, err := ed.r.ReadByte()
+					if err != nil || (c >= 0x40 && c <= 0x7e) {
+						break
+					}
+				}
+			}
+
+		default:
+			if b >= ' ' {
+				buf = append(buf, b)
+				ed.out.Write([]byte{b})
+			}
+		}
+	}
+}
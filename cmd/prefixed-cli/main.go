@@ -0,0 +1,116 @@
+// Command prefixed-cli talks to a prefixed-server over RESP.
+//
+//	prefixed-cli [flags]                          REPL, or pipeline mode if stdin is not a terminal
+//	prefixed-cli [flags] export [-prefix p] [file] write a snapshot, to stdout by default
+//	prefixed-cli [flags] import [file]             restore a snapshot, from stdin by default
+//
+// The REPL completes command names and keys upon tab. In pipeline mode,
+// forced with -json, commands are read from stdin, one per line, and their
+// results written to stdout as JSON lines. Snapshots use the format of
+// package engine, see engine.SnapshotHeader, but carry no row metadata since
+// RESP has none; the HTTP frontend's /v1/snapshot keeps it.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// errFailed is returned when some commands failed and were already reported.
+var errFailed = errors.New("some commands failed")
+
+func main() {
+
+	fs := flag.NewFlagSet("prefixed-cli", flag.ExitOnError)
+	fs.Usage = usage(fs)
+	if err := run(fs, os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		if err != errFailed {
+			fmt.Fprintln(os.Stderr, "prefixed-cli:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func usage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprint(fs.Output(), `usage:
+  prefixed-cli [flags]                           REPL, or pipeline mode if stdin is not a terminal
+  prefixed-cli [flags] export [-prefix p] [file] write a snapshot, to stdout by default
+  prefixed-cli [flags] import [file]             restore a snapshot, from stdin by default
+
+flags:
+`)
+		fs.PrintDefaults()
+	}
+}
+
+func run(fs *flag.FlagSet, args []string, stdin *os.File, stdout io.Writer) error {
+
+	addr := fs.String("addr", "127.0.0.1:6379", "`address` of the server's RESP frontend")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of each command")
+	jsonMode := fs.Bool("json", false, "pipeline mode even if stdin is a terminal")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := dial(*addr, *timeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	switch fs.Arg(0) {
+
+	case "":
+		if *jsonMode || !isTerminal(stdin.Fd()) {
+			return pipeline(c, stdin, stdout)
+		}
+		return repl(c, *addr, stdin, stdout)
+
+	case "export":
+		efs := flag.NewFlagSet("export", flag.ContinueOnError)
+		prefix := efs.String("prefix", "", "only export keys having `prefix`")
+		if err := efs.Parse(fs.Args()[1:]); err != nil {
+			return err
+		}
+
+		w := stdout
+		if name := efs.Arg(0); name != "" && name != "-" {
+			f, err := os.Create(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+
+		n, err := export(c, *prefix, w)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "exported %d rows\n", n)
+		return nil
+
+	case "import":
+		var r io.Reader = stdin
+		if name := fs.Arg(1); name != "" && name != "-" {
+			f, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+
+		n, err := restore(c, r)
+		fmt.Fprintf(os.Stderr, "imported %d rows\n", n)
+		return err
+	}
+
+	fs.Usage()
+	return fmt.Errorf("unknown subcommand %q", fs.Arg(0))
+}
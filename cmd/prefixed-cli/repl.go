@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxCompletions is the number of keys fetched to complete a key prefix.
+const maxCompletions = 50
+
+// repl runs commands typed on the terminal in until quit, exit or EOF, with
+// tab completion of command names and keys if in is a terminal.
+func repl(c *conn, addr string, in *os.File, out io.Writer) error {
+
+	prompt := addr + "> "
+	ed := newEditor(in, out, func(line string) (string, []string) {
+		return complete(line, func(prefix string) []string {
+			ks, err := scanKeys(c, prefix, maxCompletions)
+			if err != nil {
+				return nil
+			}
+			return ks
+		})
+	})
+	defer ed.close()
+
+	for {
+		line, err := ed.readLine(prompt)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		args, err := splitArgs(line)
+		if err != nil {
+			fmt.Fprintln(out, "(error)", err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if cmd := strings.ToLower(args[0]); cmd == "quit" || cmd == "exit" {
+			return nil
+		}
+
+		v, err := exec(c, args)
+		if err != nil {
+			fmt.Fprintln(out, "(error)", err)
+			if isNetErr(err) {
+				return err
+			}
+			continue
+		}
+		fmt.Fprintln(out, format(v))
+	}
+}
+
+// pipeline runs the commands read from in, one per line, and writes one JSON
+// object per command to out: {"result": ...} or {"error": "..."}. It stops at
+// the first network error and returns errFailed if any command failed.
+func pipeline(c *conn, in io.Reader, out io.Writer) error {
+
+	type result struct {
+		Result interface{} `json:"result"`
+	}
+	type failure struct {
+		Error string `json:"error"`
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	var failed bool
+
+	sc := bufio.NewScanner(in)
+	sc.Buffer(nil, 64*1024*1024)
+	for sc.Scan() {
+
+		args, err := splitArgs(sc.Text())
+		if err == nil && len(args) == 0 {
+			continue
+		}
+
+		var v interface{}
+		if err == nil {
+			v, err = exec(c, args)
+		}
+
+		if err != nil {
+			failed = true
+			if err := enc.Encode(failure{err.Error()}); err != nil {
+				return err
+			}
+			if isNetErr(err) {
+				return err
+			}
+			continue
+		}
+		if err := enc.Encode(result{v}); err != nil {
+			return err
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if failed {
+		return errFailed
+	}
+	return nil
+}
+
+// complete completes the last word of line, a command name if it is the
+// first word, a key otherwise. It returns the completed line and, if the
+// word is ambiguous, the candidates.
+func complete(line string, keys func(prefix string) []string) (string, []string) {
+
+	start := strings.LastIndexAny(line, " \t") + 1
+	word := line[start:]
+
+	var cands []string
+	if start == 0 || strings.TrimSpace(line[:start]) == "" {
+		for name := range commands {
+			if strings.HasPrefix(name, word) {
+				cands = append(cands, name)
+			}
+		}
+		sort.Strings(cands)
+	} else if !strings.HasPrefix(word, "\"") && !strings.HasPrefix(word, "'") {
+		cands = keys(word)
+	}
+
+	switch len(cands) {
+	case 0:
+		return line, nil
+	case 1:
+		return line[:start] + quoteArg(cands[0]) + " ", nil
+	}
+
+	common := cands[0]
+	for _, c := range cands[1:] {
+		for !strings.HasPrefix(c, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(common) > len(word) && quoteArg(common) == common {
+		return line[:start] + common, nil
+	}
+	return line, cands
+}
+
+// quoteArg quotes s if splitArgs would split or unquote it.
+func quoteArg(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\"'\\") || !utf8.ValidString(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/wv0m56/prefixed/engine"
+)
+
+// batchSize is the number of rows exported or imported per pipeline.
+const batchSize = 100
+
+// export writes the rows having prefix to w in the snapshot format of package
+// engine, and returns their number. Rows are read with GET, so a row removed
+// between listing and reading it is filled from origin. RESP carries no row
+// metadata, so exported rows have none; the HTTP frontend's /v1/snapshot keeps
+// it.
+func export(c *conn, prefix string, w io.Writer) (int, error) {
+
+	keys, err := scanKeys(c, prefix, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	sw, err := engine.NewSnapshotWriter(w, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for len(keys) > 0 {
+
+		batch := keys
+		if len(batch) > batchSize {
+			batch = batch[:batchSize]
+		}
+		keys = keys[len(batch):]
+
+		cmds := make([][]string, 0, 2*len(batch))
+		for _, k := range batch {
+			cmds = append(cmds, []string{"GET", k}, []string{"PTTL", k})
+		}
+		rs, err := c.pipeline(cmds)
+		if err != nil {
+			return n, err
+		}
+		now := time.Now()
+
+		for i, k := range batch {
+
+			val, pttl := rs[2*i], rs[2*i+1]
+			if e, ok := val.(replyError); ok {
+				return n, fmt.Errorf("%s: %v", k, e)
+			}
+			b, ok := val.([]byte)
+			ms, _ := pttl.(int64)
+			if !ok || ms == -2 {
+				continue // gone meanwhile
+			}
+
+			row := engine.SnapshotRow{Key: k, Val: b}
+			if ms >= 0 {
+				exp := now.Add(time.Duration(ms) * time.Millisecond)
+				row.Expires = &exp
+			}
+			if err := sw.Write(&row); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+
+	return n, sw.Flush()
+}
+
+// restore writes the rows of the snapshot in r with SET, skipping expired
+// rows, and returns the number of rows written. Row metadata in the snapshot
+// is dropped.
+func restore(c *conn, r io.Reader) (int, error) {
+
+	sr, err := engine.NewSnapshotReader(r)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for done := false; !done; {
+
+		var cmds [][]string
+		for len(cmds) < batchSize {
+
+			row, err := sr.Read()
+			if err == io.EOF {
+				done = true
+				break
+			} else if err != nil {
+				return n, err
+			}
+
+			cmd := []string{"SET", row.Key, string(row.Val)}
+			if row.Expires != nil {
+				ms := int64(time.Until(*row.Expires) / time.Millisecond)
+				if ms <= 0 {
+					continue
+				}
+				cmd = append(cmd, "PX", strconv.FormatInt(ms, 10))
+			}
+			cmds = append(cmds, cmd)
+		}
+
+		if len(cmds) == 0 {
+			break
+		}
+		rs, err := c.pipeline(cmds)
+		if err != nil {
+			return n, err
+		}
+		for i, r := range rs {
+			if e, ok := r.(replyError); ok {
+				return n, fmt.Errorf("%s: %v", cmds[i][1], e)
+			}
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// makeRaw is only implemented on Linux, elsewhere the REPL reads whole lines
+// without completion.
+func makeRaw(fd uintptr) (func(), error) {
+	return nil, errors.New("raw terminal mode not supported")
+}
+
+func isTerminal(fd uintptr) bool {
+	return false
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/wv0m56/prefixed/engine"
+	"github.com/wv0m56/prefixed/plugin/origin"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+	"github.com/wv0m56/prefixed/plugin/origin/resilience"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix starts the environment variables overriding configuration
+// settings, e.g. PREFIXED_ENGINE_CACHE_FILL_TIMEOUT=500ms overrides
+// engine.cache_fill_timeout. Lists are comma separated.
+const envPrefix = "PREFIXED"
+
+// Config is the content of the configuration file. Durations are strings
+// such as "250ms" or numbers of seconds.
+type Config struct {
+	Engine          EngineConfig     `json:"engine"`
+	Origin          OriginConfig     `json:"origin"`
+	Frontends       []FrontendConfig `json:"frontends"`
+	MetricsAddr     string           `json:"metrics_addr"`
+	ShutdownTimeout Duration         `json:"shutdown_timeout"`
+}
+
+// EngineConfig mirrors engine.Options, see there.
+type EngineConfig struct {
+	ExpectedLen                int64           `json:"expected_len"`
+	EvictPolicyRelevanceWindow Duration        `json:"evict_policy_relevance_window"`
+	EvictPolicyTickStep        Duration        `json:"evict_policy_tick_step"`
+	TtlTickStep                Duration        `json:"ttl_tick_step"`
+	CacheFillTimeout           Duration        `json:"cache_fill_timeout"`
+	MaxPayloadTotalSize        int64           `json:"max_payload_total_size"`
+	TTLRules                   []TTLRuleConfig `json:"ttl_rules"`
+	TTLIndex                   string          `json:"ttl_index"` // "duplist" or "wheel"
+	ExpiryJitter               Duration        `json:"expiry_jitter"`
+	ExpiryJitterRatio          float64         `json:"expiry_jitter_ratio"`
+	TtlMaxDeletesPerTick       int             `json:"ttl_max_deletes_per_tick"`
+	WatchBufferSize            int             `json:"watch_buffer_size"`
+	WatchDropPolicy            string          `json:"watch_drop_policy"` // "newest" or "oldest"
+	StatsPrefixClasses         []string        `json:"stats_prefix_classes"`
+	TopKeysCapacity            int             `json:"top_keys_capacity"`
+	KeyDelimiter               string          `json:"key_delimiter"`
+	TopPrefixesMaxDepth        int             `json:"top_prefixes_max_depth"`
+	DistinctMaxPrefixes        int             `json:"distinct_max_prefixes"`
+	DistinctMaxDepth           int             `json:"distinct_max_depth"`
+}
+
+// TTLRuleConfig mirrors engine.TTLRule.
+type TTLRuleConfig struct {
+	Prefix  string   `json:"prefix"`
+	Default Duration `json:"default"`
+	Min     Duration `json:"min"`
+	Max     Duration `json:"max"`
+}
+
+// OriginConfig selects the origin and the resilience middlewares wrapping
+// it. Zero values disable a middleware.
+type OriginConfig struct {
+
+	// Kind is one of "nodelay", "delayed", "random", "zeroes" and "replay",
+	// see package fake. "replay" replays the recording file ReplayFile,
+	// with the recorded latencies if ReplayLatency.
+	Kind          string `json:"kind"`
+	ReplayFile    string `json:"replay_file"`
+	ReplayLatency bool   `json:"replay_latency"`
+
+	MaxInFlight      int      `json:"max_in_flight"`
+	BreakerThreshold int      `json:"breaker_threshold"`
+	BreakerCooldown  Duration `json:"breaker_cooldown"`
+	RetryAttempts    int      `json:"retry_attempts"`
+	RetryBaseDelay   Duration `json:"retry_base_delay"`
+	RetryMaxDelay    Duration `json:"retry_max_delay"`
+}
+
+// FrontendConfig is a frontend registered in package client, e.g. "resp",
+// listening on Addr.
+type FrontendConfig struct {
+	Name string `json:"name"`
+	Addr string `json:"addr"`
+}
+
+// Duration is a time.Duration read from a string such as "1m30s", or from a
+// number of seconds.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		v, err := time.ParseDuration(s)
+		*d = Duration(v)
+		return err
+	}
+
+	var f float64
+	if err := json.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	*d = Duration(f * float64(time.Second))
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// defaultConfig holds engine.OptionsDefault and serves RESP on port 6379.
+func defaultConfig() *Config {
+
+	o := engine.OptionsDefault
+	return &Config{
+		EngineConfig{
+			ExpectedLen:                o.ExpectedLen,
+			EvictPolicyRelevanceWindow: Duration(o.EvictPolicyRelevanceWindow),
+			EvictPolicyTickStep:        Duration(o.EvictPolicyTickStep),
+			TtlTickStep:                Duration(o.TtlTickStep),
+			CacheFillTimeout:           Duration(o.CacheFillTimeout),
+			MaxPayloadTotalSize:        o.MaxPayloadTotalSize,
+			TTLIndex:                   "duplist",
+			WatchBufferSize:            o.WatchBufferSize,
+			WatchDropPolicy:            "newest",
+			TopKeysCapacity:            o.TopKeysCapacity,
+			KeyDelimiter:               o.KeyDelimiter,
+			TopPrefixesMaxDepth:        o.TopPrefixesMaxDepth,
+			DistinctMaxPrefixes:        o.DistinctMaxPrefixes,
+			DistinctMaxDepth:           o.DistinctMaxDepth,
+		},
+		OriginConfig{
+			Kind:            "nodelay",
+			BreakerCooldown: Duration(time.Second),
+			RetryBaseDelay:  Duration(10 * time.Millisecond),
+			RetryMaxDelay:   Duration(100 * time.Millisecond),
+		},
+		[]FrontendConfig{{"resp", ":6379"}},
+		"",
+		Duration(30 * time.Second),
+	}
+}
+
+// loadConfig reads the file at path over defaultConfig, its format being
+// given by its extension: .json, .yaml, .yml or .toml. An empty path means
+// no file. Environment variables override the result, see envPrefix.
+func loadConfig(path string) (*Config, error) {
+
+	c := defaultConfig()
+
+	if path != "" {
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var doc interface{}
+		switch ext := strings.ToLower(filepath.Ext(path)); ext {
+		case ".json":
+			err = json.Unmarshal(b, &doc)
+		case ".yaml", ".yml":
+			err = yaml.Unmarshal(b, &doc)
+		case ".toml":
+			var m map[string]interface{}
+			err = toml.Unmarshal(b, &m)
+			doc = m
+		default:
+			err = fmt.Errorf("unknown configuration format %q", ext)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		// decode through JSON whatever the format, so that the json tags
+		// apply and unknown settings are caught
+		if b, err = json.Marshal(doc); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(c); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(c).Elem(), envPrefix, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// applyEnv overrides the scalar and []string fields of the struct v with the
+// variables named prefix, an underscore and the upper cased json tag of the
+// field, recursively.
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+		f := v.Field(i)
+
+		if f.Kind() == reflect.Struct {
+			if err := applyEnv(f, name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setField(f, s); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(f reflect.Value, s string) error {
+
+	if f.Type() == durationType {
+		var d Duration
+		if err := d.UnmarshalJSON([]byte(strconv.Quote(s))); err != nil {
+			return err
+		}
+		f.Set(reflect.ValueOf(d))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Float64:
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(x)
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.String {
+			return errors.New("can't be set from the environment")
+		}
+		var ss []string
+		if s != "" {
+			ss = strings.Split(s, ",")
+		}
+		f.Set(reflect.ValueOf(ss))
+	default:
+		return errors.New("can't be set from the environment")
+	}
+	return nil
+}
+
+// options returns the engine options of c, without origin.
+func (c *EngineConfig) options() (engine.Options, error) {
+
+	o := engine.Options{
+		ExpectedLen:                c.ExpectedLen,
+		EvictPolicyRelevanceWindow: time.Duration(c.EvictPolicyRelevanceWindow),
+		EvictPolicyTickStep:        time.Duration(c.EvictPolicyTickStep),
+		TtlTickStep:                time.Duration(c.TtlTickStep),
+		CacheFillTimeout:           time.Duration(c.CacheFillTimeout),
+		MaxPayloadTotalSize:        c.MaxPayloadTotalSize,
+		ExpiryJitter:               time.Duration(c.ExpiryJitter),
+		ExpiryJitterRatio:          c.ExpiryJitterRatio,
+		TtlMaxDeletesPerTick:       c.TtlMaxDeletesPerTick,
+		WatchBufferSize:            c.WatchBufferSize,
+		StatsPrefixClasses:         c.StatsPrefixClasses,
+		TopKeysCapacity:            c.TopKeysCapacity,
+		KeyDelimiter:               c.KeyDelimiter,
+		TopPrefixesMaxDepth:        c.TopPrefixesMaxDepth,
+		DistinctMaxPrefixes:        c.DistinctMaxPrefixes,
+		DistinctMaxDepth:           c.DistinctMaxDepth,
+	}
+
+	for _, r := range c.TTLRules {
+		o.TTLRules = append(o.TTLRules, engine.TTLRule{
+			Prefix:  r.Prefix,
+			Default: time.Duration(r.Default),
+			Min:     time.Duration(r.Min),
+			Max:     time.Duration(r.Max),
+		})
+	}
+
+	switch c.TTLIndex {
+	case "", "duplist":
+		o.TTLIndex = engine.TTLIndexDuplist
+	case "wheel":
+		o.TTLIndex = engine.TTLIndexWheel
+	default:
+		return o, fmt.Errorf("unknown TTL index %q", c.TTLIndex)
+	}
+
+	switch c.WatchDropPolicy {
+	case "", "newest":
+		o.WatchDropPolicy = engine.DropNewest
+	case "oldest":
+		o.WatchDropPolicy = engine.DropOldest
+	default:
+		return o, fmt.Errorf("unknown watch drop policy %q", c.WatchDropPolicy)
+	}
+
+	return o, nil
+}
+
+// origin returns the origin of c wrapped in its middlewares, outermost
+// first: retries, circuit breaker, in-flight limit.
+func (c *OriginConfig) origin() (origin.Origin, error) {
+
+	var o origin.Origin
+	switch c.Kind {
+	case "nodelay":
+		o = &fake.NoDelayOrigin{}
+	case "delayed":
+		o = &fake.DelayedOrigin{}
+	case "random":
+		o = &fake.RandomOrigin{}
+	case "zeroes":
+		o = &fake.ZeroesPayloadOrigin{}
+	case "replay":
+		f, err := os.Open(c.ReplayFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if o, err = fake.NewReplayer(f, c.ReplayLatency); err != nil {
+			return nil, fmt.Errorf("%s: %v", c.ReplayFile, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown origin kind %q", c.Kind)
+	}
+
+	if c.BreakerThreshold > 0 && c.BreakerCooldown <= 0 {
+		return nil, errors.New("breaker cooldown must be positive")
+	}
+	if c.RetryAttempts > 1 && (c.RetryBaseDelay <= 0 || c.RetryBaseDelay > c.RetryMaxDelay) {
+		return nil, errors.New("retry delays must be positive and retry_base_delay <= retry_max_delay")
+	}
+
+	if c.MaxInFlight > 0 {
+		o = resilience.NewLimiter(o, c.MaxInFlight)
+	}
+	if c.BreakerThreshold > 0 {
+		o = resilience.NewCircuitBreaker(o, c.BreakerThreshold, time.Duration(c.BreakerCooldown))
+	}
+	if c.RetryAttempts > 1 {
+		o = resilience.NewRetry(o, c.RetryAttempts,
+			time.Duration(c.RetryBaseDelay), time.Duration(c.RetryMaxDelay))
+	}
+	return o, nil
+}
+
+// changed returns the json names of the settings differing between c and
+// next, e.g. "engine.ttl_tick_step".
+func (c *Config) changed(next *Config) []string {
+	return diffFields(reflect.ValueOf(c).Elem(), reflect.ValueOf(next).Elem(), "")
+}
+
+func diffFields(a, b reflect.Value, prefix string) []string {
+
+	var names []string
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+
+		name := prefix + strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		fa, fb := a.Field(i), b.Field(i)
+
+		if fa.Kind() == reflect.Struct && fa.Type() != durationType {
+			names = append(names, diffFields(fa, fb, name+".")...)
+		} else if !reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
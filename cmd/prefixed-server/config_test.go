@@ -0,0 +1,173 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/engine"
+	"github.com/wv0m56/prefixed/plugin/origin/resilience"
+)
+
+var configs = map[string]string{
+
+	"json": `{
+  "engine": {
+    "cache_fill_timeout": "500ms",
+    "max_payload_total_size": 20000000,
+    "ttl_index": "wheel",
+    "stats_prefix_classes": ["user:", "session:"],
+    "ttl_rules": [{"prefix": "user:", "default": 60, "max": "1h"}]
+  },
+  "origin": {"kind": "delayed", "retry_attempts": 3},
+  "frontends": [{"name": "resp", "addr": ":7000"}, {"name": "http", "addr": ":7001"}]
+}`,
+
+	"yaml": `
+engine:
+  cache_fill_timeout: 500ms
+  max_payload_total_size: 20000000
+  ttl_index: wheel
+  stats_prefix_classes: [user:, session:]
+  ttl_rules:
+    - prefix: "user:"
+      default: 60
+      max: 1h
+origin:
+  kind: delayed
+  retry_attempts: 3
+frontends:
+  - name: resp
+    addr: ":7000"
+  - name: http
+    addr: ":7001"
+`,
+
+	"toml": `
+# comment
+origin.kind = "delayed"
+origin.retry_attempts = 3
+
+[engine]
+cache_fill_timeout = "500ms"  # trailing comment
+max_payload_total_size = 20_000_000
+ttl_index = 'wheel'
+stats_prefix_classes = [
+  "user:",
+  "session:",
+]
+
+[[engine.ttl_rules]]
+prefix = "user:"
+default = 60
+max = "1h"
+
+[[frontends]]
+name = "resp"
+addr = ":7000"
+
+[[frontends]]
+name = "http"
+addr = ":7001"
+`,
+}
+
+func writeConfig(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "prefixed")
+	assert.Nil(t, err)
+	path := filepath.Join(dir, name)
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+
+	for format, content := range configs {
+
+		path := writeConfig(t, "prefixed."+format, content)
+		defer os.RemoveAll(filepath.Dir(path))
+
+		c, err := loadConfig(path)
+		if !assert.Nil(t, err, format) {
+			continue
+		}
+
+		opts, err := c.Engine.options()
+		assert.Nil(t, err)
+		assert.Equal(t, 500*time.Millisecond, opts.CacheFillTimeout, format)
+		assert.Equal(t, int64(20*1000*1000), opts.MaxPayloadTotalSize, format)
+		assert.Equal(t, engine.TTLIndexWheel, opts.TTLIndex, format)
+		assert.Equal(t, []string{"user:", "session:"}, opts.StatsPrefixClasses, format)
+		assert.Equal(t, []engine.TTLRule{{Prefix: "user:", Default: time.Minute, Max: time.Hour}}, opts.TTLRules, format)
+
+		// defaults are kept
+		assert.Equal(t, engine.OptionsDefault.TtlTickStep, opts.TtlTickStep, format)
+		assert.Equal(t, 30*time.Second, time.Duration(c.ShutdownTimeout), format)
+
+		assert.Equal(t, []FrontendConfig{{"resp", ":7000"}, {"http", ":7001"}}, c.Frontends, format)
+		o, err := c.Origin.origin()
+		assert.Nil(t, err)
+		assert.IsType(t, &resilience.Retry{}, o, format)
+	}
+
+	// no file
+	c, err := loadConfig("")
+	assert.Nil(t, err)
+	assert.Equal(t, defaultConfig(), c)
+
+	// typos and bad values are errors
+	for name, content := range map[string]string{
+		"unknown.json":  `{"engine": {"cache_fil_timeout": "1s"}}`,
+		"duration.yaml": "shutdown_timeout: soon",
+		"syntax.toml":   "[engine\nx = 1",
+		"value.toml":    "metrics_addr = localhost",
+		"format.ini":    "",
+	} {
+		path := writeConfig(t, name, content)
+		defer os.RemoveAll(filepath.Dir(path))
+		_, err := loadConfig(path)
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+
+	env := map[string]string{
+		"PREFIXED_ENGINE_CACHE_FILL_TIMEOUT":   "2s",
+		"PREFIXED_ENGINE_EXPECTED_LEN":         "4096",
+		"PREFIXED_ENGINE_EXPIRY_JITTER_RATIO":  "0.1",
+		"PREFIXED_ENGINE_STATS_PREFIX_CLASSES": "a:,b:",
+		"PREFIXED_ORIGIN_REPLAY_LATENCY":       "true",
+		"PREFIXED_METRICS_ADDR":                ":9100",
+	}
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+
+	c := defaultConfig()
+	assert.Nil(t, applyEnv(reflect.ValueOf(c).Elem(), envPrefix, lookup))
+	assert.Equal(t, Duration(2*time.Second), c.Engine.CacheFillTimeout)
+	assert.Equal(t, int64(4096), c.Engine.ExpectedLen)
+	assert.Equal(t, 0.1, c.Engine.ExpiryJitterRatio)
+	assert.Equal(t, []string{"a:", "b:"}, c.Engine.StatsPrefixClasses)
+	assert.True(t, c.Origin.ReplayLatency)
+	assert.Equal(t, ":9100", c.MetricsAddr)
+
+	env["PREFIXED_ENGINE_EXPECTED_LEN"] = "lots"
+	assert.NotNil(t, applyEnv(reflect.ValueOf(defaultConfig()).Elem(), envPrefix, lookup))
+
+	// changes are listed by name
+	assert.Equal(t, []string{
+		"engine.expected_len",
+		"engine.cache_fill_timeout",
+		"engine.expiry_jitter_ratio",
+		"engine.stats_prefix_classes",
+		"origin.replay_latency",
+		"metrics_addr",
+	}, defaultConfig().changed(c))
+}
@@ -0,0 +1,193 @@
+// Command prefixed-server runs a cache engine behind the frontends of its
+// configuration file.
+//
+//	prefixed-server -config /etc/prefixed.toml
+//
+// The file is JSON, YAML or TOML, see Config. Environment variables
+// override its settings, e.g. PREFIXED_ORIGIN_KIND=delayed.
+//
+// SIGTERM and SIGINT stop accepting connections, let the frontends finish
+// the requests in flight for up to shutdown_timeout, then close the engine.
+// SIGHUP reloads the configuration file, applying the engine options which
+// can change at runtime, see engine.OptionsUpdate.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/wv0m56/prefixed/engine"
+	"github.com/wv0m56/prefixed/metrics"
+	"github.com/wv0m56/prefixed/plugin/client"
+
+	// frontends, registered in package client
+	_ "github.com/wv0m56/prefixed/plugin/client/httpapi"
+	_ "github.com/wv0m56/prefixed/plugin/client/memcache"
+	_ "github.com/wv0m56/prefixed/plugin/client/resp"
+)
+
+func main() {
+
+	path := flag.String("config", "", "configuration `file` (.json, .yaml, .yml or .toml)")
+	flag.Parse()
+
+	log.SetPrefix("prefixed-server: ")
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+
+	if err := run(*path, sigs); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves until a termination signal arrives on sigs or a frontend fails.
+func run(path string, sigs <-chan os.Signal) error {
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	opts, err := cfg.Engine.options()
+	if err != nil {
+		return err
+	}
+	if opts.O, err = cfg.Origin.origin(); err != nil {
+		return err
+	}
+	e, err := engine.NewEngine(&opts)
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+
+	if len(cfg.Frontends) == 0 {
+		return fmt.Errorf("no frontend configured, available: %s",
+			strings.Join(client.Registered(), ", "))
+	}
+	g := &client.Group{}
+	for _, f := range cfg.Frontends {
+		p, err := client.New(f.Name, f.Addr)
+		if err != nil {
+			return err
+		}
+		g.Add(f.Name+"@"+f.Addr, p)
+		log.Printf("serving %s on %s", f.Name, f.Addr)
+	}
+
+	var ms *http.Server
+	if cfg.MetricsAddr != "" {
+		ln, err := net.Listen("tcp", cfg.MetricsAddr)
+		if err != nil {
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.NewHandler(e))
+		ms = &http.Server{Handler: mux}
+		go ms.Serve(ln)
+		defer ms.Close()
+		log.Printf("serving metrics on %s/metrics", cfg.MetricsAddr)
+	}
+
+	log.Printf("started, origin %s, max payload %d bytes, fill timeout %v",
+		cfg.Origin.Kind, opts.MaxPayloadTotalSize, opts.CacheFillTimeout)
+
+	errc := make(chan error, 1)
+	go func() { errc <- g.Serve(context.Background(), e) }()
+
+	for {
+		select {
+
+		case err := <-errc:
+			return err
+
+		case sig := <-sigs:
+
+			if sig == syscall.SIGHUP {
+				if next, err := reload(path, cfg, e); err != nil {
+					log.Printf("reload failed, keeping the current configuration: %v", err)
+				} else {
+					cfg = next
+				}
+				continue
+			}
+
+			log.Printf("%v, draining for up to %v", sig, time.Duration(cfg.ShutdownTimeout))
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+			err := g.Shutdown(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("drain cut short: %v", err)
+			}
+			<-errc
+
+			// waits for the cache fills of the requests cut short
+			e.Close()
+			log.Print("stopped")
+			return nil
+		}
+	}
+}
+
+// reload reads the configuration at path anew and applies the tunable engine
+// options to e. Other settings are only read at startup, their changes are
+// logged and left aside. It returns the configuration in effect.
+func reload(path string, cur *Config, e *engine.Engine) (*Config, error) {
+
+	next, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := next.Engine.options(); err != nil {
+		return nil, err
+	}
+
+	applied := *cur
+	var u engine.OptionsUpdate
+	var restart []string
+	for _, name := range cur.changed(next) {
+		switch name {
+		case "engine.max_payload_total_size":
+			u.MaxPayloadTotalSize = next.Engine.MaxPayloadTotalSize
+			applied.Engine.MaxPayloadTotalSize = next.Engine.MaxPayloadTotalSize
+		case "engine.cache_fill_timeout":
+			u.CacheFillTimeout = time.Duration(next.Engine.CacheFillTimeout)
+			applied.Engine.CacheFillTimeout = next.Engine.CacheFillTimeout
+		case "engine.ttl_tick_step":
+			u.TtlTickStep = time.Duration(next.Engine.TtlTickStep)
+			applied.Engine.TtlTickStep = next.Engine.TtlTickStep
+		case "engine.evict_policy_tick_step":
+			u.EvictPolicyTickStep = time.Duration(next.Engine.EvictPolicyTickStep)
+			applied.Engine.EvictPolicyTickStep = next.Engine.EvictPolicyTickStep
+		case "engine.evict_policy_relevance_window":
+			u.EvictPolicyRelevanceWindow = time.Duration(next.Engine.EvictPolicyRelevanceWindow)
+			applied.Engine.EvictPolicyRelevanceWindow = next.Engine.EvictPolicyRelevanceWindow
+		case "shutdown_timeout":
+			applied.ShutdownTimeout = next.ShutdownTimeout
+		default:
+			restart = append(restart, name)
+			continue
+		}
+		log.Printf("applying %s", name)
+	}
+
+	if u != (engine.OptionsUpdate{}) {
+		if err := e.UpdateOptions(u); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range restart {
+		log.Printf("%s changed, restart to apply", name)
+	}
+	return &applied, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/engine"
+)
+
+func TestRun(t *testing.T) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	path := writeConfig(t, "prefixed.json", fmt.Sprintf(
+		`{"frontends": [{"name": "resp", "addr": %q}], "shutdown_timeout": 1}`, addr))
+	defer os.RemoveAll(filepath.Dir(path))
+
+	sigs := make(chan os.Signal)
+	errc := make(chan error)
+	go func() { errc <- run(path, sigs) }()
+
+	var nc net.Conn
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if nc, err = net.Dial("tcp", addr); err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer nc.Close()
+
+	_, err = nc.Write([]byte("PING\r\n"))
+	assert.Nil(t, err)
+	line, err := bufio.NewReader(nc).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "+PONG\r\n", line)
+
+	sigs <- syscall.SIGHUP
+	sigs <- syscall.SIGTERM
+	select {
+	case err := <-errc:
+		assert.Nil(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("run didn't return")
+	}
+}
+
+func TestReload(t *testing.T) {
+
+	cur, err := loadConfig("")
+	assert.Nil(t, err)
+	opts, err := cur.Engine.options()
+	assert.Nil(t, err)
+	e, err := engine.NewEngine(&opts)
+	assert.Nil(t, err)
+	defer e.Close()
+
+	path := writeConfig(t, "prefixed.yaml", `
+engine:
+  cache_fill_timeout: 1s
+  ttl_tick_step: 1s
+  expected_len: 4096
+shutdown_timeout: 5s
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	next, err := reload(path, cur, e)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second, e.Options().CacheFillTimeout)
+	assert.Equal(t, time.Second, e.Options().TtlTickStep)
+	assert.Equal(t, Duration(5*time.Second), next.ShutdownTimeout)
+
+	// needs a restart
+	assert.Equal(t, opts.ExpectedLen, e.Options().ExpectedLen)
+	assert.Equal(t, []string{"engine.expected_len"}, next.changed(mustLoad(t, path)))
+
+	// invalid options are rejected as a whole
+	bad := writeConfig(t, "prefixed.yaml", `
+engine:
+  cache_fill_timeout: 2s
+  ttl_tick_step: 1ns
+`)
+	defer os.RemoveAll(filepath.Dir(bad))
+	_, err = reload(bad, next, e)
+	assert.NotNil(t, err)
+	assert.Equal(t, time.Second, e.Options().CacheFillTimeout)
+}
+
+func mustLoad(t *testing.T, path string) *Config {
+	c, err := loadConfig(path)
+	assert.Nil(t, err)
+	return c
+}
@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"hash"
+	"hash/fnv"
+	"time"
+
+	"github.com/tylertreat/BoomFilters"
+)
+
+const (
+	// distinctGenerations is the number of HyperLogLog generations making up
+	// the relevance window. Estimates cover between 3/4 of the window and the
+	// whole of it.
+	distinctGenerations = 4
+
+	// distinctRegisters gives a standard error of about 2.3% for 2KB per
+	// HyperLogLog.
+	distinctRegisters = 2048
+)
+
+// EstimateDistinct returns the estimated number of distinct keys read under
+// prefix p inside the relevance window, misses included. Only "" and prefixes
+// made of up to Options.DistinctMaxDepth segments (see TopPrefixes) are
+// tracked, others return 0.
+func (e *Engine) EstimateDistinct(p string) uint64 {
+
+	e.ep.Lock()
+	defer e.ep.Unlock()
+
+	if e.ep.distinct == nil {
+		return 0
+	}
+	return e.ep.distinct.estimate(p)
+}
+
+// distinctCounter keeps one HyperLogLog per tracked prefix per generation.
+// A new generation starts every relevanceWindow / distinctGenerations and the
+// oldest one is dropped. It is guarded by the evictPolicy lock.
+type distinctCounter struct {
+	delim       string
+	maxDepth    int
+	maxPrefixes int
+	span        time.Duration
+	started     time.Time                                         // of gens[0]
+	gens        [distinctGenerations]map[string]*boom.HyperLogLog // newest first
+}
+
+func newDistinctCounter(maxPrefixes, maxDepth int, delim string, window time.Duration, now time.Time) *distinctCounter {
+
+	if maxPrefixes == 0 {
+		return nil
+	}
+
+	dc := &distinctCounter{
+		delim:       delim,
+		maxDepth:    maxDepth,
+		maxPrefixes: maxPrefixes,
+		span:        window / distinctGenerations,
+		started:     now,
+	}
+	for i := range dc.gens {
+		dc.gens[i] = map[string]*boom.HyperLogLog{}
+	}
+	return dc
+}
+
+// add accounts a read of key under "" and its segment prefixes. Prefixes
+// beyond maxPrefixes per generation are ignored, "" is always tracked.
+func (dc *distinctCounter) add(key string) {
+
+	gen := dc.gens[0]
+	for _, p := range append([]string{""}, keySegments(key, dc.delim, dc.maxDepth)...) {
+
+		h, ok := gen[p]
+		if !ok {
+			if p != "" && len(gen) > dc.maxPrefixes {
+				continue
+			}
+			h = newHLL()
+			gen[p] = h
+		}
+		h.Add([]byte(key))
+	}
+}
+
+// rotate starts as many new generations as fit between the start of the
+// current one and now.
+func (dc *distinctCounter) rotate(now time.Time) {
+
+	for i := 0; now.Sub(dc.started) >= dc.span; i++ {
+
+		if i == distinctGenerations {
+			dc.started = now // all generations are stale anyway
+			break
+		}
+
+		copy(dc.gens[1:], dc.gens[:distinctGenerations-1])
+		dc.gens[0] = map[string]*boom.HyperLogLog{}
+		dc.started = dc.started.Add(dc.span)
+	}
+}
+
+// newHLL replaces the default FNV-1 hash, whose last step only changes the
+// low bits. Keys differing by their last characters, the common case, would
+// otherwise land on few registers.
+func newHLL() *boom.HyperLogLog {
+	h, _ := boom.NewHyperLogLog(distinctRegisters)
+	h.SetHash(mixedHash{fnv.New64a()})
+	return h
+}
+
+// mixedHash is a 64-bit FNV-1a hash going through the murmur3 finalizer, so
+// that every input bit affects the top bits picking HyperLogLog registers.
+type mixedHash struct {
+	hash.Hash64
+}
+
+func (mh mixedHash) Sum32() uint32 {
+	x := mh.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return uint32(x >> 32)
+}
+
+func (dc *distinctCounter) estimate(p string) uint64 {
+
+	merged := newHLL()
+	var found bool
+	for _, gen := range dc.gens {
+		if h, ok := gen[p]; ok {
+			_ = merged.Merge(h) // same number of registers
+			found = true
+		}
+	}
+
+	if !found {
+		return 0
+	}
+	return merged.Count()
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/clock"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+func TestEstimateDistinct(t *testing.T) {
+
+	clk := clock.NewManual(time.Now())
+	opts := OptionsDefault
+	opts.O = &fake.FaultyOrigin{O: &fake.NoDelayOrigin{}, ErrorRate: 0.5}
+	opts.EvictPolicyRelevanceWindow = 4 * time.Second
+	opts.EvictPolicyTickStep = time.Second
+	opts.Clock = clk
+	opts.TopKeysCapacity = 128 // to wait for reads to be accounted
+	opts.DistinctMaxPrefixes = 1024
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, e.ep.distinct)
+
+	near := func(want float64, got uint64) bool {
+		return math.Abs(float64(got)-want) <= 0.05*want
+	}
+
+	// failed fills count as well
+	for i := 0; i < 1000; i++ {
+		e.Get("user:" + strconv.Itoa(i))
+		e.Get("user:" + strconv.Itoa(i%10))
+	}
+	for i := 0; i < 50; i++ {
+		e.Get("org:" + strconv.Itoa(i))
+	}
+
+	// reads are accounted asynchronously
+	eventually(t, func() bool {
+		e.ep.Lock()
+		defer e.ep.Unlock()
+		return len(e.ep.listElPtr) == 1050 && e.ep.hot.prefixes[0].top(1)[0].Count >= 2000
+	})
+	assert.True(t, near(1050, e.EstimateDistinct("")))
+	assert.True(t, near(1000, e.EstimateDistinct("user:")))
+	assert.True(t, near(50, e.EstimateDistinct("org:")))
+	assert.Equal(t, uint64(0), e.EstimateDistinct("nobody:"))
+	assert.Equal(t, uint64(0), e.EstimateDistinct("user:1")) // depth 2
+
+	// forgotten once out of the relevance window
+	clk.Advance(3 * time.Second)
+	assert.True(t, near(1050, e.EstimateDistinct("")))
+	clk.Advance(time.Second)
+	eventually(t, func() bool { return e.EstimateDistinct("") == 0 })
+}
+
+// internals
+func TestDistinctCounter(t *testing.T) {
+
+	now := time.Now()
+	dc := newDistinctCounter(2, 1, ":", 4*time.Second, now)
+	assert.Nil(t, newDistinctCounter(0, 1, ":", time.Second, now))
+	assert.Equal(t, 0, OptionsDefault.DistinctMaxPrefixes) // opt-in
+
+	dc.add("a:1")
+	dc.add("b:1")
+	dc.add("c:1") // over the limit
+	dc.add("nodelim")
+	assert.Equal(t, uint64(4), dc.estimate(""))
+	assert.Equal(t, uint64(1), dc.estimate("b:"))
+	assert.Equal(t, uint64(0), dc.estimate("c:"))
+
+	// generations
+	dc.rotate(now.Add(999 * time.Millisecond))
+	dc.add("c:1")
+	assert.Equal(t, uint64(0), dc.estimate("c:"))
+	dc.rotate(now.Add(time.Second))
+	dc.add("c:1")
+	dc.add("a:2")
+	assert.Equal(t, uint64(1), dc.estimate("c:"))
+	assert.Equal(t, uint64(2), dc.estimate("a:"))
+	dc.rotate(now.Add(4 * time.Second))
+	assert.Equal(t, uint64(1), dc.estimate("a:"))
+
+	// a long pause drops everything
+	dc.rotate(now.Add(time.Hour))
+	assert.Equal(t, uint64(0), dc.estimate(""))
+	assert.Equal(t, now.Add(time.Hour), dc.started)
+}
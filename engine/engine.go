@@ -6,10 +6,14 @@ import (
 	"io"
 	"io/ioutil"
 	"math"
+	"math/rand"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tylertreat/BoomFilters"
+	"github.com/wv0m56/prefixed/clock"
 	"github.com/wv0m56/prefixed/plugin/origin"
 	"github.com/wv0m56/prefixed/plugin/origin/fake"
 	"github.com/wv0m56/prefixed/skiplist"
@@ -27,8 +31,30 @@ type Engine struct {
 	// c      client.ClientPlugin
 	timeout             time.Duration
 	maxPayloadTotalSize int64
+	clock               clock.Clock
+	ttlRules            *prefixTrie
+	expiryJitter        time.Duration
+	expiryJitterRatio   float64
+	rand                *rand.Rand
+	maxExpiredPerTick   int
+	watch               *watchHub
+	onRemove            func(key string, val []byte, reason RemovalReason)
+	removals            []removal // pending OnRemove calls, see unlock
+	stats               *engineStats
+	classes             *prefixTrie // of *classStats, read-only
+	classNames          []string
+	fills               sync.WaitGroup // in-flight firstFill calls
+	closed              bool
+	opts                Options     // as updated by UpdateOptions, guarded by tuneMu
+	tuneMu              *sync.Mutex // serializes UpdateOptions and Close
+	ttlLoop             *tickLoop
+	epLoop              *tickLoop
 }
 
+// ErrClosed is returned by Get and friends upon cache miss once the engine is
+// closed.
+var ErrClosed = errors.New("engine closed")
+
 type Options struct {
 
 	// ExpectedLen is the number of expected (k, v) rows in the cache.
@@ -47,6 +73,68 @@ type Options struct {
 	MaxPayloadTotalSize int64
 
 	O origin.Origin
+
+	// Clock is the source of time for TTL and eviction logic. nil means
+	// clock.Real. Tests can pass a *clock.Manual to control time.
+	Clock clock.Clock
+
+	// TTLRules sets default, minimum and maximum TTLs of rows filled from
+	// origin, by key prefix. See TTLRule.
+	TTLRules []TTLRule
+
+	// TTLIndex selects the data structure tracking expiries.
+	TTLIndex TTLIndexKind
+
+	// ExpiryJitter and ExpiryJitterRatio move the expiry of rows filled from
+	// origin earlier by a random duration, up to ExpiryJitter plus
+	// ExpiryJitterRatio times the TTL. This spreads the expiry of rows the
+	// origin gave an identical expiry to. Explicitly set TTLs are exact.
+	ExpiryJitter      time.Duration
+	ExpiryJitterRatio float64
+
+	// TtlMaxDeletesPerTick caps the number of expired rows deleted per TTL
+	// tick, so that a mass expiry doesn't hold the lock for long. Leftovers
+	// are deleted on the following ticks. 0 means no cap.
+	TtlMaxDeletesPerTick int
+
+	// WatchBufferSize is the number of events buffered by each subscription
+	// returned by Watch and WatchPrefix. 0 means 64. WatchDropPolicy decides
+	// which events are lost once a buffer is full.
+	WatchBufferSize int
+	WatchDropPolicy DropPolicy
+
+	// OnRemove, if not nil, is called whenever a row leaves the cache or is
+	// overwritten, with the value it had. It is called outside of the engine
+	// lock, from the goroutine which removed the row, e.g. the TTL loop.
+	// A slow OnRemove therefore delays the removing operation but doesn't
+	// block others.
+	OnRemove func(key string, val []byte, reason RemovalReason)
+
+	// StatsPrefixClasses are key prefixes whose hits and misses are counted
+	// separately in Stats.Classes, e.g. one per tenant or data type. A key
+	// counts under the longest class it has as prefix.
+	StatsPrefixClasses []string
+
+	// TopKeysCapacity is the number of most read keys and key prefixes
+	// tracked for TopKeys and TopPrefixes. Tracking costs a second count min
+	// sketch and heap updates on every read, so it is opt-in: 0, the
+	// default, disables it.
+	TopKeysCapacity int
+
+	// KeyDelimiter separates the segments of keys, e.g. ":" in
+	// "user:42:name". TopPrefixes tracks prefixes of up to
+	// TopPrefixesMaxDepth segments.
+	KeyDelimiter        string
+	TopPrefixesMaxDepth int
+
+	// DistinctMaxPrefixes is the number of key prefixes of up to
+	// DistinctMaxDepth segments whose distinct keys are estimated for
+	// EstimateDistinct. Prefixes seen once the limit is reached are ignored
+	// until they make it into the next generation of the relevance window.
+	// Estimation costs HyperLogLog updates on every read and up to 8KB per
+	// prefix, so it is opt-in: 0, the default, disables it.
+	DistinctMaxPrefixes int
+	DistinctMaxDepth    int
 }
 
 var OptionsDefault = Options{
@@ -57,39 +145,87 @@ var OptionsDefault = Options{
 	CacheFillTimeout:           250 * time.Millisecond,
 	MaxPayloadTotalSize:        4 * 1000 * 1000 * 1000, // 4G, dunno
 	O:                          &fake.DelayedOrigin{},  // TODO: placeholder, must fix
+	Clock:                      clock.Real{},
+	WatchBufferSize:            64,
+	KeyDelimiter:               ":",
+	TopPrefixesMaxDepth:        2,
+	DistinctMaxDepth:           1,
 }
 
-// NewEngine creates a new cache engine with a skiplist as the underlying data
-// structure.
-func NewEngine(opts *Options) (*Engine, error) {
+// validateOptions does the sanity checks of NewEngine and UpdateOptions.
+func validateOptions(opts *Options) error {
 
-	{ // sanity checks
+	if opts.ExpectedLen < 1024 {
+		return errors.New("ExpectedLen must be >= 1024")
+	}
 
-		if opts.ExpectedLen < 1024 {
-			return nil, errors.New("ExpectedLen must be >= 1024")
-		}
+	if opts.MaxPayloadTotalSize < 10*1000*1000 {
+		return errors.New("MaxPayloadTotalSize must be >= 10*1000*1000 bytes")
+	}
 
-		if opts.MaxPayloadTotalSize < 10*1000*1000 {
-			return nil, errors.New("MaxPayloadTotalSize must be >= 10*1000*1000 bytes")
-		}
+	if opts.CacheFillTimeout < 10*time.Millisecond {
+		return errors.New("cachefill timeout too small")
+	}
 
-		if opts.CacheFillTimeout < 10*time.Millisecond {
-			return nil, errors.New("cachefill timeout too small")
-		}
+	if opts.TtlTickStep < 1*time.Millisecond {
+		return errors.New("TTL tick step too small")
+	}
 
-		if opts.TtlTickStep < 1*time.Millisecond {
-			return nil, errors.New("TTL tick step too small")
-		}
+	if opts.EvictPolicyTickStep < 1*time.Millisecond ||
+		opts.EvictPolicyTickStep > opts.EvictPolicyRelevanceWindow {
 
-		if opts.EvictPolicyTickStep < 1*time.Millisecond ||
-			opts.EvictPolicyTickStep > opts.EvictPolicyRelevanceWindow {
+		return errors.New("evict policy tick step too small or bigger than relevance window")
+	}
 
-			return nil, errors.New("evict policy tick step too small or bigger than relevance window")
-		}
+	if opts.EvictPolicyRelevanceWindow < 100*time.Millisecond {
+		return errors.New("evict policy relevance window too small")
+	}
 
-		if opts.EvictPolicyRelevanceWindow < 100*time.Millisecond {
-			return nil, errors.New("evict policy relevance window too small")
-		}
+	if opts.ExpiryJitter < 0 || opts.ExpiryJitterRatio < 0 || opts.ExpiryJitterRatio >= 1 {
+		return errors.New("expiry jitter must be >= 0 and jitter ratio in [0, 1)")
+	}
+
+	if opts.TtlMaxDeletesPerTick < 0 {
+		return errors.New("TTL max deletes per tick must be >= 0")
+	}
+
+	if opts.TTLIndex != TTLIndexDuplist && opts.TTLIndex != TTLIndexWheel {
+		return errors.New("unknown TTL index kind")
+	}
+
+	if opts.WatchBufferSize < 0 {
+		return errors.New("watch buffer size must be >= 0")
+	}
+
+	if opts.WatchDropPolicy != DropNewest && opts.WatchDropPolicy != DropOldest {
+		return errors.New("unknown watch drop policy")
+	}
+
+	if opts.TopKeysCapacity < 0 || opts.TopPrefixesMaxDepth < 0 {
+		return errors.New("top keys capacity and top prefixes depth must be >= 0")
+	}
+
+	if opts.TopPrefixesMaxDepth > 0 && opts.KeyDelimiter == "" {
+		return errors.New("top prefixes need a key delimiter")
+	}
+
+	if opts.DistinctMaxPrefixes < 0 || opts.DistinctMaxDepth < 0 {
+		return errors.New("distinct max prefixes and depth must be >= 0")
+	}
+
+	if opts.DistinctMaxDepth > 0 && opts.KeyDelimiter == "" {
+		return errors.New("distinct prefixes need a key delimiter")
+	}
+
+	return validateTTLRules(opts.TTLRules)
+}
+
+// NewEngine creates a new cache engine with a skiplist as the underlying data
+// structure.
+func NewEngine(opts *Options) (*Engine, error) {
+
+	if err := validateOptions(opts); err != nil {
+		return nil, err
 	}
 
 	// log2(ExpectedLen)
@@ -102,6 +238,16 @@ func NewEngine(opts *Options) (*Engine, error) {
 		graveyardSize = 1000
 	}
 
+	watchBufferSize := opts.WatchBufferSize
+	if watchBufferSize == 0 {
+		watchBufferSize = 64
+	}
+
+	clk := opts.Clock
+	if clk == nil {
+		clk = clock.Real{}
+	}
+
 	e := &Engine{
 		&sync.RWMutex{},
 
@@ -112,10 +258,14 @@ func NewEngine(opts *Options) (*Engine, error) {
 		&ttlStore{
 			// assume 50% of elements will be TTL'ed
 			// configurable later
-			*(skiplist.NewDuplist(n - 1)),
+			newTTLIndex(opts.TTLIndex, n-1, opts.TtlTickStep, clk.Now()),
 
-			map[string]*skiplist.DupElement{},
 			nil,
+
+			map[string]time.Duration{},
+			&prefixTrie{},
+			sync.Mutex{},
+			map[string]time.Time{},
 		},
 
 		&evictPolicy{
@@ -126,6 +276,10 @@ func NewEngine(opts *Options) (*Engine, error) {
 			opts.EvictPolicyRelevanceWindow,
 			map[string]struct{}{},
 			graveyardSize,
+			clk,
+			newHotKeys(opts.TopKeysCapacity, opts.TopPrefixesMaxDepth, opts.KeyDelimiter),
+			newDistinctCounter(opts.DistinctMaxPrefixes, opts.DistinctMaxDepth, opts.KeyDelimiter,
+				opts.EvictPolicyRelevanceWindow, clk.Now()),
 		},
 
 		opts.O,
@@ -133,12 +287,49 @@ func NewEngine(opts *Options) (*Engine, error) {
 		opts.CacheFillTimeout,
 
 		opts.MaxPayloadTotalSize,
+
+		clk,
+
+		newTTLRules(opts.TTLRules),
+
+		opts.ExpiryJitter,
+
+		opts.ExpiryJitterRatio,
+
+		rand.New(rand.NewSource(clk.Now().UnixNano())),
+
+		opts.TtlMaxDeletesPerTick,
+
+		newWatchHub(watchBufferSize, opts.WatchDropPolicy),
+
+		opts.OnRemove,
+
+		nil,
+
+		&engineStats{},
+
+		newClassStats(opts.StatsPrefixClasses),
+
+		append([]string{""}, opts.StatsPrefixClasses...),
+
+		sync.WaitGroup{},
+
+		false,
+
+		*opts,
+
+		&sync.Mutex{},
+
+		nil,
+
+		nil,
 	}
 
 	e.ts.e = e
+	e.opts.Clock = clk
 
-	go e.ts.startLoop(opts.TtlTickStep)
-	go e.ep.startLoop(opts.EvictPolicyTickStep)
+	e.ttlLoop = startTickLoop(e.ts.startLoop, clk.NewTicker(opts.TtlTickStep))
+	e.epLoop = startTickLoop(e.ep.startLoop, clk.NewTicker(opts.EvictPolicyTickStep))
 
 	return e, nil
 }
@@ -146,13 +337,14 @@ func NewEngine(opts *Options) (*Engine, error) {
 // Get returns a *bytes.Reader with the value associated with key as the
 // underlying byte slice. Get triggers a cache fill upon cache miss.
 func (e *Engine) Get(key string) (r *bytes.Reader, err error) {
-	return e.get(key)
+	r, _, _, err = e.get(key)
+	return
 }
 
 // GetCopy copies the byte slice associated with key into the returned []byte.
 // GetCopy triggers a cache fill upon cache miss.
 func (e *Engine) GetCopy(key string) ([]byte, error) {
-	r, err := e.get(key)
+	r, _, _, err := e.get(key)
 	if err != nil {
 		return nil, err
 	}
@@ -161,7 +353,7 @@ func (e *Engine) GetCopy(key string) ([]byte, error) {
 
 // GetWithTTL calls Get and GetTTL and returns the combined info.
 func (e *Engine) GetWithTTL(key string) (*bytes.Reader, float64, error) {
-	r, err := e.get(key)
+	r, _, _, err := e.get(key)
 	if err != nil {
 		return nil, -1, err
 	}
@@ -169,28 +361,38 @@ func (e *Engine) GetWithTTL(key string) (*bytes.Reader, float64, error) {
 	return r, ttl[0], nil
 }
 
-func (e *Engine) get(key string) (*bytes.Reader, error) {
+// get returns the value of key along its metadata, which is nil for rows
+// without any and must not be modified, and its version, which is 0 for a
+// value filled from origin but not kept, e.g. already expired.
+func (e *Engine) get(key string) (*bytes.Reader, *Meta, uint64, error) {
 
-	go e.ep.addToWindow(key)
+	go e.ep.addToWindow(key, e.clock.Now())
 
-	r := e.tryget(key)
-	if r != nil { // cache hit
-		return r, nil
+	el := e.tryget(key)
+	if el != nil { // cache hit
+		atomic.AddUint64(&e.stats.hits, 1)
+		atomic.AddUint64(&e.classOf(key).hits, 1)
+		return el.ValReader(), el.Meta(), el.Version(), nil
 	}
 
 	// cache miss
-	r, err := e.cacheFill(key)
+	atomic.AddUint64(&e.stats.misses, 1)
+	atomic.AddUint64(&e.classOf(key).misses, 1)
+	r, m, v, err := e.cacheFill(key)
 	if err != nil {
-		return nil, err
+		return nil, nil, 0, err
 	}
-	return r, nil
+	return r, m, v, nil
 }
 
-func (e *Engine) tryget(key string) *bytes.Reader {
+func (e *Engine) tryget(key string) *skiplist.Element {
 	e.rwm.RLock()
 	defer e.rwm.RUnlock()
 	if el, ok := e.dataStore.Get(key); ok && el != nil {
-		return el.ValReader()
+		if idle := e.ts.idleTimeout(key); idle > 0 {
+			e.ts.slide(key, e.clock.Now().Add(idle))
+		}
+		return el
 	}
 	return nil
 }
@@ -208,10 +410,11 @@ func (e *Engine) GetByPrefix(p string) []*bytes.Reader {
 		return nil
 	}
 
+	now := e.clock.Now()
 	rs := make([]*bytes.Reader, len(els))
 	for i, v := range els {
 		rs[i] = v.ValReader()
-		go e.ep.addToWindow(v.Key())
+		go e.ep.addToWindow(v.Key(), now)
 	}
 	return rs
 }
@@ -228,20 +431,21 @@ func (e *Engine) GetCopiesByPrefix(p string) [][]byte {
 		return nil
 	}
 
+	now := e.clock.Now()
 	rs := make([][]byte, len(els))
 	for i, v := range els {
 		rs[i] = v.ValCopy()
-		go e.ep.addToWindow(v.Key())
+		go e.ep.addToWindow(v.Key(), now)
 	}
 	return rs
 }
 
-func (e *Engine) cacheFill(key string) (*bytes.Reader, error) {
+func (e *Engine) cacheFill(key string) (*bytes.Reader, *Meta, uint64, error) {
 
 	e.rwm.Lock()
 	if el, ok := e.dataStore.Get(key); ok && el != nil {
 		e.rwm.Unlock()
-		return el.ValReader(), nil
+		return el.ValReader(), el.Meta(), el.Version(), nil
 	}
 
 	// still locked
@@ -254,72 +458,126 @@ func (e *Engine) cacheFill(key string) (*bytes.Reader, error) {
 
 		// must never reach here
 		e.rwm.Unlock()
-		return nil, errors.New("nil condition during cache fill")
+		return nil, nil, 0, errors.New("nil condition during cache fill")
+
+	} else if e.closed {
+
+		e.rwm.Unlock()
+		return nil, nil, 0, ErrClosed
 
 	} else {
 
-		e.fillCond[key] = &condition{*sync.NewCond(e.rwm), 1, nil, nil}
-		go e.firstFill(key)
+		c := &condition{*sync.NewCond(e.rwm), 1, nil, nil, 0, false, nil}
+		e.fillCond[key] = c
+		e.fills.Add(1)
+		go e.firstFill(key, c, e.timeout)
 		return e.blockUntilFilled(key)
 	}
 }
 
-func (e *Engine) firstFill(key string) {
+func (e *Engine) firstFill(key string, c *condition, timeout time.Duration) {
+
+	defer e.fills.Done()
 
 	// fetch from remote and fill up buffer
-	rc, exp := e.o.Fetch(key, e.timeout)
-	rw := &rowWriter{key, nil, e}
+	start := time.Now() // origin latency is wall clock time, whatever e.clock
+	rc, exp, m := e.fetch(key, timeout)
+	rw := &rowWriter{key, bytes.NewBuffer(nil), m, e}
 
 	var err error
 	if rc != nil {
 		_, err = io.Copy(rw, rc)
+		_ = rc.Close() // release origin resources, the payload is already buffered
 	} else {
 		err = errors.New("nil ReadCloser from Fetch")
 	}
+	e.stats.observeFill(time.Since(start))
 
-	if err != nil {
+	e.rwm.Lock()
 
-		if rc != nil {
-			_ = rc.Close()
-		}
-		e.rwm.Lock()
-		e.fillCond[key].err = err
+	if c.filled {
+
+		// superseded by Set while fetching, waiters already got its value
+
+	} else if err != nil {
+
+		c.err = err
+		atomic.AddUint64(&e.stats.fillErrors, 1)
 
 	} else {
 
-		e.rwm.Lock()
+		atomic.AddUint64(&e.stats.fills, 1)
 
-		if rowPayloadSize := rw.b.Len(); e.dataStore.PayloadSize()+int64(rowPayloadSize) > e.maxPayloadTotalSize {
-			e.evictUntilFree(4 * rowPayloadSize)
-		}
+		e.makeRoom(rw.b.Len() + int(rw.m.Size()))
 
-		if exp != nil && exp.After(time.Now()) {
-			rw.Commit()
+		now := e.clock.Now()
+		if rw.m != nil {
+			rw.m.Created = now
+		}
+		exp = e.applyTTLRules(key, exp, now)
+
+		if idle := e.ts.idleTimeout(key); idle > 0 {
+			c.v = rw.Commit()
+			e.ts.Set(key, now.Add(idle))
+			e.watch.publish(key, EventFill, now)
+		} else if exp != nil && exp.After(now) {
+			c.v = rw.Commit()
 			e.setExpiry(key, *exp)
+			e.watch.publish(key, EventFill, now)
 		} else if exp == nil {
-			rw.Commit()
+			c.v = rw.Commit()
+			e.watch.publish(key, EventFill, now)
 		}
 
-		e.fillCond[key].b = rw.b.Bytes()
+		c.b, c.m = rw.b.Bytes(), rw.m
+		c.filled = true // b is nil for an empty payload
 	}
 
-	e.fillCond[key].Broadcast()
-	e.rwm.Unlock()
+	c.Broadcast()
+	e.unlock()
 
 	return
 }
 
+// Close stops the background TTL and eviction loops, waits for in-flight
+// cache fills and closes all subscriptions returned by Watch and WatchPrefix.
+// Cache misses fail with ErrClosed afterwards, the rows already in the cache
+// can still be read and written but no longer expire. It is safe to call
+// Close more than once.
+func (e *Engine) Close() error {
+
+	e.tuneMu.Lock()
+	defer e.tuneMu.Unlock()
+
+	e.rwm.Lock()
+	if e.closed {
+		e.rwm.Unlock()
+		return nil
+	}
+	e.closed = true
+	e.rwm.Unlock()
+
+	e.ttlLoop.stop()
+	e.epLoop.stop()
+	e.fills.Wait()
+	e.watch.closeAll()
+	return nil
+}
+
 type condition struct {
 	sync.Cond
-	count int
-	b     []byte
-	err   error
+	count  int
+	b      []byte
+	m      *Meta
+	v      uint64
+	filled bool
+	err    error
 }
 
-func (e *Engine) blockUntilFilled(key string) (r *bytes.Reader, err error) {
+func (e *Engine) blockUntilFilled(key string) (r *bytes.Reader, m *Meta, v uint64, err error) {
 
 	c := e.fillCond[key]
-	for c.b == nil && c.err == nil {
+	for !c.filled && c.err == nil {
 		e.fillCond[key].Wait()
 	}
 
@@ -327,8 +585,8 @@ func (e *Engine) blockUntilFilled(key string) (r *bytes.Reader, err error) {
 		err = c.err
 	}
 
-	if b := c.b; b != nil {
-		r = bytes.NewReader(e.fillCond[key].b)
+	if c.filled {
+		r, m, v = bytes.NewReader(c.b), c.m, c.v
 	}
 
 	e.fillCond[key].count--
@@ -344,22 +602,40 @@ func (e *Engine) blockUntilFilled(key string) (r *bytes.Reader, err error) {
 type rowWriter struct {
 	key string
 	b   *bytes.Buffer
+	m   *Meta
 	e   *Engine
 }
 
 func (rw *rowWriter) Write(p []byte) (n int, err error) {
-	if rw.b == nil {
-		rw.b = bytes.NewBuffer(nil)
-	}
 	return rw.b.Write(p)
 }
 
-// no locking.
-func (rw *rowWriter) Commit() {
-	rw.e.dataStore.Upsert(rw.key, rw.b.Bytes())
+// no locking. Commit returns the version of the row.
+func (rw *rowWriter) Commit() uint64 {
+	return rw.e.dataStore.Upsert(rw.key, rw.b.Bytes(), rw.m)
+}
+
+// makeRoom evicts rows if a row of n bytes doesn't fit, freeing about 4n
+// bytes. While the payload exceeds a lowered MaxPayloadTotalSize, the excess
+// is left to shrink, see UpdateOptions. It returns whether it evicted.
+// Needs the write lock.
+func (e *Engine) makeRoom(n int) bool {
+
+	free := e.maxPayloadTotalSize - e.dataStore.PayloadSize()
+	if free >= int64(n) {
+		return false
+	}
+
+	wanted := 4 * int64(n)
+	if free < 0 {
+		wanted += free
+	}
+	e.evictUntilFree(int(wanted))
+	return true
 }
 
-// still holding top level lock throughout
+// still holding top level lock throughout. wantedFreeSpace is negative when
+// only part of an excess is to be freed.
 func (e *Engine) evictUntilFree(wantedFreeSpace int) {
 
 	var enoughFreed bool
@@ -375,6 +651,8 @@ func (e *Engine) evictUntilFree(wantedFreeSpace int) {
 
 			go e.ep.dataDeletion(k)
 
+			e.rowRemoved(delEl, EventEvict, e.clock.Now())
+
 			if freeSpace := e.maxPayloadTotalSize - e.dataStore.PayloadSize(); freeSpace > int64(wantedFreeSpace) {
 				enoughFreed = true
 				break
@@ -389,14 +667,16 @@ func (e *Engine) evictUntilFree(wantedFreeSpace int) {
 	if !enoughFreed {
 		e.ep.Lock()
 
-		for i := 1; !enoughFreed; i *= 4 {
+		// bail out on an empty dataStore, a row bigger than the whole cache
+		// would loop forever otherwise
+		for i := 1; !enoughFreed && e.dataStore.Len() > 0; i *= 4 {
 
 			for it := e.dataStore.First(); it != nil; it = it.Next() {
 
 				if !e.ep.isRelevant(it.Key()) ||
 					e.ep.cms.Count([]byte(it.Key())) <= uint64(i) {
 
-					e.delDataTsEp(it.Key())
+					e.delDataTsEp(it.Key(), EventEvict)
 
 					if freeSpace := e.maxPayloadTotalSize - e.dataStore.PayloadSize(); freeSpace > int64(wantedFreeSpace) {
 						enoughFreed = true
@@ -409,20 +689,179 @@ func (e *Engine) evictUntilFree(wantedFreeSpace int) {
 	}
 }
 
-func (e *Engine) delDataTsEp(key string) {
-	e.dataStore.Del(key)
+// delDataTsEp returns whether key was in the data store.
+func (e *Engine) delDataTsEp(key string, reason EventReason) bool {
+	el := e.dataStore.Del(key)
+	if el != nil {
+		e.rowRemoved(el, reason, e.clock.Now())
+	}
 	e.ts.del(key)
 	go e.ep.dataDeletion(key)
+	return el != nil
+}
+
+// Set associates key with a copy of val without going through origin,
+// removing any TTL key had. A sliding expiration applying to key still takes
+// effect. Cache fills of key in progress are superseded, their callers get val.
+func (e *Engine) Set(key string, val []byte) {
+	e.set(key, val, 0, nil, writeAlways, 0)
+}
+
+// SetWithTTL is like Set except that key expires ttl from now. A non-positive
+// ttl means no TTL.
+func (e *Engine) SetWithTTL(key string, val []byte, ttl time.Duration) {
+	e.set(key, val, ttl, nil, writeAlways, 0)
+}
+
+// set stores a copy of m along the row, or no metadata if m is nil. Created
+// defaults to now. ver is the version expected by writeIfVersion. It returns
+// the version of the row written, or the current version of key, 0 if absent,
+// and false if mode prevented the write.
+func (e *Engine) set(key string, val []byte, ttl time.Duration, m *Meta, mode writeMode, ver uint64) (uint64, bool) {
+
+	b := make([]byte, len(val))
+	copy(b, val)
+	m = m.Copy()
+
+	e.rwm.Lock()
+	defer e.unlock()
+
+	old, exists := e.dataStore.Get(key)
+	if !mode.allows(old, ver) {
+		if exists {
+			return old.Version(), false
+		}
+		return 0, false
+	}
+
+	now := e.clock.Now()
+	if m != nil && m.Created.IsZero() {
+		m.Created = now
+	}
+	v := e.upsert(key, b, m, old, now)
+
+	if ttl > 0 {
+		e.ts.Set(key, now.Add(ttl))
+	} else if idle := e.ts.idleTimeout(key); idle > 0 {
+		e.ts.Set(key, now.Add(idle))
+	} else {
+		e.ts.Del(key)
+	}
+	return v, true
+}
+
+// upsert writes b and m as the row of key, replacing old, nil if key is not
+// in the cache, and returns its version. The TTL is left to the caller.
+// Needs the write lock.
+func (e *Engine) upsert(key string, b []byte, m *Meta, old *skiplist.Element, now time.Time) uint64 {
+
+	if e.makeRoom(len(b) + int(m.Size())) {
+		old, _ = e.dataStore.Get(key)
+	}
+	if old != nil && e.onRemove != nil {
+		e.removals = append(e.removals, removal{key, old.ValCopy(), Replaced})
+	}
+	v := e.dataStore.Upsert(key, b, m)
+
+	go e.ep.addWrite(key, now)
+
+	if c, ok := e.fillCond[key]; ok && !c.filled && c.err == nil {
+		c.b, c.m, c.v, c.filled = b, m, v, true
+		c.Broadcast()
+	}
+
+	atomic.AddUint64(&e.stats.sets, 1)
+	e.watch.publish(key, EventSet, now)
+	return v
 }
 
 // Invalidate deletes keys from the data, TTL, and evict policy store.
 // Only invoke Invalidate as a last resort for manual intervention.
 // Normally, control the invalidation process by setting sensible TTL
-// values at origin.
-func (e *Engine) Invalidate(keys ...string) {
+// values at origin. It returns the number of keys which were in the cache.
+func (e *Engine) Invalidate(keys ...string) int {
+	n := 0
 	e.rwm.Lock()
 	for _, v := range keys {
-		e.delDataTsEp(v)
+		if e.delDataTsEp(v, EventInvalidate) {
+			n++
+		}
 	}
-	e.rwm.Unlock()
+	e.unlock()
+	return n
+}
+
+// InvalidatePrefix is like Invalidate for all the keys having prefix p. It
+// returns the number of keys deleted.
+func (e *Engine) InvalidatePrefix(p string) int {
+
+	e.rwm.Lock()
+	defer e.unlock()
+
+	var keys []string
+	for it := e.dataStore.Seek(p); it != nil && strings.HasPrefix(it.Key(), p); it = it.Next() {
+		keys = append(keys, it.Key())
+	}
+	for _, k := range keys {
+		e.delDataTsEp(k, EventInvalidate)
+	}
+	return len(keys)
+}
+
+// Has tells whether key is in the cache, without triggering a cache fill nor
+// counting as a read.
+func (e *Engine) Has(key string) bool {
+	e.rwm.RLock()
+	defer e.rwm.RUnlock()
+	_, ok := e.dataStore.Get(key)
+	return ok
+}
+
+// KeysByPrefix returns the keys having prefix p in ascending order, skipping
+// the first offset of them and returning at most limit of them if limit > 0.
+// Values are not read, so keys don't count as read.
+func (e *Engine) KeysByPrefix(p string, offset, limit int) []string {
+
+	e.rwm.RLock()
+	defer e.rwm.RUnlock()
+
+	var keys []string
+	for it := e.dataStore.Seek(p); it != nil && strings.HasPrefix(it.Key(), p); it = it.Next() {
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if limit > 0 && len(keys) == limit {
+			break
+		}
+		keys = append(keys, it.Key())
+	}
+	return keys
+}
+
+// Entry is a key and a copy of its value.
+type Entry struct {
+	Key string
+	Val []byte
+}
+
+// EntriesByPrefix is like KeysByPrefix except that it also returns copies of
+// the values, read at once. Entries don't count as read either.
+func (e *Engine) EntriesByPrefix(p string, offset, limit int) []Entry {
+
+	e.rwm.RLock()
+	defer e.rwm.RUnlock()
+
+	var es []Entry
+	for it := e.dataStore.Seek(p); it != nil && strings.HasPrefix(it.Key(), p); it = it.Next() {
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if limit > 0 && len(es) == limit {
+			break
+		}
+		es = append(es, Entry{it.Key(), it.ValCopy()})
+	}
+	return es
 }
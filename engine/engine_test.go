@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/clock"
 	"github.com/wv0m56/prefixed/plugin/origin/fake"
 )
 
@@ -137,6 +138,26 @@ func TestPrefix(t *testing.T) {
 
 	bs := e.GetCopiesByPrefix("water")
 	assert.Equal(t, 2, len(bs))
+
+	e.Get("waterloo")
+	e.Get("wine")
+	assert.Equal(t, []string{"water", "waterfall", "waterloo"}, e.KeysByPrefix("water", 0, 0))
+	assert.Equal(t, []string{"waterfall"}, e.KeysByPrefix("water", 1, 1))
+	assert.Nil(t, e.KeysByPrefix("water", 3, 0))
+	assert.Equal(t, 4, len(e.KeysByPrefix("", 0, 0)))
+
+	assert.True(t, e.Has("wine"))
+	assert.False(t, e.Has("win"))
+	assert.Equal(t, []Entry{{"waterloo", []byte("waterloo")}, {"wine", []byte("wine")}},
+		e.EntriesByPrefix("w", 2, 5))
+	assert.Nil(t, e.EntriesByPrefix("x", 0, 0))
+
+	assert.Equal(t, 2, e.Invalidate("wine", "win", "water"))
+	assert.False(t, e.Has("wine"))
+
+	assert.Equal(t, 2, e.InvalidatePrefix("water"))
+	assert.Equal(t, 0, e.InvalidatePrefix("water"))
+	assert.Nil(t, e.KeysByPrefix("", 0, 0))
 }
 
 func TestHotKey(t *testing.T) {
@@ -173,13 +194,12 @@ func TestHotKey(t *testing.T) {
 // API + internals
 func TestEvictUponDelete(t *testing.T) {
 
+	clk := clock.NewManual(time.Now())
 	opts := OptionsDefault
 	opts.O = &fake.NoDelayOrigin{}
-
-	// too small value will fail test -race due to slower execution
 	opts.EvictPolicyRelevanceWindow = 100 * time.Millisecond
-
 	opts.EvictPolicyTickStep = 1 * time.Millisecond
+	opts.Clock = clk
 
 	eng, err := NewEngine(&opts)
 	assert.Nil(t, err)
@@ -192,7 +212,12 @@ func TestEvictUponDelete(t *testing.T) {
 	eng.GetCopy("abc")
 	eng.Get("abc")
 
-	time.Sleep(10 * time.Millisecond) // wait a bit since stats is updated via goroutine
+	// stats is updated via goroutine
+	eventually(t, func() bool {
+		eng.ep.Lock()
+		defer eng.ep.Unlock()
+		return eng.ep.cms.Count([]byte("abc")) == 4
+	})
 	eng.ep.Lock()
 	ptr, ok := eng.ep.listElPtr["abc"]
 	assert.True(t, ok)
@@ -200,7 +225,12 @@ func TestEvictUponDelete(t *testing.T) {
 	assert.Equal(t, uint64(4), eng.ep.cms.Count([]byte("abc")))
 	eng.ep.Unlock()
 
-	time.Sleep(opts.EvictPolicyRelevanceWindow + 10*time.Millisecond)
+	clk.Advance(opts.EvictPolicyRelevanceWindow + 10*time.Millisecond)
+	eventually(t, func() bool {
+		eng.ep.Lock()
+		defer eng.ep.Unlock()
+		return !eng.ep.isRelevant("abc")
+	})
 
 	eng.ep.Lock()
 	ptr, ok = eng.ep.listElPtr["abc"]
@@ -212,13 +242,13 @@ func TestEvictUponDelete(t *testing.T) {
 
 func TestSimpleEvictUponFullCache(t *testing.T) {
 
+	clk := clock.NewManual(time.Now())
 	opts := OptionsDefault
 	opts.O = &fake.ZeroesPayloadOrigin{}
 	opts.MaxPayloadTotalSize = 10 * 1000 * 1000
 	opts.EvictPolicyTickStep = 10 * time.Millisecond
-
-	// large value for -race
 	opts.EvictPolicyRelevanceWindow = 1 * time.Second
+	opts.Clock = clk
 
 	e, err := NewEngine(&opts)
 	assert.Nil(t, err)
@@ -227,9 +257,22 @@ func TestSimpleEvictUponFullCache(t *testing.T) {
 	assert.Equal(t, 0, len(e.ep.graveyard))
 	e.ep.Unlock()
 
+	// reads reach the relevance window asynchronously, evicting or ticking
+	// before they all did would leave keys out of it or of the graveyard
+	settled := func(key string) func() bool {
+		return func() bool {
+			e.rwm.RLock()
+			defer e.rwm.RUnlock()
+			e.ep.Lock()
+			defer e.ep.Unlock()
+			return e.ep.isRelevant(key) && int64(len(e.ep.listElPtr)) == e.dataStore.Len()
+		}
+	}
+
 	for i := 0; i < 1000; i++ {
 		e.Get(strconv.Itoa(i))
 	}
+	eventually(t, settled("999"))
 
 	assert.Equal(t, opts.MaxPayloadTotalSize, e.dataStore.PayloadSize())
 
@@ -249,11 +292,14 @@ func TestSimpleEvictUponFullCache(t *testing.T) {
 	assert.Equal(t, 0, len(e.ep.graveyard))
 	e.ep.Unlock()
 
-	time.Sleep(opts.EvictPolicyRelevanceWindow)
+	eventually(t, settled("abc"))
 
-	e.ep.Lock()
-	assert.True(t, len(e.ep.graveyard) > 0)
-	e.ep.Unlock()
+	clk.Advance(opts.EvictPolicyRelevanceWindow + opts.EvictPolicyTickStep)
+	eventually(t, func() bool {
+		e.ep.Lock()
+		defer e.ep.Unlock()
+		return len(e.ep.graveyard) > 0
+	})
 
 	for i := 888888; i < 888888+150; i++ {
 		_, err = e.Get(strconv.Itoa(i))
@@ -339,3 +385,42 @@ func BenchmarkErrorKey(b *testing.B) {
 		wg.Wait()
 	}
 }
+
+func TestClose(t *testing.T) {
+
+	opts := OptionsDefault
+	opts.O = &fake.DelayedOrigin{}
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	e.Set("a", []byte("b"))
+	sub := e.WatchPrefix("")
+
+	// in-flight fills complete before Close returns
+	filled := make(chan []byte)
+	go func() {
+		b, _ := e.GetCopy("slow")
+		filled <- b
+	}()
+	eventually(t, func() bool {
+		e.rwm.RLock()
+		defer e.rwm.RUnlock()
+		return len(e.fillCond) == 1
+	})
+
+	assert.Nil(t, e.Close())
+	assert.True(t, e.Has("slow"))
+	assert.Equal(t, []byte("slow"), <-filled)
+
+	// subscriptions are closed, after the events already sent
+	for range sub.C {
+	}
+
+	b, err := e.GetCopy("a")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), b)
+	_, err = e.GetCopy("c")
+	assert.Equal(t, ErrClosed, err)
+
+	assert.Nil(t, e.Close())
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/tylertreat/BoomFilters"
+	"github.com/wv0m56/prefixed/clock"
 )
 
 // evictPolicy is the data structure determining which row of the cache should
@@ -19,6 +20,9 @@ type evictPolicy struct {
 	relevanceWindow time.Duration
 	graveyard       map[string]struct{}
 	graveyardCap    int
+	clock           clock.Clock
+	hot             *hotKeys         // nil if disabled
+	distinct        *distinctCounter // nil if disabled
 }
 
 func (ep *evictPolicy) isRelevant(key string) bool {
@@ -27,18 +31,40 @@ func (ep *evictPolicy) isRelevant(key string) bool {
 }
 
 // lock ok because called from goroutine
-func (ep *evictPolicy) addToWindow(key string) {
+// now is the time of access, taken before spawning the goroutine.
+func (ep *evictPolicy) addToWindow(key string, now time.Time) {
 
 	ep.Lock()
 	defer ep.Unlock()
 
+	ep.cms.Add([]byte(key))
+	if ep.hot != nil {
+		ep.hot.read(key, ep.cms.Count([]byte(key)))
+	}
+	if ep.distinct != nil {
+		ep.distinct.add(key)
+	}
+	ep.refresh(key, now)
+}
+
+// addWrite keeps a written key in the relevance window. Writes aren't reads,
+// so they count neither towards access frequency nor hot and distinct keys.
+// lock ok because called from goroutine
+func (ep *evictPolicy) addWrite(key string, now time.Time) {
+	ep.Lock()
+	defer ep.Unlock()
+	ep.refresh(key, now)
+}
+
+// refresh moves key to the back of the relevance window.
+func (ep *evictPolicy) refresh(key string, now time.Time) {
+
 	if ptr, ok := ep.listElPtr[key]; ok {
 		ep.ll.delByPtr(ptr)
 		// no need to delete map element, overwritten later
 	}
 
-	ep.cms.Add([]byte(key))
-	ptr := ep.ll.addToBack(key)
+	ptr := ep.ll.addToBack(key, now)
 	ep.listElPtr[key] = ptr
 	delete(ep.graveyard, key)
 }
@@ -70,6 +96,9 @@ func (ep *evictPolicy) outRelevanceWindow(key string) {
 }
 
 func (ep *evictPolicy) del(key string) {
+	if ep.hot != nil {
+		ep.hot.del(key, ep.cms.Count([]byte(key)))
+	}
 	_ = ep.cms.TestAndRemoveAll([]byte(key))
 	if ptr, ok := ep.listElPtr[key]; ok {
 		ep.ll.delByPtr(ptr)
@@ -77,12 +106,23 @@ func (ep *evictPolicy) del(key string) {
 	delete(ep.listElPtr, key)
 }
 
-func (ep *evictPolicy) startLoop(step time.Duration) {
+func (ep *evictPolicy) startLoop(t clock.Ticker, stop <-chan struct{}) {
+
+	defer t.Stop()
+	for {
+		var now time.Time
+		select {
+		case <-stop:
+			return
+		case now = <-t.C():
+		}
 
-	for range time.Tick(step) {
 		ep.Lock()
+		if ep.distinct != nil {
+			ep.distinct.rotate(now)
+		}
 		for it := ep.ll.front; it != nil &&
-			it.lastReadTime.Add(ep.relevanceWindow).Before(time.Now()); it = it.next {
+			it.lastReadTime.Add(ep.relevanceWindow).Before(now); it = it.next {
 
 			ep.outRelevanceWindow(it.val)
 		}
@@ -103,9 +143,9 @@ type llElement struct {
 }
 
 // approximately sorted
-func (ll *linkedList) addToBack(val string) *llElement {
+func (ll *linkedList) addToBack(val string, now time.Time) *llElement {
 
-	e := &llElement{time.Now(), val, nil, nil}
+	e := &llElement{now, val, nil, nil}
 	e.prev = ll.back
 
 	if ll.back != nil {
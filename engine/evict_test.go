@@ -7,11 +7,13 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/tylertreat/BoomFilters"
+	"github.com/wv0m56/prefixed/clock"
 )
 
 // internals
 func TestEvictPolicy(t *testing.T) {
 
+	clk := clock.NewManual(time.Now())
 	ep := &evictPolicy{
 		sync.Mutex{},
 		boom.NewCountMinSketch(0.001, 0.99),
@@ -20,13 +22,16 @@ func TestEvictPolicy(t *testing.T) {
 		50 * time.Millisecond,
 		map[string]struct{}{},
 		1000,
+		clk,
+		nil,
+		nil,
 	}
 
-	go ep.startLoop(time.Millisecond)
+	go ep.startLoop(clk.NewTicker(time.Millisecond), nil)
 
-	ep.addToWindow("foo")
-	ep.addToWindow("bar")
-	ep.addToWindow("baz")
+	ep.addToWindow("foo", clk.Now())
+	ep.addToWindow("bar", clk.Now())
+	ep.addToWindow("baz", clk.Now())
 
 	ep.Lock()
 
@@ -44,11 +49,22 @@ func TestEvictPolicy(t *testing.T) {
 
 	ep.Unlock()
 
-	time.Sleep(60 * time.Millisecond)
+	// still inside the relevance window
+	clk.Advance(50 * time.Millisecond)
+	ep.Lock()
+	assert.Equal(t, 3, len(ep.listElPtr))
+	ep.Unlock()
+
+	clk.Advance(10 * time.Millisecond)
+	eventually(t, func() bool {
+		ep.Lock()
+		defer ep.Unlock()
+		return len(ep.listElPtr) == 0
+	})
 
 	ep.Lock()
 
-	assert.Equal(t, 0, len(ep.listElPtr))
+	assert.Equal(t, 3, len(ep.graveyard))
 
 	assert.Equal(t, uint64(0), ep.cms.Count([]byte("foo")))
 	assert.Equal(t, uint64(0), ep.cms.Count([]byte("bar")))
@@ -64,7 +80,7 @@ func TestLinkedList(t *testing.T) {
 	ll := &linkedList{}
 	ll.delFront()
 
-	ll.addToBack("one")
+	ll.addToBack("one", time.Now())
 	assert.NotNil(t, ll.front)
 	assert.NotNil(t, ll.back)
 	assert.Equal(t, ll.front, ll.back)
@@ -73,10 +89,10 @@ func TestLinkedList(t *testing.T) {
 	assert.Nil(t, ll.front)
 	assert.Nil(t, ll.back)
 
-	ptr1 := ll.addToBack("one")
-	ll.addToBack("two")
-	ptr2 := ll.addToBack("3")
-	ll.addToBack("4")
+	ptr1 := ll.addToBack("one", time.Now())
+	ll.addToBack("two", time.Now())
+	ptr2 := ll.addToBack("3", time.Now())
+	ll.addToBack("4", time.Now())
 	assert.Equal(t, "one", ll.front.val)
 	assert.Equal(t, "4", ll.back.val)
 
@@ -100,7 +116,7 @@ func TestLinkedList(t *testing.T) {
 	}
 	assert.Equal(t, "two4", vals)
 
-	ptr3 := ll.addToBack("back")
+	ptr3 := ll.addToBack("back", time.Now())
 	vals = ""
 	for it := ll.front; it != nil; it = it.next {
 		vals += it.val
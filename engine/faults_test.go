@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+// origin returning an empty payload for every key
+type emptyOrigin struct{}
+
+func (_ *emptyOrigin) Fetch(_ string, _ time.Duration) (io.ReadCloser, *time.Time) {
+	return ioutil.NopCloser(bytes.NewReader(nil)), nil
+}
+
+func TestFillFaults(t *testing.T) {
+
+	newEngine := func(o *fake.FaultyOrigin) *Engine {
+		opts := OptionsDefault
+		opts.O = o
+		e, err := NewEngine(&opts)
+		assert.Nil(t, err)
+		return e
+	}
+
+	// nil ReadCloser, failed read and partial read never commit a row
+	for _, fo := range []*fake.FaultyOrigin{
+		{NilRate: 1},
+		{ErrorRate: 1},
+		{PartialRate: 1},
+		{Latency: func() time.Duration { return time.Second }},
+	} {
+		e := newEngine(fo)
+		r, err := e.Get("key")
+		assert.NotNil(t, err)
+		assert.Nil(t, r)
+		assert.Equal(t, int64(0), e.dataStore.Len())
+		assert.Equal(t, 0, len(e.fillCond))
+	}
+
+	// close error after a complete read is harmless
+	e := newEngine(&fake.FaultyOrigin{CloseErrorRate: 1})
+	b, err := e.GetCopy("key")
+	assert.Nil(t, err)
+	assert.Equal(t, "key", string(b))
+	assert.Equal(t, int64(1), e.dataStore.Len())
+
+	// expiry in the past serves the value but does not commit the row
+	e = newEngine(&fake.FaultyOrigin{PastExpiryRate: 1})
+	b, err = e.GetCopy("key")
+	assert.Nil(t, err)
+	assert.Equal(t, "key", string(b))
+	assert.Equal(t, int64(0), e.dataStore.Len())
+	assert.Equal(t, 0, e.ts.Len())
+
+	// empty payload is a valid value
+	e = newEngine(&fake.FaultyOrigin{O: &emptyOrigin{}})
+	b, err = e.GetCopy("key")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(b))
+	assert.Equal(t, int64(1), e.dataStore.Len())
+}
+
+// concurrent waiters in blockUntilFilled all observe the same outcome
+func TestFillFaultsConcurrent(t *testing.T) {
+
+	opts := OptionsDefault
+	opts.O = &fake.FaultyOrigin{
+		ErrorRate:   0.3,
+		PartialRate: 0.3,
+		NilRate:     0.1,
+		Latency:     func() time.Duration { return 50 * time.Millisecond },
+		Seed:        42,
+	}
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+
+		key := strconv.Itoa(i)
+		errs := make([]error, 50)
+		wg.Add(len(errs))
+		for j := range errs {
+			go func(j int) {
+				_, errs[j] = e.GetCopy(key)
+				wg.Done()
+			}(j)
+		}
+		wg.Wait()
+
+		for j := range errs {
+			assert.Equal(t, errs[0], errs[j])
+		}
+		_, cached := e.dataStore.Get(key)
+		assert.Equal(t, errs[0] == nil, cached)
+	}
+	assert.Equal(t, 0, len(e.fillCond))
+}
+
+// faults while the cache is full and evicting
+func TestFillFaultsEviction(t *testing.T) {
+
+	opts := OptionsDefault
+	opts.O = &fake.FaultyOrigin{
+		O:              &fake.ZeroesPayloadOrigin{},
+		ErrorRate:      0.2,
+		PartialRate:    0.2,
+		NilRate:        0.1,
+		CloseErrorRate: 0.2,
+		PastExpiryRate: 0.1,
+		Latency:        fake.ExponentialLatency(100 * time.Microsecond),
+		Seed:           7,
+	}
+	opts.MaxPayloadTotalSize = 10 * 1000 * 1000
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	wg := sync.WaitGroup{}
+	wg.Add(3000)
+	for i := 0; i < 3000; i++ {
+		go func(i int) {
+			e.Get(strconv.Itoa(i))
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+
+	e.rwm.RLock()
+	assert.True(t, e.dataStore.PayloadSize() <= opts.MaxPayloadTotalSize)
+	assert.True(t, e.dataStore.Len() > 0)
+	assert.Equal(t, 0, len(e.fillCond))
+	e.rwm.RUnlock()
+}
@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Counters are rows holding a number in canonical decimal encoding: an
+// integer as strconv.FormatInt writes it, or a float as strconv.FormatFloat
+// writes it in 'f' format with the smallest precision representing it
+// exactly, e.g. "-42" or "3.25".
+
+// ErrNotNumber is returned by IncrBy and IncrByFloat when the row to
+// increment doesn't hold a number.
+var ErrNotNumber = errors.New("value is not a number")
+
+// ErrOverflow is returned by IncrBy and IncrByFloat when the incremented
+// number can't be represented.
+var ErrOverflow = errors.New("increment would overflow")
+
+// IncrBy atomically adds n to the integer held by key and returns the result.
+// A missing key is created with value n, expiring ttl from now if ttl is
+// positive, origin is not consulted. An existing row keeps its TTL and
+// metadata. The row is left untouched upon error.
+func (e *Engine) IncrBy(key string, n int64, ttl time.Duration) (int64, error) {
+
+	var res int64
+	err := e.update(key, ttl, func(old []byte, exists bool) ([]byte, error) {
+
+		var cur int64
+		if exists {
+			var err error
+			if cur, err = strconv.ParseInt(string(old), 10, 64); err != nil {
+				return nil, ErrNotNumber
+			}
+		}
+
+		if (n > 0 && cur > math.MaxInt64-n) || (n < 0 && cur < math.MinInt64-n) {
+			return nil, ErrOverflow
+		}
+		res = cur + n
+		return strconv.AppendInt(nil, res, 10), nil
+	})
+	return res, err
+}
+
+// IncrByFloat is like IncrBy for floats. Integers are valid floats, the
+// result is stored as a float nonetheless, e.g. "10" incremented by 0.5 is
+// "10.5".
+func (e *Engine) IncrByFloat(key string, f float64, ttl time.Duration) (float64, error) {
+
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, ErrNotNumber
+	}
+
+	var res float64
+	err := e.update(key, ttl, func(old []byte, exists bool) ([]byte, error) {
+
+		var cur float64
+		if exists {
+			var ok bool
+			if cur, ok = parseFloat(old); !ok {
+				return nil, ErrNotNumber
+			}
+		}
+
+		res = cur + f
+		if math.IsInf(res, 0) {
+			return nil, ErrOverflow
+		}
+		return strconv.AppendFloat(nil, res, 'f', -1, 64), nil
+	})
+	return res, err
+}
+
+// SumPrefix returns the sum of the numbers held by keys having prefix p, read
+// at once, and how many there are. Rows not holding a number are skipped.
+// Rows don't count as read.
+func (e *Engine) SumPrefix(p string) (sum float64, n int) {
+
+	e.rwm.RLock()
+	defer e.rwm.RUnlock()
+
+	for it := e.dataStore.Seek(p); it != nil && strings.HasPrefix(it.Key(), p); it = it.Next() {
+		if f, ok := parseFloat(it.ValCopy()); ok {
+			sum += f
+			n++
+		}
+	}
+	return sum, n
+}
+
+// parseFloat parses a finite decimal number.
+func parseFloat(b []byte) (float64, bool) {
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+// update replaces the row of key with the value fn returns given the current
+// value, as Set would, except that an existing row keeps its TTL and
+// metadata. A created row expires ttl from now if ttl is positive. Nothing
+// is written if fn returns an error.
+func (e *Engine) update(key string, ttl time.Duration, fn func(old []byte, exists bool) ([]byte, error)) error {
+
+	e.rwm.Lock()
+	defer e.unlock()
+
+	old, exists := e.dataStore.Get(key)
+	var val []byte
+	var m *Meta
+	if exists {
+		val, m = old.ValCopy(), old.Meta()
+	}
+
+	b, err := fn(val, exists)
+	if err != nil {
+		return err
+	}
+
+	now := e.clock.Now()
+	e.upsert(key, b, m, old, now)
+
+	if !exists && ttl > 0 {
+		e.ts.Set(key, now.Add(ttl))
+	} else if idle := e.ts.idleTimeout(key); idle > 0 {
+		e.ts.Set(key, now.Add(idle))
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/clock"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+func TestIncr(t *testing.T) {
+
+	clk := clock.NewManual(time.Now())
+	opts := OptionsDefault
+	opts.O = &fake.NoDelayOrigin{}
+	opts.Clock = clk
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	// created with a TTL, origin is not consulted
+	n, err := e.IncrBy("c:a", 5, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), n)
+	ttl, ok := e.TTL("c:a")
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, ttl)
+
+	// the TTL and metadata of existing rows are kept
+	clk.Advance(time.Second)
+	e.SetWithMeta("c:b", []byte("-3"), 0, Meta{Flags: 1})
+	n, err = e.IncrBy("c:a", -7, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-2), n)
+	ttl, _ = e.TTL("c:a")
+	assert.Equal(t, time.Minute-time.Second, ttl)
+	n, err = e.IncrBy("c:b", 1, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-2), n)
+	b, m, _, _ := e.GetWithMeta("c:b")
+	assert.Equal(t, "-2", string(b))
+	assert.Equal(t, uint32(1), m.Flags)
+	_, ok = e.TTL("c:b")
+	assert.False(t, ok)
+
+	e.Set("s", []byte("x"))
+	_, err = e.IncrBy("s", 1, 0)
+	assert.Equal(t, ErrNotNumber, err)
+	e.Set("s", nil)
+	_, err = e.IncrBy("s", 1, 0)
+	assert.Equal(t, ErrNotNumber, err)
+	e.Set("max", []byte("9223372036854775806"))
+	n, err = e.IncrBy("max", 1, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(math.MaxInt64), n)
+	_, err = e.IncrBy("max", 1, 0)
+	assert.Equal(t, ErrOverflow, err)
+	b, _ = e.GetCopy("max")
+	assert.Equal(t, "9223372036854775807", string(b))
+
+	// floats
+	f, err := e.IncrByFloat("c:a", 0.25, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, -1.75, f)
+	b, _ = e.GetCopy("c:a")
+	assert.Equal(t, "-1.75", string(b))
+	_, err = e.IncrBy("c:a", 1, 0)
+	assert.Equal(t, ErrNotNumber, err)
+	f, err = e.IncrByFloat("c:c", 1e21, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1e21, f)
+	b, _ = e.GetCopy("c:c")
+	assert.Equal(t, "1000000000000000000000", string(b))
+	_, err = e.IncrByFloat("c:c", math.Inf(1), 0)
+	assert.Equal(t, ErrNotNumber, err)
+	e.Set("big", []byte("1.7e308"))
+	_, err = e.IncrByFloat("big", 1.7e308, 0)
+	assert.Equal(t, ErrOverflow, err)
+	e.Set("nan", []byte("NaN"))
+	_, err = e.IncrByFloat("nan", 1, 0)
+	assert.Equal(t, ErrNotNumber, err)
+
+	sum, n2 := e.SumPrefix("c:")
+	assert.Equal(t, 3, n2)
+	assert.Equal(t, -1.75-2+1e21, sum)
+	e.Set("c:x", []byte("not a number"))
+	sum2, n2 := e.SumPrefix("c:")
+	assert.Equal(t, 3, n2)
+	assert.Equal(t, sum, sum2)
+	_, n2 = e.SumPrefix("nobody")
+	assert.Zero(t, n2)
+}
@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/wv0m56/prefixed/plugin/origin"
+	"github.com/wv0m56/prefixed/skiplist"
+)
+
+// Meta is metadata stored along a row, see skiplist.Meta. Rows written by
+// Set, SetWithTTL or a cache fill from an origin which is not an
+// origin.MetaOrigin have none.
+type Meta = skiplist.Meta
+
+// SetWithMeta is like SetWithTTL except that m is stored along the row. It
+// returns the version of the row, see Version.
+func (e *Engine) SetWithMeta(key string, val []byte, ttl time.Duration, m Meta) uint64 {
+	v, _ := e.set(key, val, ttl, &m, writeAlways, 0)
+	return v
+}
+
+// Add is like SetWithMeta, only if key is not in the cache. Origin is not
+// consulted. It returns false if key was already there.
+func (e *Engine) Add(key string, val []byte, ttl time.Duration, m Meta) (uint64, bool) {
+	return e.set(key, val, ttl, &m, writeIfAbsent, 0)
+}
+
+// Replace is like SetWithMeta, only if key is in the cache. It returns false
+// if key wasn't there.
+func (e *Engine) Replace(key string, val []byte, ttl time.Duration, m Meta) (uint64, bool) {
+	return e.set(key, val, ttl, &m, writeIfPresent, 0)
+}
+
+// GetWithMeta is like GetCopy and also returns the metadata of the row, the
+// zero Meta if it has none, and its version. It triggers a cache fill upon
+// cache miss.
+func (e *Engine) GetWithMeta(key string) ([]byte, Meta, uint64, error) {
+
+	r, m, v, err := e.get(key)
+	if err != nil {
+		return nil, Meta{}, 0, err
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, Meta{}, 0, err
+	}
+
+	if m == nil {
+		return b, Meta{}, v, nil
+	}
+	return b, *m.Copy(), v, nil
+}
+
+// fetch fetches key from origin, along its metadata if origin is an
+// origin.MetaOrigin. Created is left to the caller.
+func (e *Engine) fetch(key string, timeout time.Duration) (io.ReadCloser, *time.Time, *Meta) {
+
+	mo, ok := e.o.(origin.MetaOrigin)
+	if !ok {
+		rc, exp := e.o.Fetch(key, timeout)
+		return rc, exp, nil
+	}
+
+	rc, exp, ct, h := mo.FetchWithMeta(key, timeout)
+	return rc, exp, &Meta{ContentType: ct, Headers: h}
+}
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/clock"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+func TestMeta(t *testing.T) {
+
+	clk := clock.NewManual(time.Now())
+	opts := OptionsDefault
+	opts.O = &fake.NoDelayOrigin{}
+	opts.Clock = clk
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	// filled rows have no metadata
+	b, m, v0, err := e.GetWithMeta("a")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", string(b))
+	assert.Equal(t, Meta{}, m)
+	assert.NotZero(t, v0)
+	_, _, _, err = e.GetWithMeta("bench error")
+	assert.NotNil(t, err)
+
+	v1 := e.SetWithMeta("a", []byte("1"), 0, Meta{Flags: 7})
+	assert.True(t, v1 > v0)
+	b, m, v, err := e.GetWithMeta("a")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", string(b))
+	assert.Equal(t, Meta{Flags: 7, Created: clk.Now()}, m)
+	assert.Equal(t, v1, v)
+
+	// a plain Set clears metadata
+	e.Set("a", []byte("2"))
+	_, m, _, _ = e.GetWithMeta("a")
+	assert.Equal(t, Meta{}, m)
+
+	_, ok := e.Add("a", []byte("3"), 0, Meta{})
+	assert.False(t, ok)
+	v2, ok := e.Add("b", []byte("3"), time.Minute, Meta{Flags: 1})
+	assert.True(t, ok)
+	assert.True(t, v2 > v1)
+	_, ok = e.TTL("b")
+	assert.True(t, ok)
+
+	_, ok = e.Replace("c", []byte("4"), 0, Meta{})
+	assert.False(t, ok)
+	assert.False(t, e.Has("c"))
+	v3, ok := e.Replace("b", []byte("4"), 0, Meta{Flags: 2})
+	assert.True(t, ok)
+	assert.True(t, v3 > v2)
+	_, ok = e.TTL("b")
+	assert.False(t, ok)
+	m3 := Meta{Flags: 2}
+
+	// metadata counts towards the payload size and leaves along the row
+	size := e.Stats().PayloadSize
+	m4 := Meta{ContentType: "text/plain", Headers: map[string]string{"k": "v"}}
+	e.SetWithMeta("b", []byte("4"), 0, m4)
+	assert.Equal(t, size+m4.Size()-m3.Size(), e.Stats().PayloadSize)
+	m4.Headers["k"] = "modified"
+	_, m, _, _ = e.GetWithMeta("b")
+	assert.Equal(t, "v", m.Headers["k"])
+	assert.Equal(t, clk.Now(), m.Created)
+	e.Invalidate("b")
+	assert.Equal(t, size-m3.Size()-1, e.Stats().PayloadSize)
+	_, m, _, _ = e.GetWithMeta("b")
+	assert.Equal(t, Meta{}, m)
+}
+
+// metaOrigin describes every value as text/plain.
+type metaOrigin struct{ fake.NoDelayOrigin }
+
+func (o *metaOrigin) FetchWithMeta(key string, timeout time.Duration) (io.ReadCloser, *time.Time, string, map[string]string) {
+	rc, exp := o.Fetch(key, timeout)
+	return rc, exp, "text/plain", map[string]string{"Origin": "meta"}
+}
+
+func TestMetaOrigin(t *testing.T) {
+
+	opts := OptionsDefault
+	opts.O = &metaOrigin{}
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	b, m, v, err := e.GetWithMeta("a")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", string(b))
+	assert.Equal(t, "text/plain", m.ContentType)
+	assert.Equal(t, map[string]string{"Origin": "meta"}, m.Headers)
+	assert.NotZero(t, v)
+	assert.False(t, m.Created.IsZero())
+	assert.Equal(t, int64(1)+m.Size(), e.Stats().PayloadSize)
+
+	_, m2, v2, _ := e.GetWithMeta("a")
+	assert.Equal(t, m, m2)
+	assert.Equal(t, v, v2)
+}
@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/wv0m56/prefixed/clock"
+)
+
+// shrinkStep is the number of payload bytes evicted per write lock while
+// shrinking down to a lowered MaxPayloadTotalSize.
+const shrinkStep = 1024 * 1024
+
+// OptionsUpdate holds the options UpdateOptions can change at runtime, see
+// Options. Zero fields are left unchanged.
+type OptionsUpdate struct {
+	MaxPayloadTotalSize        int64
+	CacheFillTimeout           time.Duration
+	TtlTickStep                time.Duration
+	EvictPolicyTickStep        time.Duration
+	EvictPolicyRelevanceWindow time.Duration
+}
+
+// Options returns the options of the engine, as given to NewEngine and
+// changed by UpdateOptions since.
+func (e *Engine) Options() Options {
+	e.tuneMu.Lock()
+	defer e.tuneMu.Unlock()
+	return e.opts
+}
+
+// UpdateOptions applies the non-zero fields of u, after checking the
+// resulting options like NewEngine does. Nothing changes if they are
+// invalid.
+//
+// A lowered MaxPayloadTotalSize is enforced by evicting rows in steps of
+// about a megabyte, releasing the lock in between, before UpdateOptions
+// returns. New tick steps restart the TTL and eviction loops. A new relevance
+// window applies to the keys already in it.
+func (e *Engine) UpdateOptions(u OptionsUpdate) error {
+
+	e.tuneMu.Lock()
+	defer e.tuneMu.Unlock()
+
+	opts := e.opts
+	if u.MaxPayloadTotalSize != 0 {
+		opts.MaxPayloadTotalSize = u.MaxPayloadTotalSize
+	}
+	if u.CacheFillTimeout != 0 {
+		opts.CacheFillTimeout = u.CacheFillTimeout
+	}
+	if u.TtlTickStep != 0 {
+		opts.TtlTickStep = u.TtlTickStep
+	}
+	if u.EvictPolicyTickStep != 0 {
+		opts.EvictPolicyTickStep = u.EvictPolicyTickStep
+	}
+	if u.EvictPolicyRelevanceWindow != 0 {
+		opts.EvictPolicyRelevanceWindow = u.EvictPolicyRelevanceWindow
+	}
+	if err := validateOptions(&opts); err != nil {
+		return err
+	}
+	old := e.opts
+	e.opts = opts
+
+	e.rwm.Lock()
+	e.maxPayloadTotalSize = opts.MaxPayloadTotalSize
+	e.timeout = opts.CacheFillTimeout
+	closed := e.closed
+	e.rwm.Unlock()
+
+	e.ep.Lock()
+	e.ep.relevanceWindow = opts.EvictPolicyRelevanceWindow
+	if e.ep.distinct != nil {
+		e.ep.distinct.span = opts.EvictPolicyRelevanceWindow / distinctGenerations
+	}
+	e.ep.Unlock()
+
+	if !closed {
+		if opts.TtlTickStep != old.TtlTickStep {
+			e.ttlLoop.stop()
+			e.ttlLoop = startTickLoop(e.ts.startLoop, e.clock.NewTicker(opts.TtlTickStep))
+		}
+		if opts.EvictPolicyTickStep != old.EvictPolicyTickStep {
+			e.epLoop.stop()
+			e.epLoop = startTickLoop(e.ep.startLoop, e.clock.NewTicker(opts.EvictPolicyTickStep))
+		}
+	}
+
+	if opts.MaxPayloadTotalSize < old.MaxPayloadTotalSize {
+		e.shrink()
+	}
+	return nil
+}
+
+// shrink evicts rows until the payload fits in MaxPayloadTotalSize, a step
+// at a time so that other operations get the lock in between.
+func (e *Engine) shrink() {
+	for {
+		e.rwm.Lock()
+		excess := e.dataStore.PayloadSize() - e.maxPayloadTotalSize
+		if excess <= 0 || e.dataStore.Len() == 0 {
+			e.unlock()
+			return
+		}
+		if excess > shrinkStep {
+			excess = shrinkStep
+		}
+		e.evictUntilFree(int(e.maxPayloadTotalSize - e.dataStore.PayloadSize() + excess - 1))
+		e.unlock()
+		runtime.Gosched()
+	}
+}
+
+// tickLoop is a background loop run upon the ticks of a ticker until stopped.
+type tickLoop struct {
+	quit chan struct{}
+	done chan struct{}
+}
+
+func startTickLoop(run func(clock.Ticker, <-chan struct{}), t clock.Ticker) *tickLoop {
+	l := &tickLoop{make(chan struct{}), make(chan struct{})}
+	go func() {
+		defer close(l.done)
+		run(t, l.quit)
+	}()
+	return l
+}
+
+// stop stops the loop and waits for the tick being handled, if any.
+func (l *tickLoop) stop() {
+	close(l.quit)
+	<-l.done
+}
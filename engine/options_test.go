@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/clock"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+func TestUpdateOptions(t *testing.T) {
+
+	clk := clock.NewManual(time.Now())
+	opts := OptionsDefault
+	opts.O = &fake.DelayedOrigin{}
+	opts.Clock = clk
+	opts.MaxPayloadTotalSize = 30 * 1000 * 1000
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+	defer e.Close()
+
+	// invalid updates change nothing
+	assert.NotNil(t, e.UpdateOptions(OptionsUpdate{MaxPayloadTotalSize: 1000}))
+	assert.NotNil(t, e.UpdateOptions(OptionsUpdate{
+		CacheFillTimeout:    time.Second,
+		EvictPolicyTickStep: 2 * opts.EvictPolicyRelevanceWindow,
+	}))
+	assert.Equal(t, opts.CacheFillTimeout, e.Options().CacheFillTimeout)
+
+	// fill timeout, DelayedOrigin takes 100ms
+	assert.Nil(t, e.UpdateOptions(OptionsUpdate{CacheFillTimeout: 50 * time.Millisecond}))
+	assert.Equal(t, 50*time.Millisecond, e.Options().CacheFillTimeout)
+	_, err = e.GetCopy("slow")
+	assert.NotNil(t, err)
+
+	// relevance window
+	assert.Nil(t, e.UpdateOptions(OptionsUpdate{EvictPolicyRelevanceWindow: time.Hour}))
+	e.ep.Lock()
+	assert.Equal(t, time.Hour, e.ep.relevanceWindow)
+	e.ep.Unlock()
+
+	// the TTL loop ticks at the new step
+	e.SetWithTTL("ttl", []byte("v"), time.Second)
+	assert.Nil(t, e.UpdateOptions(OptionsUpdate{TtlTickStep: time.Minute}))
+	clk.Advance(2 * time.Second)
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, e.Has("ttl"))
+	clk.Advance(time.Minute)
+	eventually(t, func() bool { return !e.Has("ttl") })
+
+	// shrinking evicts down to the new limit
+	val := make([]byte, 1000*1000)
+	for i := 0; i < 25; i++ {
+		e.Set(fmt.Sprintf("big%02d", i), val)
+	}
+	assert.Equal(t, int64(25*1000*1000), e.Stats().PayloadSize)
+
+	assert.Nil(t, e.UpdateOptions(OptionsUpdate{MaxPayloadTotalSize: 12 * 1000 * 1000}))
+	st := e.Stats()
+	assert.True(t, st.PayloadSize <= 12*1000*1000, st.PayloadSize)
+	assert.True(t, st.Len >= 10, st.Len)
+	assert.Equal(t, int64(12*1000*1000), st.MaxPayloadTotalSize)
+
+	// writes evict against the new limit
+	for i := 0; i < 25; i++ {
+		e.Set(fmt.Sprintf("new%02d", i), val)
+	}
+	assert.True(t, e.Stats().PayloadSize <= 12*1000*1000)
+
+	// growing back is free
+	assert.Nil(t, e.UpdateOptions(OptionsUpdate{MaxPayloadTotalSize: 30 * 1000 * 1000}))
+	assert.Equal(t, int64(30*1000*1000), e.Options().MaxPayloadTotalSize)
+}
@@ -0,0 +1,127 @@
+package engine
+
+// prefixTrie maps key prefixes to values and finds the longest prefix of a key
+// having a value. It is meant for small sets of configuration rules, not for
+// cache rows. Not thread safe.
+type prefixTrie struct {
+	root trieNode
+	len  int
+}
+
+type trieNode struct {
+	children map[byte]*trieNode
+	val      interface{}
+	hasVal   bool
+}
+
+// set associates v with prefix p, overwriting any existing value.
+func (pt *prefixTrie) set(p string, v interface{}) {
+
+	n := &pt.root
+	for i := 0; i < len(p); i++ {
+		if n.children == nil {
+			n.children = map[byte]*trieNode{}
+		}
+		child, ok := n.children[p[i]]
+		if !ok {
+			child = &trieNode{}
+			n.children[p[i]] = child
+		}
+		n = child
+	}
+
+	if !n.hasVal {
+		pt.len++
+	}
+	n.val, n.hasVal = v, true
+}
+
+// del removes the value associated with prefix p. Emptied nodes are left in
+// place, rule sets are expected to be small and rarely shrink.
+func (pt *prefixTrie) del(p string) {
+
+	n := &pt.root
+	for i := 0; i < len(p) && n != nil; i++ {
+		n = n.children[p[i]]
+	}
+
+	if n != nil && n.hasVal {
+		n.val, n.hasVal = nil, false
+		pt.len--
+	}
+}
+
+// longest returns the value associated with the longest prefix of key, which
+// may be key itself or "". ok is false if no prefix of key has a value.
+func (pt *prefixTrie) longest(key string) (v interface{}, ok bool) {
+
+	if pt.len == 0 {
+		return nil, false
+	}
+
+	n := &pt.root
+	for i := 0; ; i++ {
+
+		if n.hasVal {
+			v, ok = n.val, true
+		}
+
+		if i == len(key) {
+			return
+		}
+
+		if n = n.children[key[i]]; n == nil {
+			return
+		}
+	}
+}
+
+// get returns the value associated with exactly p.
+func (pt *prefixTrie) get(p string) (interface{}, bool) {
+
+	n := &pt.root
+	for i := 0; i < len(p) && n != nil; i++ {
+		n = n.children[p[i]]
+	}
+
+	if n == nil || !n.hasVal {
+		return nil, false
+	}
+	return n.val, true
+}
+
+// each calls fn with the value of every prefix of key having one, shortest
+// first.
+func (pt *prefixTrie) each(key string, fn func(v interface{})) {
+
+	if pt.len == 0 {
+		return
+	}
+
+	n := &pt.root
+	for i := 0; n != nil; i++ {
+
+		if n.hasVal {
+			fn(n.val)
+		}
+
+		if i == len(key) {
+			return
+		}
+		n = n.children[key[i]]
+	}
+}
+
+// all calls fn with every value, in no particular order.
+func (pt *prefixTrie) all(fn func(v interface{})) {
+	pt.root.all(fn)
+}
+
+func (n *trieNode) all(fn func(v interface{})) {
+	if n.hasVal {
+		fn(n.val)
+	}
+	for _, c := range n.children {
+		c.all(fn)
+	}
+}
@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// internals
+func TestPrefixTrie(t *testing.T) {
+
+	pt := &prefixTrie{}
+	_, ok := pt.longest("abc")
+	assert.False(t, ok)
+
+	pt.set("a", 1)
+	pt.set("abc", 3)
+	pt.set("b", 2)
+	pt.set("b", 22)
+	assert.Equal(t, 3, pt.len)
+
+	for key, want := range map[string]interface{}{
+		"a": 1, "ab": 1, "abc": 3, "abcd": 3, "b": 22, "bb": 22, "c": nil, "": nil,
+	} {
+		v, _ := pt.longest(key)
+		assert.Equal(t, want, v, key)
+	}
+
+	pt.set("", 0)
+	v, ok := pt.longest("c")
+	assert.True(t, ok)
+	assert.Equal(t, 0, v)
+
+	pt.del("abc")
+	pt.del("zzz")
+	v, _ = pt.longest("abcd")
+	assert.Equal(t, 1, v)
+	assert.Equal(t, 3, pt.len)
+
+	v, ok = pt.get("ab")
+	assert.False(t, ok)
+	v, ok = pt.get("b")
+	assert.True(t, ok)
+	assert.Equal(t, 22, v)
+
+	var vals []interface{}
+	pt.each("abcd", func(v interface{}) { vals = append(vals, v) })
+	assert.Equal(t, []interface{}{0, 1}, vals)
+}
@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/wv0m56/prefixed/skiplist"
+)
+
+// RemovalReason tells why a row left the cache, see Options.OnRemove.
+type RemovalReason int
+
+const (
+	// Evicted rows were removed to free up space.
+	Evicted RemovalReason = iota
+
+	// Expired rows were removed by the TTL loop.
+	Expired
+
+	// Invalidated rows were removed by Invalidate.
+	Invalidated
+
+	// Replaced rows were overwritten by Set or SetWithTTL.
+	Replaced
+)
+
+func (r RemovalReason) String() string {
+	switch r {
+	case Evicted:
+		return "evicted"
+	case Expired:
+		return "expired"
+	case Invalidated:
+		return "invalidated"
+	case Replaced:
+		return "replaced"
+	default:
+		return "unknown"
+	}
+}
+
+type removal struct {
+	key    string
+	val    []byte
+	reason RemovalReason
+}
+
+// rowRemoved notifies watchers of a row which left the data store and queues
+// the OnRemove callback. Needs the write lock, released through unlock.
+func (e *Engine) rowRemoved(el *skiplist.Element, reason EventReason, now time.Time) {
+
+	e.stats.removed(reason)
+	e.watch.publish(el.Key(), reason, now)
+
+	if e.onRemove == nil {
+		return
+	}
+
+	var rr RemovalReason
+	switch reason {
+	case EventExpire:
+		rr = Expired
+	case EventInvalidate:
+		rr = Invalidated
+	default:
+		rr = Evicted
+	}
+	e.removals = append(e.removals, removal{el.Key(), el.ValCopy(), rr})
+}
+
+// unlock releases the write lock, then calls OnRemove for the rows removed
+// while holding it.
+func (e *Engine) unlock() {
+
+	rs := e.removals
+	e.removals = nil
+	e.rwm.Unlock()
+
+	for _, r := range rs {
+		e.onRemove(r.key, r.val, r.reason)
+	}
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/clock"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+func TestOnRemove(t *testing.T) {
+
+	var (
+		mu      sync.Mutex
+		removed []string
+		e       *Engine
+	)
+
+	clk := clock.NewManual(time.Now())
+	opts := OptionsDefault
+	opts.O = &fake.NoDelayOrigin{}
+	opts.TtlTickStep = 1 * time.Millisecond
+	opts.Clock = clk
+	opts.OnRemove = func(key string, val []byte, reason RemovalReason) {
+		e.GetTTL(key) // would deadlock if called under the engine lock
+		mu.Lock()
+		removed = append(removed, key+"="+string(val)+":"+reason.String())
+		mu.Unlock()
+	}
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	got := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		r := removed
+		removed = nil
+		return r
+	}
+
+	e.Get("a")
+	e.Get("b")
+	e.Get("c")
+	assert.Nil(t, got())
+
+	e.Set("a", []byte("new"))
+	assert.Equal(t, []string{"a=a:replaced"}, got())
+
+	e.Invalidate("b", "nobody")
+	assert.Equal(t, []string{"b=b:invalidated"}, got())
+
+	e.Expire("c", time.Second)
+	clk.Advance(time.Second + time.Millisecond)
+	eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(removed) == 1
+	})
+	assert.Equal(t, []string{"c=c:expired"}, got())
+
+	e.rwm.Lock()
+	e.evictUntilFree(int(e.maxPayloadTotalSize))
+	e.unlock()
+	assert.Equal(t, []string{"a=new:evicted"}, got())
+}
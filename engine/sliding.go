@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"time"
+)
+
+// SetSliding makes key expire after being idle (not read via Get) for idle.
+// Each successful Get pushes the expiry of key idle into the future, and the
+// expiry given by the origin upon cache fill is overridden. The setting is
+// dropped along with the row when it leaves the cache, or by Persist.
+// A non-positive idle removes the setting, leaving the current TTL as is.
+// It returns false if key is not in the cache.
+func (e *Engine) SetSliding(key string, idle time.Duration) bool {
+
+	e.rwm.Lock()
+	defer e.rwm.Unlock()
+
+	if _, ok := e.dataStore.Get(key); !ok {
+		return false
+	}
+
+	if idle <= 0 {
+		delete(e.ts.idle, key)
+		return true
+	}
+
+	e.ts.idle[key] = idle
+	e.ts.Set(key, e.clock.Now().Add(idle))
+	return true
+}
+
+// SetSlidingPrefix makes all keys having prefix p, including those not cached
+// yet, expire after being idle for idle (see SetSliding). A per key setting
+// takes precedence over prefix settings, and the longest matching prefix wins
+// among the latter. The expiry of rows already in the cache is only updated
+// upon their next Get. A non-positive idle removes the setting for p.
+func (e *Engine) SetSlidingPrefix(p string, idle time.Duration) {
+
+	e.rwm.Lock()
+	defer e.rwm.Unlock()
+
+	if idle <= 0 {
+		e.ts.idlePrefix.del(p)
+	} else {
+		e.ts.idlePrefix.set(p, idle)
+	}
+}
+
+// idleTimeout returns the sliding expiration setting applying to key, or 0.
+// A per key setting of 0 is left by Persist. Needs at least the read lock.
+func (ts *ttlStore) idleTimeout(key string) time.Duration {
+
+	if idle, ok := ts.idle[key]; ok {
+		return idle
+	}
+	if v, ok := ts.idlePrefix.longest(key); ok {
+		return v.(time.Duration)
+	}
+	return 0
+}
+
+// slide queues pushing the expiry of key to expiry. Reads only hold the read
+// lock, so the new expiry is applied in batch by the TTL loop upon its next
+// tick.
+func (ts *ttlStore) slide(key string, expiry time.Time) {
+	ts.slideMu.Lock()
+	ts.pending[key] = expiry
+	ts.slideMu.Unlock()
+}
+
+func (ts *ttlStore) takePending() map[string]time.Time {
+
+	ts.slideMu.Lock()
+	defer ts.slideMu.Unlock()
+
+	if len(ts.pending) == 0 {
+		return nil
+	}
+	p := ts.pending
+	ts.pending = map[string]time.Time{}
+	return p
+}
+
+// Needs the write lock. Rows which left the cache or lost their sliding
+// setting since being read, e.g. upon Persist, are skipped.
+func (ts *ttlStore) applySlides(pending map[string]time.Time) {
+	for k, exp := range pending {
+		if _, ok := ts.e.dataStore.Get(k); ok && ts.idleTimeout(k) > 0 {
+			ts.e.ts.Set(k, exp)
+		}
+	}
+}
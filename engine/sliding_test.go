@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/clock"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+func TestSliding(t *testing.T) {
+
+	clk := clock.NewManual(time.Now())
+	opts := OptionsDefault
+	opts.O = &fake.ExpiringOrigin{} // 24h expiry, overridden by sliding
+	opts.TtlTickStep = 1 * time.Millisecond
+	opts.Clock = clk
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	assert.False(t, e.SetSliding("a", 10*time.Second))
+	e.Get("a")
+	e.Get("b")
+	assert.True(t, e.SetSliding("a", 10*time.Second))
+	assert.Equal(t, 10.0, e.GetTTL("a")[0])
+
+	e.SetSlidingPrefix("sess:", 10*time.Second)
+	e.SetSlidingPrefix("sess:long:", time.Minute)
+	e.Get("sess:1")
+	e.Get("sess:long:1")
+	assert.Equal(t, []float64{10, 60}, e.GetTTL("sess:1", "sess:long:1"))
+
+	// reads push expiry forward, applied on the next tick
+	clk.Advance(8 * time.Second)
+	e.Get("a")
+	e.Get("sess:1")
+	clk.Advance(5 * time.Second)
+	clk.Advance(time.Millisecond) // the previous tick has been handled by now
+	assert.Equal(t, "absess:1sess:long:1", keys(e))
+	secs := e.GetTTL("a", "sess:1")
+	assert.True(t, roughly(5, secs[0]))
+	assert.True(t, roughly(5, secs[1]))
+
+	// idle for too long
+	clk.Advance(6 * time.Second)
+	eventually(t, func() bool { return keys(e) == "bsess:long:1" })
+
+	// per key setting dropped along with the row, refill uses the origin expiry
+	e.Get("a")
+	assert.True(t, roughly(24*3600, e.GetTTL("a")[0]))
+
+	// removing the longer prefix setting falls back to the shorter one
+	e.SetSlidingPrefix("sess:long:", 0)
+	e.Get("sess:long:1")
+	clk.Advance(5 * time.Second)
+	clk.Advance(time.Millisecond)
+	assert.True(t, roughly(10-5.001, e.GetTTL("sess:long:1")[0]))
+
+	// Persist wins over the prefix setting, including a slide already queued
+	e.Get("sess:long:1")
+	assert.True(t, e.Persist("sess:long:1"))
+	clk.Advance(time.Second)
+	clk.Advance(time.Millisecond)
+	assert.Equal(t, -1.0, e.GetTTL("sess:long:1")[0])
+	e.Get("sess:long:1")
+	clk.Advance(time.Second)
+	clk.Advance(time.Millisecond)
+	assert.Equal(t, -1.0, e.GetTTL("sess:long:1")[0])
+
+	// until the row leaves the cache
+	e.Invalidate("sess:long:1")
+	e.Get("sess:long:1")
+	assert.Equal(t, 10.0, e.GetTTL("sess:long:1")[0])
+}
@@ -0,0 +1,180 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Snapshots are streams of JSON lines: a SnapshotHeader followed by one
+// SnapshotRow per row, in key order. Values are base64 encoded and expiries
+// absolute, so a snapshot restored later only brings back the rows which
+// haven't expired meanwhile. Row metadata is kept, versions are not.
+const (
+	SnapshotFormat  = "prefixed-snapshot"
+	SnapshotVersion = 1
+)
+
+// snapshotBatch is the number of rows copied per read lock by Snapshot.
+const snapshotBatch = 1024
+
+// SnapshotHeader is the first line of a snapshot.
+type SnapshotHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+}
+
+// SnapshotRow is a row of a snapshot. Expires is nil for rows without TTL,
+// Meta for rows without metadata.
+type SnapshotRow struct {
+	Key     string     `json:"key"`
+	Val     []byte     `json:"val"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Meta    *Meta      `json:"meta,omitempty"`
+}
+
+// SnapshotWriter writes a snapshot, e.g. from rows read over the network.
+type SnapshotWriter struct {
+	bw  *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewSnapshotWriter writes the header of a snapshot created at now to w.
+func NewSnapshotWriter(w io.Writer, now time.Time) (*SnapshotWriter, error) {
+	bw := bufio.NewWriter(w)
+	sw := &SnapshotWriter{bw, json.NewEncoder(bw)}
+	return sw, sw.enc.Encode(&SnapshotHeader{SnapshotFormat, SnapshotVersion, now})
+}
+
+// Write writes r. Rows must be written in key order.
+func (sw *SnapshotWriter) Write(r *SnapshotRow) error {
+	return sw.enc.Encode(r)
+}
+
+// Flush writes buffered rows to the underlying writer.
+func (sw *SnapshotWriter) Flush() error {
+	return sw.bw.Flush()
+}
+
+// SnapshotReader reads a snapshot.
+type SnapshotReader struct {
+	dec *json.Decoder
+	hdr SnapshotHeader
+}
+
+// NewSnapshotReader reads and checks the header of the snapshot in r.
+func NewSnapshotReader(r io.Reader) (*SnapshotReader, error) {
+
+	sr := &SnapshotReader{dec: json.NewDecoder(bufio.NewReader(r))}
+	if err := sr.dec.Decode(&sr.hdr); err != nil {
+		return nil, fmt.Errorf("invalid snapshot header: %v", err)
+	}
+	if sr.hdr.Format != SnapshotFormat {
+		return nil, errors.New("not a snapshot")
+	}
+	if sr.hdr.Version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", sr.hdr.Version)
+	}
+	return sr, nil
+}
+
+// Header returns the header of the snapshot.
+func (sr *SnapshotReader) Header() SnapshotHeader {
+	return sr.hdr
+}
+
+// Read returns the next row, or io.EOF after the last one.
+func (sr *SnapshotReader) Read() (*SnapshotRow, error) {
+	var r SnapshotRow
+	if err := sr.dec.Decode(&r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Snapshot writes every row to w and returns the number of rows written.
+// Rows are copied by batches under the read lock, so a snapshot of a busy
+// engine is not a point in time image: rows written meanwhile may or may not
+// be part of it. Sliding expiration settings are not part of snapshots, a
+// sliding row is saved with its current expiry.
+func (e *Engine) Snapshot(w io.Writer) (int, error) {
+
+	sw, err := NewSnapshotWriter(w, e.clock.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	var after string
+	for first := true; ; first = false {
+
+		rows := e.snapshotBatch(after, first)
+		for i := range rows {
+			if err := sw.Write(&rows[i]); err != nil {
+				return n, err
+			}
+			n++
+		}
+
+		if len(rows) < snapshotBatch {
+			return n, sw.Flush()
+		}
+		after = rows[len(rows)-1].Key
+	}
+}
+
+// snapshotBatch copies the rows following after, or from after included if
+// first.
+func (e *Engine) snapshotBatch(after string, first bool) []SnapshotRow {
+
+	e.rwm.RLock()
+	defer e.rwm.RUnlock()
+
+	rows := make([]SnapshotRow, 0, snapshotBatch)
+	for it := e.dataStore.Seek(after); it != nil && len(rows) < snapshotBatch; it = it.Next() {
+		if !first && it.Key() == after {
+			continue
+		}
+		r := SnapshotRow{Key: it.Key(), Val: it.ValCopy(), Meta: it.Meta().Copy()}
+		if exp, ok := e.ts.Get(it.Key()); ok {
+			r.Expires = &exp
+		}
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+// Restore writes the rows of the snapshot in r as Set and SetWithTTL would, or
+// as SetWithMeta for rows with metadata, skipping expired rows, and returns
+// the number of rows written. Rows restored before an error remain in the
+// cache.
+func (e *Engine) Restore(r io.Reader) (int, error) {
+
+	sr, err := NewSnapshotReader(r)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for {
+		row, err := sr.Read()
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+
+		var ttl time.Duration
+		if row.Expires != nil {
+			if ttl = row.Expires.Sub(e.clock.Now()); ttl <= 0 {
+				continue
+			}
+		}
+		e.set(row.Key, row.Val, ttl, row.Meta, writeAlways, 0)
+		n++
+	}
+}
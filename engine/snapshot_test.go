@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/clock"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+func TestSnapshot(t *testing.T) {
+
+	clk := clock.NewManual(time.Now())
+	opts := OptionsDefault
+	opts.O = &fake.NoDelayOrigin{}
+	opts.Clock = clk
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	// more than a batch
+	n := snapshotBatch + 10
+	for i := 0; i < n; i++ {
+		e.Set(fmt.Sprintf("k%05d", i), []byte{byte(i), 0, 255})
+	}
+	e.SetWithTTL("short", []byte("s"), time.Second)
+	e.SetWithTTL("long", []byte("l"), time.Hour)
+	e.SetWithMeta("meta", []byte("m"), 0, Meta{ContentType: "text/plain", Headers: map[string]string{"a": "b"}})
+
+	var buf bytes.Buffer
+	written, err := e.Snapshot(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, n+3, written)
+	assert.Equal(t, n+4, strings.Count(buf.String(), "\n"))
+
+	sr, err := NewSnapshotReader(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, clk.Now().Unix(), sr.Header().Created.Unix())
+	row, err := sr.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, &SnapshotRow{"k00000", []byte{0, 0, 255}, nil, nil}, row)
+
+	// restored later, short has expired
+	clk.Advance(2 * time.Second)
+	opts.Clock = clk
+	e2, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	restored, err := e2.Restore(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, n+2, restored)
+	assert.Equal(t, strings.Replace(keys(e), "short", "", 1), keys(e2))
+	assert.False(t, e2.Has("short"))
+
+	b, err := e2.GetCopy(fmt.Sprintf("k%05d", 300))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{byte(300 % 256), 0, 255}, b)
+
+	ttl, ok := e2.TTL("long")
+	assert.True(t, ok)
+	assert.Equal(t, time.Hour-2*time.Second, ttl)
+	_, ok = e2.TTL("k00001")
+	assert.False(t, ok)
+
+	// metadata is restored
+	_, m, _, err := e2.GetWithMeta("meta")
+	assert.Nil(t, err)
+	assert.Equal(t, "text/plain", m.ContentType)
+	assert.Equal(t, map[string]string{"a": "b"}, m.Headers)
+	assert.True(t, clk.Now().Add(-2*time.Second).Equal(m.Created))
+
+	_, err = e2.Restore(strings.NewReader(`{"format":"other","version":1}` + "\n"))
+	assert.NotNil(t, err)
+	_, err = e2.Restore(strings.NewReader(`{"format":"prefixed-snapshot","version":2}` + "\n"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/wv0m56/prefixed/plugin/origin"
+)
+
+// Stats is a point in time snapshot of the engine counters, see Engine.Stats.
+// Counters are cumulative since NewEngine.
+type Stats struct {
+
+	// Hits and Misses count Get, GetCopy and GetWithTTL calls served from
+	// the cache and calls which needed a cache fill, respectively. Callers
+	// joining a fill in progress count as misses.
+	Hits   uint64
+	Misses uint64
+
+	// Fills counts successful cache fills, FillErrors failed ones. Fills
+	// superseded by Set count as neither.
+	Fills      uint64
+	FillErrors uint64
+	Sets       uint64
+
+	// Rows removed from the cache, by cause.
+	Evictions     uint64
+	Expirations   uint64
+	Invalidations uint64
+
+	// FillLatency is the distribution of the time taken to fetch and buffer
+	// a payload from origin, errors included.
+	FillLatency LatencyHistogram
+
+	// Classes breaks Hits and Misses down by Options.StatsPrefixClasses.
+	// Keys having none of them are counted under "".
+	Classes map[string]ClassStats
+
+	InFlightFills       int
+	GraveyardSize       int
+	TTLCount            int
+	Len                 int64
+	PayloadSize         int64
+	MaxPayloadTotalSize int64
+
+	// Origin is what the origin reports if it implements origin.Reporter,
+	// nil otherwise.
+	Origin map[string]float64
+}
+
+// HitRate returns Hits / (Hits + Misses), or 0 before the first Get.
+func (s *Stats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// ClassStats counts Get calls on keys of a prefix class.
+type ClassStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// latencyBounds are the upper bounds of the histogram buckets.
+var latencyBounds = [...]time.Duration{
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// LatencyHistogram counts observations into buckets. Counts[i] is the number
+// of observations <= Bounds[i] and greater than the previous bound. The last
+// element of Counts is the overflow bucket.
+type LatencyHistogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// Quantile returns the upper bound of the bucket holding the q-th quantile,
+// q being in [0, 1]. Overflowing quantiles return the last bound.
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+
+	if h.Count == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(h.Count)))
+	if rank == 0 {
+		rank = 1
+	}
+
+	var n uint64
+	for i, c := range h.Counts {
+		n += c
+		if n >= rank && i < len(h.Bounds) {
+			return h.Bounds[i]
+		}
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+// engineStats holds the counters behind Stats. They are updated atomically so
+// that the read path doesn't need the write lock.
+type engineStats struct {
+	hits, misses                          uint64
+	fills, fillErrors, sets               uint64
+	evictions, expirations, invalidations uint64
+	fillLatencySum                        uint64
+	fillLatencyBuckets                    [len(latencyBounds) + 1]uint64
+}
+
+type classStats struct {
+	hits, misses uint64
+}
+
+// newClassStats maps each prefix class to its counters, "" included.
+func newClassStats(classes []string) *prefixTrie {
+	pt := &prefixTrie{}
+	pt.set("", &classStats{})
+	for _, p := range classes {
+		pt.set(p, &classStats{})
+	}
+	return pt
+}
+
+// classOf returns the counters of the longest prefix class of key.
+func (e *Engine) classOf(key string) *classStats {
+	v, _ := e.classes.longest(key)
+	return v.(*classStats)
+}
+
+func (es *engineStats) observeFill(d time.Duration) {
+
+	i := 0
+	for i < len(latencyBounds) && d > latencyBounds[i] {
+		i++
+	}
+	atomic.AddUint64(&es.fillLatencyBuckets[i], 1)
+	atomic.AddUint64(&es.fillLatencySum, uint64(d))
+}
+
+func (es *engineStats) removed(reason EventReason) {
+	switch reason {
+	case EventEvict:
+		atomic.AddUint64(&es.evictions, 1)
+	case EventExpire:
+		atomic.AddUint64(&es.expirations, 1)
+	case EventInvalidate:
+		atomic.AddUint64(&es.invalidations, 1)
+	}
+}
+
+// Stats returns a snapshot of the engine counters and sizes.
+func (e *Engine) Stats() Stats {
+
+	es := e.stats
+	s := Stats{
+		Hits:          atomic.LoadUint64(&es.hits),
+		Misses:        atomic.LoadUint64(&es.misses),
+		Fills:         atomic.LoadUint64(&es.fills),
+		FillErrors:    atomic.LoadUint64(&es.fillErrors),
+		Sets:          atomic.LoadUint64(&es.sets),
+		Evictions:     atomic.LoadUint64(&es.evictions),
+		Expirations:   atomic.LoadUint64(&es.expirations),
+		Invalidations: atomic.LoadUint64(&es.invalidations),
+	}
+
+	s.FillLatency.Bounds = append([]time.Duration(nil), latencyBounds[:]...)
+	s.FillLatency.Counts = make([]uint64, len(es.fillLatencyBuckets))
+	for i := range es.fillLatencyBuckets {
+		s.FillLatency.Counts[i] = atomic.LoadUint64(&es.fillLatencyBuckets[i])
+		s.FillLatency.Count += s.FillLatency.Counts[i]
+	}
+	s.FillLatency.Sum = time.Duration(atomic.LoadUint64(&es.fillLatencySum))
+
+	s.Classes = map[string]ClassStats{}
+	for _, p := range e.classNames {
+		cs := e.classOf(p)
+		s.Classes[p] = ClassStats{atomic.LoadUint64(&cs.hits), atomic.LoadUint64(&cs.misses)}
+	}
+	e.rwm.RLock()
+	s.MaxPayloadTotalSize = e.maxPayloadTotalSize
+	s.InFlightFills = len(e.fillCond)
+	s.TTLCount = e.ts.Len()
+	s.Len = e.dataStore.Len()
+	s.PayloadSize = e.dataStore.PayloadSize()
+	e.rwm.RUnlock()
+
+	e.ep.Lock()
+	s.GraveyardSize = len(e.ep.graveyard)
+	e.ep.Unlock()
+
+	if r, ok := e.o.(origin.Reporter); ok {
+		s.Origin = r.Report()
+	}
+
+	return s
+}
@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/clock"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+	"github.com/wv0m56/prefixed/plugin/origin/resilience"
+)
+
+func TestStats(t *testing.T) {
+
+	clk := clock.NewManual(time.Now())
+	opts := OptionsDefault
+	opts.O = resilience.NewLimiter(&fake.NoDelayOrigin{}, 4)
+	opts.TtlTickStep = 1 * time.Millisecond
+	opts.Clock = clk
+	opts.StatsPrefixClasses = []string{"a", "bench", "z"}
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	s := e.Stats()
+	assert.Equal(t, 0.0, s.HitRate())
+	assert.Equal(t, time.Duration(0), s.FillLatency.Quantile(0.5))
+
+	e.Get("a")
+	e.Get("a")
+	e.Get("a")
+	e.Get("b")
+	e.Get("c")
+	_, err = e.Get("bench error")
+	assert.NotNil(t, err)
+	e.Set("d", []byte("dddd"))
+	e.Invalidate("b")
+	e.Expire("c", time.Second)
+	clk.Advance(time.Second + time.Millisecond)
+	eventually(t, func() bool { return e.Stats().Expirations == 1 })
+	e.rwm.Lock()
+	e.evictUntilFree(int(e.maxPayloadTotalSize))
+	e.unlock()
+
+	s = e.Stats()
+	assert.Equal(t, uint64(2), s.Hits)
+	assert.Equal(t, uint64(4), s.Misses)
+	assert.Equal(t, 2.0/6, s.HitRate())
+	assert.Equal(t, uint64(3), s.Fills)
+	assert.Equal(t, uint64(1), s.FillErrors)
+	assert.Equal(t, uint64(1), s.Sets)
+	assert.Equal(t, uint64(2), s.Evictions)
+	assert.Equal(t, uint64(1), s.Expirations)
+	assert.Equal(t, uint64(1), s.Invalidations)
+	assert.Equal(t, 0, s.InFlightFills)
+	assert.Equal(t, 0, s.TTLCount)
+	assert.Equal(t, int64(0), s.Len)
+	assert.Equal(t, int64(0), s.PayloadSize)
+	assert.Equal(t, opts.MaxPayloadTotalSize, s.MaxPayloadTotalSize)
+	assert.Equal(t, map[string]ClassStats{
+		"": {0, 2}, "a": {2, 1}, "bench": {0, 1}, "z": {0, 0},
+	}, s.Classes)
+
+	// fake fills take less than a millisecond
+	assert.Equal(t, uint64(4), s.FillLatency.Count)
+	assert.Equal(t, uint64(4), s.FillLatency.Counts[0])
+	assert.Equal(t, time.Millisecond, s.FillLatency.Quantile(0.99))
+
+	assert.Equal(t, 4.0, s.Origin["limiter_limit"])
+	assert.Equal(t, 0.0, s.Origin["limiter_in_flight"])
+}
+
+func TestFillLatencyWallClock(t *testing.T) {
+
+	opts := OptionsDefault
+	opts.O = &fake.DelayedOrigin{} // 100ms per fetch
+	opts.Clock = clock.NewManual(time.Now())
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	e.Get("a")
+	s := e.Stats()
+	assert.Equal(t, uint64(1), s.FillLatency.Count)
+	assert.True(t, s.FillLatency.Sum >= 100*time.Millisecond)
+}
+
+func TestLatencyHistogramQuantile(t *testing.T) {
+
+	var es engineStats
+	for i := 0; i < 90; i++ {
+		es.observeFill(3 * time.Millisecond)
+	}
+	for i := 0; i < 9; i++ {
+		es.observeFill(200 * time.Millisecond)
+	}
+	es.observeFill(time.Minute)
+
+	h := LatencyHistogram{Bounds: latencyBounds[:]}
+	for _, c := range es.fillLatencyBuckets {
+		h.Counts = append(h.Counts, c)
+		h.Count += c
+	}
+
+	assert.Equal(t, 5*time.Millisecond, h.Quantile(0))
+	assert.Equal(t, 5*time.Millisecond, h.Quantile(0.9))
+	assert.Equal(t, 250*time.Millisecond, h.Quantile(0.95))
+	assert.Equal(t, 250*time.Millisecond, h.Quantile(0.99))
+	assert.Equal(t, 5*time.Second, h.Quantile(1))
+}
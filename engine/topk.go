@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"container/heap"
+	"sort"
+	"strings"
+
+	"github.com/tylertreat/BoomFilters"
+)
+
+// KeyCount is a key, or a key prefix, with its estimated number of reads
+// inside the relevance window.
+type KeyCount struct {
+	Key   string
+	Count uint64
+}
+
+// TopKeys returns the k most read keys inside the relevance window, most read
+// first. Counts are count-min sketch estimates and k is capped by
+// Options.TopKeysCapacity. Reads are accounted asynchronously, so a read may
+// take a moment to show up.
+func (e *Engine) TopKeys(k int) []KeyCount {
+
+	e.ep.Lock()
+	defer e.ep.Unlock()
+
+	if e.ep.hot == nil {
+		return nil
+	}
+	return e.ep.hot.keys.top(k)
+}
+
+// TopPrefixes is like TopKeys except that reads are counted by key prefix made
+// of the first depth segments of keys, delimiter included. With ":" as
+// Options.KeyDelimiter, "user:42:name" counts under "user:" at depth 1 and
+// under "user:42:" at depth 2. Keys having fewer segments are not counted.
+// It returns nil if depth is not between 1 and Options.TopPrefixesMaxDepth.
+func (e *Engine) TopPrefixes(k, depth int) []KeyCount {
+
+	e.ep.Lock()
+	defer e.ep.Unlock()
+
+	if e.ep.hot == nil || depth < 1 || depth > len(e.ep.hot.prefixes) {
+		return nil
+	}
+	return e.ep.hot.prefixes[depth-1].top(k)
+}
+
+// hotKeys tracks heavy hitters among keys and key prefixes. It is guarded by
+// the evictPolicy lock and fed by the evictPolicy count-min sketch.
+type hotKeys struct {
+	keys      *topK
+	prefixCMS *boom.CountMinSketch
+	prefixes  []*topK // by depth-1
+	delim     string
+}
+
+func newHotKeys(capacity, maxDepth int, delim string) *hotKeys {
+
+	if capacity == 0 {
+		return nil
+	}
+
+	hk := &hotKeys{newTopK(capacity), nil, nil, delim}
+	if maxDepth > 0 {
+		hk.prefixCMS = boom.NewCountMinSketch(0.001, 0.99)
+		for i := 0; i < maxDepth; i++ {
+			hk.prefixes = append(hk.prefixes, newTopK(capacity))
+		}
+	}
+	return hk
+}
+
+// read accounts a read of key, count being its new estimate.
+func (hk *hotKeys) read(key string, count uint64) {
+
+	hk.keys.update(key, count)
+	for d, p := range keySegments(key, hk.delim, len(hk.prefixes)) {
+		hk.prefixCMS.Add([]byte(p))
+		hk.prefixes[d].update(p, hk.prefixCMS.Count([]byte(p)))
+	}
+}
+
+// del forgets the count reads of key.
+func (hk *hotKeys) del(key string, count uint64) {
+
+	hk.keys.update(key, 0)
+	for d, p := range keySegments(key, hk.delim, len(hk.prefixes)) {
+		hk.prefixCMS.TestAndRemove([]byte(p), count)
+		hk.prefixes[d].update(p, hk.prefixCMS.Count([]byte(p)))
+	}
+}
+
+// keySegments returns the prefixes of key made of its first 1, 2, ... segments,
+// up to maxDepth segments.
+func keySegments(key, delim string, maxDepth int) (ps []string) {
+
+	for i := 0; len(ps) < maxDepth; {
+		j := strings.Index(key[i:], delim)
+		if j < 0 {
+			break
+		}
+		i += j + len(delim)
+		ps = append(ps, key[:i])
+	}
+	return
+}
+
+// topK keeps the capacity keys with the highest counts seen, using a min-heap
+// so that the smallest one can be replaced in O(logN).
+type topK struct {
+	capacity int
+	h        topKHeap
+	m        map[string]*topKItem
+}
+
+type topKItem struct {
+	KeyCount
+	index int
+}
+
+func newTopK(capacity int) *topK {
+	return &topK{capacity, nil, map[string]*topKItem{}}
+}
+
+// update sets the count of key. A zero count removes key.
+func (tk *topK) update(key string, count uint64) {
+
+	if it, ok := tk.m[key]; ok {
+		if count == 0 {
+			heap.Remove(&tk.h, it.index)
+			delete(tk.m, key)
+		} else {
+			it.Count = count
+			heap.Fix(&tk.h, it.index)
+		}
+		return
+	}
+
+	if count == 0 {
+		return
+	}
+
+	if len(tk.h) < tk.capacity {
+		it := &topKItem{KeyCount{key, count}, 0}
+		heap.Push(&tk.h, it)
+		tk.m[key] = it
+		return
+	}
+
+	if min := tk.h[0]; count > min.Count {
+		delete(tk.m, min.Key)
+		min.KeyCount = KeyCount{key, count}
+		tk.m[key] = min
+		heap.Fix(&tk.h, 0)
+	}
+}
+
+// top returns the k items with the highest counts, highest first.
+func (tk *topK) top(k int) []KeyCount {
+
+	if k < 0 {
+		k = 0
+	}
+
+	kcs := make([]KeyCount, len(tk.h))
+	for i, it := range tk.h {
+		kcs[i] = it.KeyCount
+	}
+	sort.Slice(kcs, func(i, j int) bool {
+		if kcs[i].Count != kcs[j].Count {
+			return kcs[i].Count > kcs[j].Count
+		}
+		return kcs[i].Key < kcs[j].Key
+	})
+
+	if k < len(kcs) {
+		kcs = kcs[:k]
+	}
+	return kcs
+}
+
+type topKHeap []*topKItem
+
+func (h topKHeap) Len() int           { return len(h) }
+func (h topKHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topKHeap) Push(x interface{}) {
+	it := x.(*topKItem)
+	it.index = len(*h)
+	*h = append(*h, it)
+}
+
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
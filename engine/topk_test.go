@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+// internals
+func TestTopK(t *testing.T) {
+
+	tk := newTopK(3)
+	assert.Equal(t, []KeyCount{}, tk.top(3))
+
+	tk.update("a", 1)
+	tk.update("b", 5)
+	tk.update("c", 3)
+	tk.update("d", 2) // replaces a
+	tk.update("e", 1) // not enough
+	assert.Equal(t, []KeyCount{{"b", 5}, {"c", 3}, {"d", 2}}, tk.top(10))
+	assert.Equal(t, []KeyCount{{"b", 5}}, tk.top(1))
+	assert.Equal(t, []KeyCount{}, tk.top(-1))
+
+	tk.update("d", 9)
+	tk.update("b", 0)
+	assert.Equal(t, []KeyCount{{"d", 9}, {"c", 3}}, tk.top(10))
+	tk.update("e", 1)
+	assert.Equal(t, []KeyCount{{"d", 9}, {"c", 3}, {"e", 1}}, tk.top(10))
+	assert.Equal(t, 3, len(tk.m))
+
+	assert.Equal(t, []string{"a::", "a::b::"}, keySegments("a::b::c::d", "::", 2))
+	assert.Equal(t, []string{"a::"}, keySegments("a::b", "::", 2))
+	assert.Nil(t, keySegments("ab", "::", 2))
+	assert.Nil(t, keySegments("a:b", ":", 0))
+	assert.Nil(t, newHotKeys(0, 2, ":"))
+}
+
+func TestTopKeys(t *testing.T) {
+
+	// disabled by default
+	opts := OptionsDefault
+	opts.O = &fake.NoDelayOrigin{}
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+	e.Get("a")
+	assert.Nil(t, e.ep.hot)
+	assert.Nil(t, e.TopKeys(1))
+
+	opts.TopKeysCapacity = 4
+	e, err = NewEngine(&opts)
+	assert.Nil(t, err)
+
+	// writes aren't reads
+	for i := 0; i < 100; i++ {
+		e.Set("w:1", nil)
+	}
+
+	for i := 0; i < 10; i++ {
+		k := strconv.Itoa(i)
+		for j := 0; j <= i; j++ {
+			e.Get("user:" + k)
+		}
+		e.Get("org:" + k + ":name")
+	}
+	e.Get("org:1:age")
+
+	// reads are accounted asynchronously
+	eventually(t, func() bool {
+		top := e.TopPrefixes(10, 1)
+		return len(top) == 2 && top[0].Count == 55 && top[1].Count == 11
+	})
+	assert.Equal(t, []KeyCount{{"user:", 55}, {"org:", 11}}, e.TopPrefixes(10, 1))
+	assert.Equal(t, []KeyCount{{"user:9", 10}, {"user:8", 9}}, e.TopKeys(2))
+	assert.Equal(t, 4, len(e.TopKeys(10)))
+	assert.Equal(t, KeyCount{"org:1:", 2}, e.TopPrefixes(10, 2)[0])
+	assert.Nil(t, e.TopPrefixes(10, 3))
+	assert.Nil(t, e.TopPrefixes(10, 0))
+
+	// rows leaving the cache are forgotten
+	e.Invalidate("user:9")
+	eventually(t, func() bool { return e.TopKeys(1)[0].Key == "user:8" })
+	assert.Equal(t, uint64(45), e.TopPrefixes(1, 1)[0].Count)
+
+	opts.TopPrefixesMaxDepth = 1
+	opts.KeyDelimiter = ""
+	e, err = NewEngine(&opts)
+	assert.Nil(t, e)
+	assert.NotNil(t, err)
+}
@@ -1,18 +1,41 @@
 package engine
 
 import (
+	"sync"
 	"time"
 
-	"github.com/wv0m56/prefixed/skiplist"
+	"github.com/wv0m56/prefixed/clock"
 )
 
+// setExpiry sets the expiry of a row filled from origin. Expiry is moved
+// earlier by a random jitter, if configured, so that rows given the same
+// expiry by the origin don't all leave the cache at once. Jitter never moves
+// expiry before the Min of the TTL rule applying to key. Needs the write
+// lock, which also guards e.rand.
 func (e *Engine) setExpiry(key string, expiry time.Time) {
 
-	if de, ok := e.ts.m[key]; ok {
-		e.ts.DelElement(de)
+	if e.expiryJitter > 0 || e.expiryJitterRatio > 0 {
+
+		now := e.clock.Now()
+		j := e.expiryJitter
+		if ttl := expiry.Sub(now); ttl > 0 {
+			j += time.Duration(e.expiryJitterRatio * float64(ttl))
+		}
+
+		if j > 0 {
+			floor := now
+			if v, ok := e.ttlRules.longest(key); ok {
+				floor = now.Add(v.(*TTLRule).Min)
+			}
+
+			expiry = expiry.Add(-time.Duration(e.rand.Int63n(int64(j))))
+			if expiry.Before(floor) {
+				expiry = floor
+			}
+		}
 	}
-	insertedTTL := e.ts.Insert(expiry, key)
-	e.ts.m[key] = insertedTTL
+
+	e.ts.Set(key, expiry)
 }
 
 // GetTTL returns the number of seconds left until expiry for the given keys, in
@@ -20,12 +43,15 @@ func (e *Engine) setExpiry(key string, expiry time.Time) {
 // Keys without TTL yields negative values.
 func (e *Engine) GetTTL(keys ...string) []float64 {
 
+	e.rwm.RLock()
+	defer e.rwm.RUnlock()
+
 	var t []float64
-	now := time.Now()
+	now := e.clock.Now()
 	for _, k := range keys {
-		d, ok := e.ts.m[k]
+		exp, ok := e.ts.Get(k)
 		if ok {
-			t = append(t, d.Key().Sub(now).Seconds())
+			t = append(t, exp.Sub(now).Seconds())
 		} else {
 			t = append(t, -1)
 		}
@@ -33,43 +59,136 @@ func (e *Engine) GetTTL(keys ...string) []float64 {
 	return t
 }
 
+// TTL returns the time left until key expires, which is negative if key
+// expired and is about to be deleted. ok is false if key has no TTL.
+func (e *Engine) TTL(key string) (ttl time.Duration, ok bool) {
+
+	e.rwm.RLock()
+	defer e.rwm.RUnlock()
+
+	exp, ok := e.ts.Get(key)
+	if !ok {
+		return 0, false
+	}
+	return exp.Sub(e.clock.Now()), true
+}
+
+// Expire sets key to expire d from now, overwriting any existing TTL.
+// It returns false if key is not in the cache.
+func (e *Engine) Expire(key string, d time.Duration) bool {
+	return e.ExpireAt(key, e.clock.Now().Add(d))
+}
+
+// ExpireAt sets key to expire at t, overwriting any existing TTL.
+// It returns false if key is not in the cache.
+func (e *Engine) ExpireAt(key string, t time.Time) bool {
+
+	e.rwm.Lock()
+	defer e.rwm.Unlock()
+
+	if _, ok := e.dataStore.Get(key); !ok {
+		return false
+	}
+	e.ts.Set(key, t)
+	return true
+}
+
+// Persist removes the TTL of key so that it only leaves the cache upon
+// eviction or invalidation. Sliding expiration no longer applies to the row,
+// be it set per key or by prefix, until it leaves the cache or SetSliding is
+// called for key. It returns false if key has no TTL.
+func (e *Engine) Persist(key string) bool {
+
+	e.rwm.Lock()
+	defer e.rwm.Unlock()
+
+	if _, ok := e.ts.Get(key); !ok {
+		return false
+	}
+	e.ts.Del(key)
+	e.ts.idle[key] = 0 // overrides prefix settings, see idleTimeout
+	return true
+}
+
+// Touch extends the TTL of key by d. It returns false if key has no TTL.
+func (e *Engine) Touch(key string, d time.Duration) bool {
+
+	e.rwm.Lock()
+	defer e.rwm.Unlock()
+
+	exp, ok := e.ts.Get(key)
+	if !ok {
+		return false
+	}
+	e.ts.Set(key, exp.Add(d))
+	return true
+}
+
+// ExpirePrefix sets all keys having prefix p to expire d from now in a single
+// locked pass, overwriting existing TTLs. It returns the number of keys
+// affected.
+func (e *Engine) ExpirePrefix(p string, d time.Duration) int {
+
+	e.rwm.Lock()
+	defer e.rwm.Unlock()
+
+	t := e.clock.Now().Add(d)
+	els := e.dataStore.GetByPrefix(p)
+	for _, el := range els {
+		e.ts.Set(el.Key(), t)
+	}
+	return len(els)
+}
+
 type ttlStore struct {
-	skiplist.Duplist
-	m map[string]*skiplist.DupElement
+	TTLIndex
 	e *Engine
+
+	// sliding expiration, see sliding.go
+	idle       map[string]time.Duration
+	idlePrefix *prefixTrie
+	slideMu    sync.Mutex
+	pending    map[string]time.Time
 }
 
 // to be invoked as a goroutine e.g. go startLoop()
-func (ts *ttlStore) startLoop(step time.Duration) {
+// The ticker is created by the caller so that it is registered with the clock
+// by the time the goroutine is spawned.
+func (ts *ttlStore) startLoop(t clock.Ticker, stop <-chan struct{}) {
 
-	for range time.Tick(step) {
+	defer t.Stop()
+	for {
+		var now time.Time
+		select {
+		case <-stop:
+			return
+		case now = <-t.C():
+		}
 
 		var somethingExpired bool
-		now := time.Now()
+		pending := ts.takePending()
 
 		ts.e.rwm.RLock()
-		if f := ts.First(); f != nil && now.After(f.Key()) {
-			somethingExpired = true
-		}
+		somethingExpired = ts.Expired(now)
 		ts.e.rwm.RUnlock()
 
-		if somethingExpired {
+		if somethingExpired || pending != nil {
 			ts.e.rwm.Lock()
-			for f := ts.First(); f != nil && now.After(f.Key()); f = ts.First() {
-				ts.DelFirst()
-				delete(ts.m, f.Val())
-				if el := ts.e.dataStore.Del(f.Val()); el != nil {
+			ts.applySlides(pending)
+			for _, k := range ts.PopExpired(now, ts.e.maxExpiredPerTick) {
+				delete(ts.idle, k)
+				if el := ts.e.dataStore.Del(k); el != nil {
 					go ts.e.ep.dataDeletion(el.Key()) // stats, no need to be precise
+					ts.e.rowRemoved(el, EventExpire, now)
 				}
 			}
-			ts.e.rwm.Unlock()
+			ts.e.unlock()
 		}
 	}
 }
 
 // no lock
 func (ts *ttlStore) del(key string) {
-	de, _ := ts.e.ts.m[key]
-	ts.DelElement(de)
-	delete(ts.m, key)
+	ts.Del(key)
+	delete(ts.idle, key)
 }
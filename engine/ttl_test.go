@@ -1,18 +1,22 @@
 package engine
 
 import (
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/clock"
 	"github.com/wv0m56/prefixed/plugin/origin/fake"
 )
 
 func TestTTL(t *testing.T) {
 
+	clk := clock.NewManual(time.Now())
 	opts := OptionsDefault
 	opts.O = &fake.NoDelayOrigin{}
 	opts.TtlTickStep = 1 * time.Millisecond
+	opts.Clock = clk
 	e, err := NewEngine(&opts)
 	assert.Nil(t, err)
 
@@ -24,39 +28,27 @@ func TestTTL(t *testing.T) {
 	e.Get("f")
 
 	setTTL := func(key string, ttl time.Duration) {
-		e.setExpiry(key, time.Now().Add(ttl))
+		e.rwm.Lock()
+		e.setExpiry(key, clk.Now().Add(ttl))
+		e.rwm.Unlock()
 	}
 
 	setTTL("c", 19*time.Millisecond)
 	setTTL("f", 25*time.Millisecond)
 	setTTL("z", 11*time.Millisecond)
 
-	vals := ""
-	e.rwm.RLock()
-	for it := e.dataStore.First(); it != nil; it = it.Next() {
-		vals += it.Key()
-	}
-	e.rwm.RUnlock()
-	assert.Equal(t, "abcdef", vals)
+	assert.Equal(t, "abcdef", keys(e))
+
+	// nothing expires before its time
+	clk.Advance(18 * time.Millisecond)
+	assert.Equal(t, "abcdef", keys(e))
 
 	// confirm element deletion after expiry
-	time.Sleep(20 * time.Millisecond)
-	vals = ""
-	e.rwm.RLock()
-	for it := e.dataStore.First(); it != nil; it = it.Next() {
-		vals += it.Key()
-	}
-	e.rwm.RUnlock()
-	assert.Equal(t, "abdef", vals)
+	clk.Advance(2 * time.Millisecond)
+	eventually(t, func() bool { return keys(e) == "abdef" })
 
-	time.Sleep(6 * time.Millisecond)
-	vals = ""
-	e.rwm.RLock()
-	for it := e.dataStore.First(); it != nil; it = it.Next() {
-		vals += it.Key()
-	}
-	e.rwm.RUnlock()
-	assert.Equal(t, "abde", vals)
+	clk.Advance(6 * time.Millisecond)
+	eventually(t, func() bool { return keys(e) == "abde" })
 
 	// GetTTL
 	setTTL("d", 15*time.Second)
@@ -64,11 +56,11 @@ func TestTTL(t *testing.T) {
 	secs := e.GetTTL("a", "d", "ff")
 	assert.Equal(t, 3, len(secs))
 
-	assert.True(t, roughly(24.0, secs[0]))
-	assert.True(t, roughly(15.0, secs[1]))
+	assert.Equal(t, 24.0, secs[0])
+	assert.Equal(t, 15.0, secs[1])
 	assert.Equal(t, -1.0, secs[2])
 
-	time.Sleep(50 * time.Millisecond)
+	clk.Advance(50 * time.Millisecond)
 
 	secs = e.GetTTL("a", "d", "ff")
 	assert.True(t, roughly(23.95, secs[0]))
@@ -77,7 +69,72 @@ func TestTTL(t *testing.T) {
 	// Overwrite existing TTL
 	setTTL("a", 700*time.Second)
 	secs = e.GetTTL("a", "d", "ff")
-	assert.True(t, roughly(700, secs[0]))
+	assert.Equal(t, 700.0, secs[0])
+}
+
+func TestTTLAPI(t *testing.T) {
+
+	clk := clock.NewManual(time.Now())
+	opts := OptionsDefault
+	opts.O = &fake.NoDelayOrigin{}
+	opts.TtlTickStep = 1 * time.Millisecond
+	opts.Clock = clk
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	for _, k := range []string{"a", "b", "user:1", "user:2", "user:3"} {
+		e.Get(k)
+	}
+
+	assert.False(t, e.Expire("missing", time.Second))
+	assert.True(t, e.Expire("a", 10*time.Second))
+	assert.True(t, e.ExpireAt("b", clk.Now().Add(20*time.Second)))
+	assert.Equal(t, []float64{10, 20}, e.GetTTL("a", "b"))
+	ttl, ok := e.TTL("a")
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Second, ttl)
+	_, ok = e.TTL("user:1")
+	assert.False(t, ok)
+
+	// Touch extends, Persist drops
+	assert.True(t, e.Touch("a", 5*time.Second))
+	assert.Equal(t, 15.0, e.GetTTL("a")[0])
+	assert.True(t, e.Persist("a"))
+	assert.Equal(t, -1.0, e.GetTTL("a")[0])
+	assert.False(t, e.Persist("a"))
+	assert.False(t, e.Touch("a", time.Second))
+
+	assert.Equal(t, 3, e.ExpirePrefix("user:", 30*time.Second))
+	assert.Equal(t, 0, e.ExpirePrefix("nobody:", 30*time.Second))
+	assert.Equal(t, []float64{30, 30, 30}, e.GetTTL("user:1", "user:2", "user:3"))
+	assert.True(t, e.Persist("user:2"))
+
+	clk.Advance(21 * time.Second)
+	eventually(t, func() bool { return keys(e) == "auser:1user:2user:3" })
+	clk.Advance(10 * time.Second)
+	eventually(t, func() bool { return keys(e) == "auser:2" })
+}
+
+// keys concatenates all keys inside the data store.
+func keys(e *Engine) string {
+	vals := ""
+	e.rwm.RLock()
+	for it := e.dataStore.First(); it != nil; it = it.Next() {
+		vals += it.Key()
+	}
+	e.rwm.RUnlock()
+	return vals
+}
+
+// eventually fails the test if cond does not become true within a second.
+// Background loops handle ticks of a manual clock asynchronously.
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+	}
 }
 
 func roughly(a, b float64) bool {
@@ -86,3 +143,78 @@ func roughly(a, b float64) bool {
 	}
 	return false
 }
+
+func TestExpiryJitter(t *testing.T) {
+
+	opts := OptionsDefault
+	opts.ExpiryJitter = -1
+	e, err := NewEngine(&opts)
+	assert.Nil(t, e)
+	assert.NotNil(t, err)
+
+	opts = OptionsDefault
+	opts.ExpiryJitterRatio = 1
+	e, err = NewEngine(&opts)
+	assert.Nil(t, e)
+	assert.NotNil(t, err)
+
+	opts = OptionsDefault
+	opts.O = &fake.ExpiringOrigin{} // 24h expiry
+	opts.ExpiryJitter = time.Hour
+	opts.ExpiryJitterRatio = 0.5
+	e, err = NewEngine(&opts)
+	assert.Nil(t, err)
+
+	distinct := map[float64]struct{}{}
+	for i := 0; i < 100; i++ {
+		k := strconv.Itoa(i)
+		e.Get(k)
+		secs := e.GetTTL(k)[0]
+		assert.True(t, secs > 11*3600 && secs <= 24*3600)
+		distinct[secs] = struct{}{}
+	}
+	assert.True(t, len(distinct) > 90)
+
+	// explicit TTLs are exact
+	e.Expire("0", time.Hour)
+	assert.True(t, roughly(3600, e.GetTTL("0")[0]))
+
+	// jitter never goes below the Min of a TTL rule
+	opts.TTLRules = []TTLRule{{Prefix: "m:", Min: 20 * time.Hour}}
+	e, err = NewEngine(&opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		k := "m:" + strconv.Itoa(i)
+		e.Get(k)
+		assert.True(t, e.GetTTL(k)[0] >= 20*3600-1)
+	}
+
+	// sliding rows aren't jittered
+	e.SetSlidingPrefix("s:", time.Hour)
+	e.Get("s:1")
+	assert.True(t, roughly(3600, e.GetTTL("s:1")[0]))
+}
+
+func TestTtlMaxDeletesPerTick(t *testing.T) {
+
+	clk := clock.NewManual(time.Now())
+	opts := OptionsDefault
+	opts.O = &fake.NoDelayOrigin{}
+	opts.TtlTickStep = 1 * time.Millisecond
+	opts.TtlMaxDeletesPerTick = 3
+	opts.Clock = clk
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 8; i++ {
+		e.Get(strconv.Itoa(i))
+	}
+	assert.Equal(t, 8, e.ExpirePrefix("", time.Second))
+
+	clk.Advance(time.Second + time.Millisecond)
+	eventually(t, func() bool { return len(keys(e)) == 5 })
+	clk.Advance(time.Millisecond)
+	eventually(t, func() bool { return len(keys(e)) == 2 })
+	clk.Advance(time.Millisecond)
+	eventually(t, func() bool { return len(keys(e)) == 0 })
+}
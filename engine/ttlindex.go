@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/wv0m56/prefixed/skiplist"
+)
+
+// TTLIndex keeps track of the expiry of keys for the TTL loop.
+// Implementations need not be thread safe, the engine guards them with its
+// RWMutex. Methods named like read-only operations must not mutate anything,
+// as they are called under the read lock.
+type TTLIndex interface {
+
+	// Set sets the expiry of key, overwriting any existing one.
+	Set(key string, expiry time.Time)
+
+	// Get returns the expiry of key according to the comma-ok idiom.
+	Get(key string) (time.Time, bool)
+
+	// Del removes key from the index. It does nothing if key is absent.
+	Del(key string)
+
+	// Len returns the number of keys inside the index.
+	Len() int
+
+	// Expired is a cheap read-only check telling whether PopExpired(now, ...)
+	// might have work to do. False positives are allowed, false negatives
+	// are not.
+	Expired(now time.Time) bool
+
+	// PopExpired removes and returns keys whose expiry is before now, at most
+	// max of them if max > 0.
+	PopExpired(now time.Time, max int) []string
+}
+
+// TTLIndexKind selects the TTLIndex implementation used by an Engine.
+type TTLIndexKind int
+
+const (
+	// TTLIndexDuplist sorts expiries inside a skiplist.Duplist. Inserts and
+	// deletes are O(logN) time comparisons. It is the default.
+	TTLIndexDuplist TTLIndexKind = iota
+
+	// TTLIndexWheel hashes expiries into a hierarchical timing wheel whose
+	// resolution is Options.TtlTickStep. Inserts and deletes are O(1), which
+	// pays off with millions of short TTLs.
+	TTLIndexWheel
+)
+
+func newTTLIndex(kind TTLIndexKind, maxHeight int, resolution time.Duration, now time.Time) TTLIndex {
+	switch kind {
+	case TTLIndexWheel:
+		return newTimingWheel(resolution, now)
+	default:
+		return &duplistIndex{
+			*(skiplist.NewDuplist(maxHeight)),
+			map[string]*skiplist.DupElement{},
+		}
+	}
+}
+
+// duplistIndex is a Duplist plus a map pointing to each key's element, so
+// that a key's expiry can be found or deleted.
+type duplistIndex struct {
+	skiplist.Duplist
+	m map[string]*skiplist.DupElement
+}
+
+func (di *duplistIndex) Set(key string, expiry time.Time) {
+
+	if de, ok := di.m[key]; ok {
+		di.DelElement(de)
+	}
+	di.m[key] = di.Insert(expiry, key)
+}
+
+func (di *duplistIndex) Get(key string) (time.Time, bool) {
+	if de, ok := di.m[key]; ok {
+		return de.Key(), true
+	}
+	return time.Time{}, false
+}
+
+func (di *duplistIndex) Del(key string) {
+	di.DelElement(di.m[key])
+	delete(di.m, key)
+}
+
+func (di *duplistIndex) Len() int {
+	return len(di.m)
+}
+
+func (di *duplistIndex) Expired(now time.Time) bool {
+	f := di.First()
+	return f != nil && now.After(f.Key())
+}
+
+func (di *duplistIndex) PopExpired(now time.Time, max int) (keys []string) {
+	for f := di.First(); f != nil && now.After(f.Key()) &&
+		(max <= 0 || len(keys) < max); f = di.First() {
+
+		di.DelFirst()
+		delete(di.m, f.Val())
+		keys = append(keys, f.Val())
+	}
+	return
+}
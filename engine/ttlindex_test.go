@@ -0,0 +1,158 @@
+package engine
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/clock"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+var ttlIndexKinds = map[string]TTLIndexKind{
+	"duplist": TTLIndexDuplist,
+	"wheel":   TTLIndexWheel,
+}
+
+// internals
+// Both implementations are checked against a map doing the obvious thing.
+func TestTTLIndexConformance(t *testing.T) {
+
+	for name, kind := range ttlIndexKinds {
+
+		r := rand.New(rand.NewSource(1))
+		origin := time.Now()
+		now := origin
+		idx := newTTLIndex(kind, 16, time.Millisecond, origin)
+		model := map[string]time.Time{}
+
+		for step := 0; step < 20000; step++ {
+
+			key := strconv.Itoa(r.Intn(2000))
+			switch op := r.Intn(10); {
+
+			case op < 5:
+				// mostly short TTLs, a few beyond the wheel's range
+				d := time.Duration(r.Int63n(int64(2 * time.Second)))
+				switch r.Intn(50) {
+				case 0:
+					d = time.Duration(r.Int63n(int64(100 * 24 * time.Hour)))
+				case 1:
+					d = -d
+				}
+				idx.Set(key, now.Add(d))
+				model[key] = now.Add(d)
+
+			case op < 6:
+				idx.Del(key)
+				delete(model, key)
+
+			default:
+				now = now.Add(time.Duration(r.Int63n(int64(5 * time.Millisecond))))
+				if r.Intn(500) == 0 {
+					now = now.Add(time.Duration(r.Int63n(int64(200 * time.Hour))))
+				}
+
+				var want []string
+				for k, exp := range model {
+					if now.After(exp) {
+						want = append(want, k)
+					}
+				}
+
+				assert.Equal(t, len(want) > 0 || idx.Expired(now), idx.Expired(now), name)
+				got := idx.PopExpired(now, 0)
+				sort.Strings(want)
+				sort.Strings(got)
+				if !assert.Equal(t, want, got, name) {
+					return
+				}
+				for _, k := range got {
+					delete(model, k)
+				}
+			}
+
+			assert.Equal(t, len(model), idx.Len(), name)
+			exp, ok := idx.Get(key)
+			mexp, mok := model[key]
+			assert.Equal(t, mok, ok, name)
+			assert.True(t, exp.Equal(mexp), name)
+		}
+
+		// max per pop
+		for i := 0; i < 10; i++ {
+			idx.Set(strconv.Itoa(i), now.Add(-time.Second))
+		}
+		now = now.Add(time.Millisecond)
+		assert.Equal(t, 4, len(idx.PopExpired(now, 4)), name)
+		assert.True(t, idx.Expired(now), name)
+		assert.Equal(t, 4, len(idx.PopExpired(now, 4)), name)
+		assert.True(t, len(idx.PopExpired(now.Add(100*24*time.Hour), 0)) >= 2, name)
+		assert.False(t, idx.Expired(now.Add(101*24*time.Hour)), name)
+		assert.Equal(t, 0, idx.Len(), name)
+	}
+}
+
+func TestTTLIndexEngine(t *testing.T) {
+
+	clk := clock.NewManual(time.Now())
+	opts := OptionsDefault
+	opts.O = &fake.NoDelayOrigin{}
+	opts.TtlTickStep = 1 * time.Millisecond
+	opts.TTLIndex = TTLIndexWheel
+	opts.Clock = clk
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		e.Get(strconv.Itoa(i))
+		e.Expire(strconv.Itoa(i), time.Duration(i)*time.Second+time.Millisecond)
+	}
+	assert.Equal(t, 10, e.ts.Len())
+
+	clk.Advance(5 * time.Second)
+	eventually(t, func() bool { return keys(e) == "56789" })
+	clk.Advance(5 * time.Second)
+	eventually(t, func() bool { return keys(e) == "" })
+
+	opts.TTLIndex = 42
+	e, err = NewEngine(&opts)
+	assert.Nil(t, e)
+	assert.NotNil(t, err)
+}
+
+func benchmarkTTLIndexSet(b *testing.B, kind TTLIndexKind) {
+	now := time.Now()
+	idx := newTTLIndex(kind, 22, time.Millisecond, now)
+	keys := make([]string, 1<<20)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	r := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.Set(keys[i&(len(keys)-1)], now.Add(time.Duration(r.Int63n(int64(time.Minute)))))
+	}
+}
+
+func benchmarkTTLIndexExpire(b *testing.B, kind TTLIndexKind) {
+	now := time.Now()
+	idx := newTTLIndex(kind, 22, time.Millisecond, now)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < b.N; i++ {
+		idx.Set(strconv.Itoa(i), now.Add(time.Duration(r.Int63n(int64(time.Minute)))))
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; {
+		now = now.Add(time.Millisecond)
+		n += len(idx.PopExpired(now, 0))
+	}
+}
+
+func BenchmarkTTLIndexSetDuplist(b *testing.B)    { benchmarkTTLIndexSet(b, TTLIndexDuplist) }
+func BenchmarkTTLIndexSetWheel(b *testing.B)      { benchmarkTTLIndexSet(b, TTLIndexWheel) }
+func BenchmarkTTLIndexExpireDuplist(b *testing.B) { benchmarkTTLIndexExpire(b, TTLIndexDuplist) }
+func BenchmarkTTLIndexExpireWheel(b *testing.B)   { benchmarkTTLIndexExpire(b, TTLIndexWheel) }
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"errors"
+	"time"
+)
+
+// TTLRule bounds the TTL of rows filled from origin whose key has Prefix.
+// When several rules match a key, the one with the longest Prefix applies.
+// A zero duration field means the corresponding bound is not set.
+type TTLRule struct {
+	Prefix string
+
+	// Default is the TTL given to rows for which the origin returns no expiry.
+	Default time.Duration
+
+	// Min is the lowest TTL a row gets, even if the origin returns an expiry
+	// which is sooner or already in the past.
+	Min time.Duration
+
+	// Max is the highest TTL a row gets. Rows for which the origin returns no
+	// expiry (and without Default) get Max as TTL.
+	Max time.Duration
+}
+
+func validateTTLRules(rules []TTLRule) error {
+
+	seen := map[string]struct{}{}
+	for _, r := range rules {
+
+		if _, dup := seen[r.Prefix]; dup {
+			return errors.New("duplicate TTL rule prefix " + r.Prefix)
+		}
+		seen[r.Prefix] = struct{}{}
+
+		if r.Default < 0 || r.Min < 0 || r.Max < 0 {
+			return errors.New("negative duration in TTL rule " + r.Prefix)
+		}
+
+		if r.Max > 0 && r.Min > r.Max {
+			return errors.New("TTL rule min greater than max " + r.Prefix)
+		}
+
+		if r.Default > 0 && (r.Default < r.Min || (r.Max > 0 && r.Default > r.Max)) {
+			return errors.New("TTL rule default outside of [min, max] " + r.Prefix)
+		}
+	}
+	return nil
+}
+
+func newTTLRules(rules []TTLRule) *prefixTrie {
+	pt := &prefixTrie{}
+	for i := range rules {
+		r := rules[i]
+		pt.set(r.Prefix, &r)
+	}
+	return pt
+}
+
+// applyTTLRules returns the expiry of a row filled from origin, given the
+// expiry returned by origin (nil meaning no TTL) and the current time.
+func (e *Engine) applyTTLRules(key string, exp *time.Time, now time.Time) *time.Time {
+
+	v, ok := e.ttlRules.longest(key)
+	if !ok {
+		return exp
+	}
+	r := v.(*TTLRule)
+
+	if exp == nil && r.Default > 0 {
+		t := now.Add(r.Default)
+		exp = &t
+	}
+
+	if exp == nil {
+		if r.Max > 0 {
+			t := now.Add(r.Max)
+			exp = &t
+		}
+		return exp
+	}
+
+	t := *exp
+	if r.Min > 0 && t.Before(now.Add(r.Min)) {
+		t = now.Add(r.Min)
+	}
+	if r.Max > 0 && t.After(now.Add(r.Max)) {
+		t = now.Add(r.Max)
+	}
+	return &t
+}
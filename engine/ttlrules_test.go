@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/clock"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+func TestTTLRulesSanity(t *testing.T) {
+
+	for _, rules := range [][]TTLRule{
+		{{Prefix: "a", Min: -time.Second}},
+		{{Prefix: "a", Min: 2 * time.Second, Max: time.Second}},
+		{{Prefix: "a", Default: time.Hour, Max: time.Minute}},
+		{{Prefix: "a", Default: time.Second, Min: time.Minute}},
+		{{Prefix: "a"}, {Prefix: "a"}},
+	} {
+		opts := OptionsDefault
+		opts.TTLRules = rules
+		e, err := NewEngine(&opts)
+		assert.Nil(t, e)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestTTLRules(t *testing.T) {
+
+	clk := clock.NewManual(time.Now())
+	opts := OptionsDefault
+	opts.Clock = clk
+	opts.TTLRules = []TTLRule{
+		{Prefix: "", Max: time.Hour},
+		{Prefix: "cfg:", Default: time.Minute},
+		{Prefix: "cfg:pinned:"}, // no bounds, shadows the rules above
+		{Prefix: "day:", Min: 25 * time.Hour, Max: 48 * time.Hour},
+		{Prefix: "min:", Min: time.Minute},
+	}
+
+	// origin without expiry
+	opts.O = &fake.NoDelayOrigin{}
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+	for _, k := range []string{"x", "cfg:1", "cfg:pinned:1", "min:1"} {
+		e.Get(k)
+	}
+	assert.Equal(t, []float64{3600, 60, -1, -1}, e.GetTTL("x", "cfg:1", "cfg:pinned:1", "min:1"))
+
+	// origin with a 24h expiry
+	opts.O = &fake.ExpiringOrigin{}
+	e, err = NewEngine(&opts)
+	assert.Nil(t, err)
+	for _, k := range []string{"x", "day:1", "cfg:1"} {
+		e.Get(k)
+	}
+	assert.Equal(t, []float64{3600, 25 * 3600}, e.GetTTL("x", "day:1"))
+
+	// only the longest matching rule applies, "cfg:" has no max
+	assert.True(t, roughly(24*3600, e.GetTTL("cfg:1")[0]))
+
+	// origin with an expiry in the past
+	opts.O = &fake.FaultyOrigin{PastExpiryRate: 1}
+	e, err = NewEngine(&opts)
+	assert.Nil(t, err)
+	e.Get("min:1")
+	e.Get("x")
+	assert.Equal(t, []float64{60, -1}, e.GetTTL("min:1", "x"))
+	assert.Equal(t, "min:1", keys(e))
+}
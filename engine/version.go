@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"io/ioutil"
+	"time"
+
+	"github.com/wv0m56/prefixed/skiplist"
+)
+
+// Every write of a row, by Set and friends or by a cache fill, gives it a
+// new version, greater than any version handed out before by the engine and
+// never 0. Versions allow for optimistic concurrency: read a row along its
+// version, then write it back with CompareAndSwap, which fails if the row
+// was written meanwhile. They are not kept by snapshots.
+
+type writeMode int
+
+const (
+	writeAlways writeMode = iota
+	writeIfAbsent
+	writeIfPresent
+	writeIfVersion
+)
+
+// allows tells whether a row may be written given the row it replaces, nil
+// if none, and ver, the version expected by writeIfVersion.
+func (mode writeMode) allows(old *skiplist.Element, ver uint64) bool {
+	switch mode {
+	case writeIfAbsent:
+		return old == nil
+	case writeIfPresent:
+		return old != nil
+	case writeIfVersion:
+		return old != nil && old.Version() == ver
+	default:
+		return true
+	}
+}
+
+// Version returns the version of the row of key, without triggering a cache
+// fill. ok is false if key is not in the cache.
+func (e *Engine) Version(key string) (v uint64, ok bool) {
+
+	e.rwm.RLock()
+	defer e.rwm.RUnlock()
+
+	el, ok := e.dataStore.Get(key)
+	if !ok {
+		return 0, false
+	}
+	return el.Version(), true
+}
+
+// CompareAndSwap is like Set, only if the row of key is at version. It
+// returns the new version of the row, or its current version, 0 if key is
+// not in the cache, and false if it didn't write. Origin is not consulted.
+func (e *Engine) CompareAndSwap(key string, version uint64, val []byte) (uint64, bool) {
+	return e.set(key, val, 0, nil, writeIfVersion, version)
+}
+
+// CompareAndSwapWithMeta is like CompareAndSwap except that key expires ttl
+// from now and that m is stored along the row, see SetWithMeta.
+func (e *Engine) CompareAndSwapWithMeta(key string, version uint64, val []byte, ttl time.Duration, m Meta) (uint64, bool) {
+	return e.set(key, val, ttl, &m, writeIfVersion, version)
+}
+
+// DeleteIfVersion is like Invalidate, only if the row of key is at version.
+// It returns the current version of the row, 0 if key is not in the cache,
+// and whether it deleted the row.
+func (e *Engine) DeleteIfVersion(key string, version uint64) (uint64, bool) {
+
+	e.rwm.Lock()
+	defer e.unlock()
+
+	el, ok := e.dataStore.Get(key)
+	if !ok {
+		return 0, false
+	}
+	if el.Version() != version {
+		return el.Version(), false
+	}
+	e.delDataTsEp(key, EventInvalidate)
+	return version, true
+}
+
+// GetIfNoneMatch is like GetCopy, except that it returns no value and false
+// if the row of key is at version, without copying it. It also returns the
+// version of the row, which is 0 for a value filled from origin but not kept,
+// e.g. already expired. It triggers a cache fill upon cache miss.
+func (e *Engine) GetIfNoneMatch(key string, version uint64) (b []byte, v uint64, modified bool, err error) {
+
+	r, _, v, err := e.get(key)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if v != 0 && v == version {
+		return nil, v, false, nil
+	}
+	b, err = ioutil.ReadAll(r)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return b, v, true, nil
+}
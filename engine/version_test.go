@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+func TestVersion(t *testing.T) {
+
+	opts := OptionsDefault
+	opts.O = &fake.NoDelayOrigin{}
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	_, ok := e.Version("a")
+	assert.False(t, ok)
+
+	// filled rows have a version too
+	b, v1, modified, err := e.GetIfNoneMatch("a", 0)
+	assert.Nil(t, err)
+	assert.True(t, modified)
+	assert.Equal(t, "a", string(b))
+	assert.NotZero(t, v1)
+	v, ok := e.Version("a")
+	assert.True(t, ok)
+	assert.Equal(t, v1, v)
+
+	b, v, modified, err = e.GetIfNoneMatch("a", v1)
+	assert.Nil(t, err)
+	assert.False(t, modified)
+	assert.Nil(t, b)
+	assert.Equal(t, v1, v)
+	_, _, _, err = e.GetIfNoneMatch("bench error", 0)
+	assert.NotNil(t, err)
+
+	// every write bumps the version
+	e.Set("a", []byte("1"))
+	v2, _ := e.Version("a")
+	assert.True(t, v2 > v1)
+	b, v, modified, _ = e.GetIfNoneMatch("a", v1)
+	assert.True(t, modified)
+	assert.Equal(t, "1", string(b))
+	assert.Equal(t, v2, v)
+
+	v, ok = e.CompareAndSwap("a", v1, []byte("2"))
+	assert.False(t, ok)
+	assert.Equal(t, v2, v)
+	v, ok = e.CompareAndSwap("nobody", v1, []byte("2"))
+	assert.False(t, ok)
+	assert.Zero(t, v)
+	assert.False(t, e.Has("nobody"))
+
+	e.Expire("a", time.Hour)
+	v3, ok := e.CompareAndSwap("a", v2, []byte("3"))
+	assert.True(t, ok)
+	assert.True(t, v3 > v2)
+	b, _ = e.GetCopy("a")
+	assert.Equal(t, "3", string(b))
+	_, ok = e.TTL("a")
+	assert.False(t, ok)
+
+	v4, ok := e.CompareAndSwapWithMeta("a", v3, []byte("4"), time.Hour, Meta{Flags: 4})
+	assert.True(t, ok)
+	_, m, v, _ := e.GetWithMeta("a")
+	assert.Equal(t, uint32(4), m.Flags)
+	assert.Equal(t, v4, v)
+	_, ok = e.TTL("a")
+	assert.True(t, ok)
+
+	v, ok = e.DeleteIfVersion("a", v3)
+	assert.False(t, ok)
+	assert.Equal(t, v4, v)
+	assert.True(t, e.Has("a"))
+	v, ok = e.DeleteIfVersion("a", v4)
+	assert.True(t, ok)
+	assert.Equal(t, v4, v)
+	assert.False(t, e.Has("a"))
+	v, ok = e.DeleteIfVersion("a", v4)
+	assert.False(t, ok)
+	assert.Zero(t, v)
+
+	// versions are never handed out twice, even for the same value
+	b, v, modified, _ = e.GetIfNoneMatch("a", v4)
+	assert.True(t, modified)
+	assert.Equal(t, "a", string(b))
+	assert.True(t, v > v4)
+}
@@ -0,0 +1,262 @@
+package engine
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventReason tells why a row changed.
+type EventReason int
+
+const (
+	// EventSet is published when a row is written by Set or SetWithTTL.
+	EventSet EventReason = iota
+
+	// EventFill is published when a row is filled from origin.
+	EventFill
+
+	// EventInvalidate is published when a row is removed by Invalidate.
+	EventInvalidate
+
+	// EventExpire is published when a row is removed by the TTL loop.
+	EventExpire
+
+	// EventEvict is published when a row is removed to free up space.
+	EventEvict
+)
+
+func (r EventReason) String() string {
+	switch r {
+	case EventSet:
+		return "set"
+	case EventFill:
+		return "fill"
+	case EventInvalidate:
+		return "invalidate"
+	case EventExpire:
+		return "expire"
+	case EventEvict:
+		return "evict"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a change of the row associated with Key.
+type Event struct {
+	Key    string
+	Reason EventReason
+	Time   time.Time
+}
+
+// DropPolicy decides which event is lost when the buffer of a subscription is
+// full.
+type DropPolicy int
+
+const (
+	// DropNewest discards the event being published. It is the default.
+	DropNewest DropPolicy = iota
+
+	// DropOldest discards the oldest buffered event to make room for the one
+	// being published.
+	DropOldest
+)
+
+// Subscription delivers events of a Watch or WatchPrefix call on C. Events are
+// published without ever blocking the engine, so a subscriber not keeping up
+// loses events according to the DropPolicy of the engine.
+type Subscription struct {
+	C <-chan Event
+
+	c       chan Event
+	h       *watchHub
+	key     string
+	prefix  bool
+	policy  DropPolicy
+	dropped uint64
+
+	mu     sync.Mutex // guards sends against Close
+	closed bool
+}
+
+// Dropped returns the number of events lost so far because C was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops the delivery of events and closes C. It is safe to call Close
+// more than once.
+func (s *Subscription) Close() {
+
+	s.h.remove(s)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.c)
+	}
+}
+
+func (s *Subscription) send(ev Event) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.c <- ev:
+		return
+	default:
+	}
+
+	atomic.AddUint64(&s.dropped, 1)
+	if s.policy == DropNewest {
+		return
+	}
+
+	// DropOldest, the subscriber may have drained C in the meantime
+	select {
+	case <-s.c:
+	default:
+	}
+	select {
+	case s.c <- ev:
+	default:
+	}
+}
+
+// Watch subscribes to changes of the row associated with key.
+func (e *Engine) Watch(key string) *Subscription {
+	return e.watch.add(key, false)
+}
+
+// WatchPrefix subscribes to changes of all rows whose key has prefix p.
+func (e *Engine) WatchPrefix(p string) *Subscription {
+	return e.watch.add(p, true)
+}
+
+// watchHub dispatches events to subscriptions. It has its own lock, so that
+// subscribing doesn't contend with the engine.
+type watchHub struct {
+	mu       sync.RWMutex
+	n        int64 // number of subscriptions, read atomically as a fast path
+	keys     map[string]map[*Subscription]struct{}
+	prefixes *prefixTrie // of map[*Subscription]struct{}
+	bufSize  int
+	policy   DropPolicy
+}
+
+func newWatchHub(bufSize int, policy DropPolicy) *watchHub {
+	return &watchHub{
+		keys:     map[string]map[*Subscription]struct{}{},
+		prefixes: &prefixTrie{},
+		bufSize:  bufSize,
+		policy:   policy,
+	}
+}
+
+func (h *watchHub) add(key string, prefix bool) *Subscription {
+
+	c := make(chan Event, h.bufSize)
+	s := &Subscription{C: c, c: c, h: h, key: key, prefix: prefix, policy: h.policy}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var set map[*Subscription]struct{}
+	if prefix {
+		if v, ok := h.prefixes.get(key); ok {
+			set = v.(map[*Subscription]struct{})
+		} else {
+			set = map[*Subscription]struct{}{}
+			h.prefixes.set(key, set)
+		}
+	} else {
+		if set = h.keys[key]; set == nil {
+			set = map[*Subscription]struct{}{}
+			h.keys[key] = set
+		}
+	}
+
+	set[s] = struct{}{}
+	atomic.AddInt64(&h.n, 1)
+	return s
+}
+
+func (h *watchHub) remove(s *Subscription) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var set map[*Subscription]struct{}
+	if s.prefix {
+		if v, ok := h.prefixes.get(s.key); ok {
+			set = v.(map[*Subscription]struct{})
+		}
+	} else {
+		set = h.keys[s.key]
+	}
+
+	if _, ok := set[s]; !ok {
+		return
+	}
+	delete(set, s)
+	atomic.AddInt64(&h.n, -1)
+
+	if len(set) == 0 {
+		if s.prefix {
+			h.prefixes.del(s.key)
+		} else {
+			delete(h.keys, s.key)
+		}
+	}
+}
+
+// closeAll closes every subscription.
+func (h *watchHub) closeAll() {
+
+	var subs []*Subscription
+	h.mu.RLock()
+	for _, set := range h.keys {
+		for s := range set {
+			subs = append(subs, s)
+		}
+	}
+	h.prefixes.all(func(v interface{}) {
+		for s := range v.(map[*Subscription]struct{}) {
+			subs = append(subs, s)
+		}
+	})
+	h.mu.RUnlock()
+
+	for _, s := range subs {
+		s.Close()
+	}
+}
+
+// publish never blocks. It is called while holding the engine lock.
+func (h *watchHub) publish(key string, reason EventReason, now time.Time) {
+
+	if atomic.LoadInt64(&h.n) == 0 {
+		return
+	}
+
+	ev := Event{key, reason, now}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for s := range h.keys[key] {
+		s.send(ev)
+	}
+	h.prefixes.each(key, func(v interface{}) {
+		for s := range v.(map[*Subscription]struct{}) {
+			s.send(ev)
+		}
+	})
+}
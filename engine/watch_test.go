@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/clock"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+func TestWatch(t *testing.T) {
+
+	clk := clock.NewManual(time.Now())
+	opts := OptionsDefault
+	opts.O = &fake.NoDelayOrigin{}
+	opts.TtlTickStep = 1 * time.Millisecond
+	opts.Clock = clk
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	users := e.WatchPrefix("user:")
+	a := e.Watch("a")
+	all := e.WatchPrefix("")
+
+	next := func(s *Subscription) Event {
+		select {
+		case ev := <-s.C:
+			return ev
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+			return Event{}
+		}
+	}
+
+	e.Get("user:1")
+	e.Get("a")
+	e.Get("a") // hit, no event
+	e.Set("user:2", []byte("v"))
+	e.Invalidate("user:1", "nobody")
+	assert.True(t, e.Expire("user:2", time.Second))
+
+	assert.Equal(t, Event{"user:1", EventFill, clk.Now()}, next(users))
+	assert.Equal(t, Event{"user:2", EventSet, clk.Now()}, next(users))
+	assert.Equal(t, Event{"user:1", EventInvalidate, clk.Now()}, next(users))
+	assert.Equal(t, Event{"a", EventFill, clk.Now()}, next(a))
+
+	clk.Advance(time.Second + time.Millisecond)
+	ev := next(users)
+	assert.Equal(t, "user:2", ev.Key)
+	assert.Equal(t, EventExpire, ev.Reason)
+	assert.Equal(t, "expire", ev.Reason.String())
+
+	e.rwm.Lock()
+	e.evictUntilFree(int(e.maxPayloadTotalSize))
+	e.rwm.Unlock()
+	assert.Equal(t, Event{"a", EventEvict, clk.Now()}, next(a))
+
+	var reasons []EventReason
+	for i := 0; i < 6; i++ {
+		reasons = append(reasons, next(all).Reason)
+	}
+	assert.Equal(t, []EventReason{
+		EventFill, EventFill, EventSet, EventInvalidate, EventExpire, EventEvict,
+	}, reasons)
+
+	// closed subscriptions get nothing and don't count
+	users.Close()
+	users.Close()
+	a.Close()
+	all.Close()
+	_, ok := <-users.C
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), e.watch.n)
+	e.Set("user:3", nil)
+	assert.Equal(t, 0, len(e.watch.keys))
+	assert.Equal(t, 0, e.watch.prefixes.len)
+}
+
+func TestWatchDropPolicy(t *testing.T) {
+
+	opts := OptionsDefault
+	opts.WatchBufferSize = -1
+	e, err := NewEngine(&opts)
+	assert.Nil(t, e)
+	assert.NotNil(t, err)
+
+	for policy, want := range map[DropPolicy]string{
+		DropNewest: "01",
+		DropOldest: "34",
+	} {
+		opts = OptionsDefault
+		opts.O = &fake.NoDelayOrigin{}
+		opts.WatchBufferSize = 2
+		opts.WatchDropPolicy = policy
+		e, err = NewEngine(&opts)
+		assert.Nil(t, err)
+
+		s := e.WatchPrefix("")
+		for i := 0; i < 5; i++ {
+			e.Set(strconv.Itoa(i), nil) // never blocks
+		}
+		assert.Equal(t, uint64(3), s.Dropped())
+
+		s.Close()
+		got := ""
+		for ev := range s.C {
+			got += ev.Key
+		}
+		assert.Equal(t, want, got)
+	}
+}
+
+func TestSetSupersedesFill(t *testing.T) {
+
+	opts := OptionsDefault
+	opts.O = &fake.DelayedOrigin{} // 100ms
+	e, err := NewEngine(&opts)
+	assert.Nil(t, err)
+
+	done := make(chan []byte)
+	go func() {
+		b, _ := e.GetCopy("k")
+		done <- b
+	}()
+
+	eventually(t, func() bool {
+		e.rwm.RLock()
+		defer e.rwm.RUnlock()
+		return e.fillCond["k"] != nil
+	})
+	e.SetWithTTL("k", []byte("set"), time.Hour)
+	assert.Equal(t, []byte("set"), <-done)
+
+	// the fetch completing later must not overwrite the row
+	time.Sleep(200 * time.Millisecond)
+	b, err := e.GetCopy("k")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("set"), b)
+	assert.True(t, roughly(3600, e.GetTTL("k")[0]))
+
+	e.Set("k", []byte("again"))
+	assert.Equal(t, -1.0, e.GetTTL("k")[0])
+}
@@ -0,0 +1,283 @@
+package engine
+
+import (
+	"time"
+)
+
+const (
+	wheelBits   = 8
+	wheelSize   = 1 << wheelBits
+	wheelMask   = wheelSize - 1
+	wheelLevels = 4
+)
+
+// timingWheel is a hierarchical timing wheel implementing TTLIndex.
+// Time is cut into ticks of resolution since origin. Level L has wheelSize
+// slots each spanning wheelSize^L ticks. A key lands on the lowest level
+// whose span covers the distance between its expiry and the current tick,
+// and is cascaded down a level each time the wheel below completes a round.
+// Expiries further than wheelSize^wheelLevels ticks go to an overflow list.
+type timingWheel struct {
+	resolution time.Duration
+	origin     time.Time
+
+	// slots of all ticks before current have been popped
+	current int64
+
+	slots     [wheelLevels][wheelSize]*wheelEntry
+	counts    [wheelLevels]int
+	overflow  *wheelEntry
+	overflowN int
+
+	m map[string]*wheelEntry
+}
+
+type wheelEntry struct {
+	key    string
+	expiry time.Time
+	ticks  int64
+	level  int // -1 for overflow
+	slot   int
+	prev   *wheelEntry
+	next   *wheelEntry
+}
+
+func newTimingWheel(resolution time.Duration, now time.Time) *timingWheel {
+	return &timingWheel{
+		resolution: resolution,
+		origin:     now,
+		m:          map[string]*wheelEntry{},
+	}
+}
+
+func (tw *timingWheel) tickOf(t time.Time) int64 {
+	d := t.Sub(tw.origin)
+	if d < 0 {
+		return -1
+	}
+	return int64(d / tw.resolution)
+}
+
+func (tw *timingWheel) Set(key string, expiry time.Time) {
+
+	we, ok := tw.m[key]
+	if ok {
+		tw.unlink(we)
+	} else {
+		we = &wheelEntry{key: key}
+		tw.m[key] = we
+	}
+	we.expiry = expiry
+	we.ticks = tw.tickOf(expiry)
+	tw.place(we)
+}
+
+func (tw *timingWheel) Get(key string) (time.Time, bool) {
+	if we, ok := tw.m[key]; ok {
+		return we.expiry, true
+	}
+	return time.Time{}, false
+}
+
+func (tw *timingWheel) Del(key string) {
+	if we, ok := tw.m[key]; ok {
+		tw.unlink(we)
+		delete(tw.m, key)
+	}
+}
+
+func (tw *timingWheel) Len() int {
+	return len(tw.m)
+}
+
+func (tw *timingWheel) Expired(now time.Time) bool {
+
+	if len(tw.m) == 0 {
+		return false
+	}
+
+	target := tw.tickOf(now)
+
+	// fully elapsed slots of the current round
+	for t := tw.current; t < target && t < tw.current+wheelSize; t++ {
+		if tw.slots[0][t&wheelMask] != nil {
+			return true
+		}
+	}
+
+	// a round completes, higher levels need cascading
+	if target > tw.current|wheelMask && len(tw.m) > tw.counts[0] {
+		return true
+	}
+
+	if target-tw.current < wheelSize {
+		for we := tw.slots[0][target&wheelMask]; we != nil; we = we.next {
+			if now.After(we.expiry) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (tw *timingWheel) PopExpired(now time.Time, max int) (keys []string) {
+
+	target := tw.tickOf(now)
+
+	for tw.current < target {
+
+		if len(tw.m) == 0 {
+			tw.current = target
+			break
+		}
+
+		// skip ahead over empty lower levels
+		if empty := tw.emptyLevels(); empty > 0 {
+
+			next := (tw.current | (int64(1)<<uint(wheelBits*empty) - 1)) + 1
+			if next > target {
+				tw.current = target
+				break
+			}
+			tw.current = next
+			tw.cascade()
+			continue
+		}
+
+		if !tw.drain(&tw.slots[0][tw.current&wheelMask], nil, max, &keys) {
+			return // max reached, resume from the same slot next time
+		}
+
+		tw.current++
+		if tw.current&wheelMask == 0 {
+			tw.cascade()
+		}
+	}
+
+	// partially elapsed slot
+	tw.drain(&tw.slots[0][tw.current&wheelMask], &now, max, &keys)
+	return
+}
+
+// emptyLevels returns the number of consecutive empty levels starting from
+// level 0.
+func (tw *timingWheel) emptyLevels() int {
+	n := 0
+	for n < wheelLevels && tw.counts[n] == 0 {
+		n++
+	}
+	return n
+}
+
+// drain pops entries of a slot expiring before now, or all of them if now is
+// nil. It returns false if it stopped because max was reached.
+func (tw *timingWheel) drain(head **wheelEntry, now *time.Time, max int, keys *[]string) bool {
+
+	for we := *head; we != nil; {
+		next := we.next
+		if now == nil || now.After(we.expiry) {
+			if max > 0 && len(*keys) >= max {
+				return false
+			}
+			tw.unlink(we)
+			delete(tw.m, we.key)
+			*keys = append(*keys, we.key)
+		}
+		we = next
+	}
+	return true
+}
+
+// cascade moves entries of higher levels down once the current tick crosses
+// their slot boundary, highest level first.
+func (tw *timingWheel) cascade() {
+
+	if tw.current&(int64(1)<<uint(wheelBits*wheelLevels)-1) == 0 {
+		ov := tw.overflow
+		tw.overflow, tw.overflowN = nil, 0
+		tw.replace(ov)
+	}
+
+	for l := wheelLevels - 1; l >= 1; l-- {
+
+		shift := uint(wheelBits * l)
+		if tw.current&(int64(1)<<shift-1) != 0 {
+			continue
+		}
+
+		idx := (tw.current >> shift) & wheelMask
+		list := tw.slots[l][idx]
+		tw.slots[l][idx] = nil
+		for we := list; we != nil; we = we.next {
+			tw.counts[l]--
+		}
+		tw.replace(list)
+	}
+}
+
+// replace places every entry of a detached list again.
+func (tw *timingWheel) replace(list *wheelEntry) {
+	for we := list; we != nil; {
+		next := we.next
+		we.prev, we.next = nil, nil
+		tw.place(we)
+		we = next
+	}
+}
+
+func (tw *timingWheel) place(we *wheelEntry) {
+
+	t := we.ticks
+	if t < tw.current {
+		t = tw.current // overdue, pop with the current slot
+	}
+	delta := t - tw.current
+
+	we.level = -1
+	for l := 0; l < wheelLevels; l++ {
+		if delta < int64(1)<<uint(wheelBits*(l+1)) {
+			we.level = l
+			we.slot = int((t >> uint(wheelBits*l)) & wheelMask)
+			break
+		}
+	}
+
+	head := tw.head(we)
+	we.prev, we.next = nil, *head
+	if *head != nil {
+		(*head).prev = we
+	}
+	*head = we
+
+	if we.level < 0 {
+		tw.overflowN++
+	} else {
+		tw.counts[we.level]++
+	}
+}
+
+func (tw *timingWheel) unlink(we *wheelEntry) {
+
+	if we.prev != nil {
+		we.prev.next = we.next
+	} else {
+		*tw.head(we) = we.next
+	}
+	if we.next != nil {
+		we.next.prev = we.prev
+	}
+	we.prev, we.next = nil, nil
+
+	if we.level < 0 {
+		tw.overflowN--
+	} else {
+		tw.counts[we.level]--
+	}
+}
+
+func (tw *timingWheel) head(we *wheelEntry) **wheelEntry {
+	if we.level < 0 {
+		return &tw.overflow
+	}
+	return &tw.slots[we.level][we.slot]
+}
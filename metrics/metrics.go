@@ -0,0 +1,157 @@
+// Package metrics exposes engine statistics in the Prometheus text exposition
+// format (version 0.0.4), without depending on the Prometheus client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wv0m56/prefixed/engine"
+)
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Source is anything providing engine statistics, normally an *engine.Engine.
+type Source interface {
+	Stats() engine.Stats
+}
+
+// NewHandler returns an http.Handler serving the statistics of src upon every
+// request, meant to be mounted on /metrics.
+func NewHandler(src Source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := src.Stats()
+		w.Header().Set("Content-Type", ContentType)
+		_ = Write(w, &s)
+	})
+}
+
+// Write writes s to w in the text exposition format. Metric names start with
+// "prefixed_". Whatever the origin reports is written as
+// prefixed_origin_<key>, as a counter if key ends with "_total" and as a
+// gauge otherwise.
+func Write(w io.Writer, s *engine.Stats) error {
+
+	bw := bufio.NewWriter(w)
+	m := &writer{bw}
+
+	classes := make([]string, 0, len(s.Classes))
+	for c := range s.Classes {
+		classes = append(classes, c)
+	}
+	sort.Strings(classes)
+
+	m.family("prefixed_cache_hits_total", "counter", "Reads served from the cache, by key prefix class.")
+	for _, c := range classes {
+		m.sample("prefixed_cache_hits_total", labels("class", c), float64(s.Classes[c].Hits))
+	}
+	m.family("prefixed_cache_misses_total", "counter", "Reads needing a cache fill, by key prefix class.")
+	for _, c := range classes {
+		m.sample("prefixed_cache_misses_total", labels("class", c), float64(s.Classes[c].Misses))
+	}
+
+	m.single("prefixed_fills_total", "counter", "Successful cache fills from origin.", float64(s.Fills))
+	m.single("prefixed_fill_errors_total", "counter", "Failed cache fills from origin.", float64(s.FillErrors))
+	m.single("prefixed_sets_total", "counter", "Rows written without going through origin.", float64(s.Sets))
+
+	m.family("prefixed_removals_total", "counter", "Rows removed from the cache, by reason.")
+	m.sample("prefixed_removals_total", labels("reason", "evicted"), float64(s.Evictions))
+	m.sample("prefixed_removals_total", labels("reason", "expired"), float64(s.Expirations))
+	m.sample("prefixed_removals_total", labels("reason", "invalidated"), float64(s.Invalidations))
+
+	h := &s.FillLatency
+	m.family("prefixed_fill_duration_seconds", "histogram", "Time taken to fetch a payload from origin.")
+	var cum uint64
+	for i, c := range h.Counts {
+		cum += c
+		le := math.Inf(1)
+		if i < len(h.Bounds) {
+			le = h.Bounds[i].Seconds()
+		}
+		m.sample("prefixed_fill_duration_seconds_bucket", labels("le", formatFloat(le)), float64(cum))
+	}
+	m.sample("prefixed_fill_duration_seconds_sum", "", h.Sum.Seconds())
+	m.sample("prefixed_fill_duration_seconds_count", "", float64(h.Count))
+
+	m.single("prefixed_in_flight_fills", "gauge", "Cache fills in progress.", float64(s.InFlightFills))
+	m.single("prefixed_graveyard_rows", "gauge", "Rows out of the relevance window, evicted first.", float64(s.GraveyardSize))
+	m.single("prefixed_ttl_rows", "gauge", "Rows having a TTL.", float64(s.TTLCount))
+	m.single("prefixed_rows", "gauge", "Rows in the cache.", float64(s.Len))
+	m.single("prefixed_payload_bytes", "gauge", "Total size of the payloads in the cache.", float64(s.PayloadSize))
+	m.single("prefixed_payload_max_bytes", "gauge", "MaxPayloadTotalSize of the engine.", float64(s.MaxPayloadTotalSize))
+
+	keys := make([]string, 0, len(s.Origin))
+	for k := range s.Origin {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		typ := "gauge"
+		if strings.HasSuffix(k, "_total") {
+			typ = "counter"
+		}
+		m.single("prefixed_origin_"+sanitize(k), typ, "Reported by the origin.", s.Origin[k])
+	}
+
+	return bw.Flush()
+}
+
+// writer ignores errors, bufio.Writer keeps the first one for Flush.
+type writer struct {
+	w *bufio.Writer
+}
+
+func (m *writer) family(name, typ, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+}
+
+func (m *writer) sample(name, labels string, v float64) {
+	fmt.Fprintf(m.w, "%s%s %s\n", name, labels, formatFloat(v))
+}
+
+func (m *writer) single(name, typ, help string, v float64) {
+	m.family(name, typ, help)
+	m.sample(name, "", v)
+}
+
+func labels(name, value string) string {
+	return "{" + name + `="` + escapeLabel(value) + `"}`
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+// sanitize turns s into a valid metric name suffix.
+func sanitize(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/engine"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+	"github.com/wv0m56/prefixed/plugin/origin/resilience"
+)
+
+var sampleLine = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{(.*)\})? (\S+)$`)
+
+// parse checks r is well formed exposition text and returns its samples keyed
+// by name plus labels as written, and the type of each family.
+func parse(t *testing.T, r io.Reader) (map[string]float64, map[string]string) {
+
+	samples := map[string]float64{}
+	types := map[string]string{}
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+
+		line := sc.Text()
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		if strings.HasPrefix(line, "# TYPE ") {
+			f := strings.Fields(line)
+			if assert.Equal(t, 4, len(f), line) {
+				_, dup := types[f[2]]
+				assert.False(t, dup, line)
+				types[f[2]] = f[3]
+			}
+			continue
+		}
+
+		m := sampleLine.FindStringSubmatch(line)
+		if !assert.NotNil(t, m, line) {
+			continue
+		}
+
+		family := m[1]
+		if _, ok := types[family]; !ok {
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				family = strings.TrimSuffix(family, suffix)
+			}
+			assert.Equal(t, "histogram", types[family], line)
+		}
+
+		if m[3] != "" {
+			parseLabels(t, m[3])
+		}
+
+		v, err := strconv.ParseFloat(m[4], 64)
+		assert.Nil(t, err, line)
+		_, dup := samples[m[1]+m[2]]
+		assert.False(t, dup, line)
+		samples[m[1]+m[2]] = v
+	}
+	return samples, types
+}
+
+// parseLabels returns label values, unescaped.
+func parseLabels(t *testing.T, s string) map[string]string {
+
+	ls := map[string]string{}
+	for s != "" {
+		eq := strings.Index(s, `="`)
+		if !assert.True(t, eq > 0, s) {
+			return nil
+		}
+		name := s[:eq]
+		s = s[eq+2:]
+
+		var val []byte
+		for {
+			if !assert.NotEqual(t, 0, len(s), "unterminated label value") {
+				return nil
+			}
+			c := s[0]
+			s = s[1:]
+			if c == '"' {
+				break
+			}
+			if c == '\\' {
+				switch s[0] {
+				case 'n':
+					c = '\n'
+				default:
+					c = s[0]
+				}
+				s = s[1:]
+			}
+			val = append(val, c)
+		}
+		ls[name] = string(val)
+		s = strings.TrimPrefix(s, ",")
+	}
+	return ls
+}
+
+func TestHandler(t *testing.T) {
+
+	opts := engine.OptionsDefault
+	opts.O = resilience.NewRetry(
+		resilience.NewCircuitBreaker(&fake.NoDelayOrigin{}, 5, time.Second),
+		3, time.Millisecond, 10*time.Millisecond,
+	)
+	opts.StatsPrefixClasses = []string{"user:", `odd"class\`}
+	e, err := engine.NewEngine(&opts)
+	assert.Nil(t, err)
+
+	e.Get("user:1")
+	e.Get("user:1")
+	e.Get("user:2")
+	e.Get("other")
+	e.Invalidate("other")
+
+	rec := httptest.NewRecorder()
+	NewHandler(e).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, ContentType, rec.Header().Get("Content-Type"))
+
+	samples, types := parse(t, rec.Body)
+
+	assert.Equal(t, 1.0, samples[`prefixed_cache_hits_total{class="user:"}`])
+	assert.Equal(t, 2.0, samples[`prefixed_cache_misses_total{class="user:"}`])
+	assert.Equal(t, 1.0, samples[`prefixed_cache_misses_total{class=""}`])
+	assert.Equal(t, 0.0, samples[`prefixed_cache_hits_total{class="odd\"class\\"}`])
+	assert.Equal(t, 3.0, samples["prefixed_fills_total"])
+	assert.Equal(t, 1.0, samples[`prefixed_removals_total{reason="invalidated"}`])
+	assert.Equal(t, 2.0, samples["prefixed_rows"])
+	assert.Equal(t, float64(opts.MaxPayloadTotalSize), samples["prefixed_payload_max_bytes"])
+
+	assert.Equal(t, "histogram", types["prefixed_fill_duration_seconds"])
+	assert.Equal(t, 3.0, samples[`prefixed_fill_duration_seconds_bucket{le="+Inf"}`])
+	assert.Equal(t, 3.0, samples["prefixed_fill_duration_seconds_count"])
+
+	assert.Equal(t, "counter", types["prefixed_origin_retry_fetches_total"])
+	assert.Equal(t, 3.0, samples["prefixed_origin_retry_fetches_total"])
+	assert.Equal(t, "gauge", types["prefixed_origin_breaker_state"])
+	assert.Equal(t, 0.0, samples["prefixed_origin_breaker_state"])
+}
+
+func TestParseLabels(t *testing.T) {
+	assert.Equal(t,
+		map[string]string{"a": `x"y\`, "b": "\n"},
+		parseLabels(t, `a="x\"y\\",b="\n"`),
+	)
+}
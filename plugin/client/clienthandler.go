@@ -1,9 +1,63 @@
+// Package client defines the interface of network frontends serving a cache
+// engine, and a registry to run several of them against one engine.
 package client
 
-import "io"
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
 
-// ClientPlugin interfaces network API into engine's Go API. It handles
-// requests from network clients and sends them replies.
+	"github.com/wv0m56/prefixed/engine"
+)
+
+// ClientPlugin is a network frontend translating a wire protocol into calls to
+// the engine's Go API, e.g. RESP, memcached or HTTP.
 type ClientPlugin interface {
-	ReplyTo(key, w io.Writer)
+
+	// Serve listens and serves eng until ctx is done or Shutdown is called,
+	// in which cases it returns nil, even if Shutdown was called first, or
+	// until serving fails.
+	Serve(ctx context.Context, eng EngineAPI) error
+
+	// Shutdown stops accepting connections and lets in-flight requests
+	// complete, closing whatever remains once ctx is done. It returns
+	// ctx.Err() in the latter case.
+	Shutdown(ctx context.Context) error
+}
+
+// EngineAPI is the part of *engine.Engine frontends use.
+type EngineAPI interface {
+	Get(key string) (*bytes.Reader, error)
+	GetCopy(key string) ([]byte, error)
+	GetWithMeta(key string) ([]byte, engine.Meta, uint64, error)
+	GetIfNoneMatch(key string, version uint64) ([]byte, uint64, bool, error)
+	Has(key string) bool
+	KeysByPrefix(p string, offset, limit int) []string
+	EntriesByPrefix(p string, offset, limit int) []engine.Entry
+
+	Set(key string, val []byte)
+	SetWithTTL(key string, val []byte, ttl time.Duration)
+	SetWithMeta(key string, val []byte, ttl time.Duration, m engine.Meta) uint64
+	Add(key string, val []byte, ttl time.Duration, m engine.Meta) (uint64, bool)
+	Replace(key string, val []byte, ttl time.Duration, m engine.Meta) (uint64, bool)
+	CompareAndSwap(key string, version uint64, val []byte) (uint64, bool)
+	CompareAndSwapWithMeta(key string, version uint64, val []byte, ttl time.Duration, m engine.Meta) (uint64, bool)
+	Invalidate(keys ...string) int
+	DeleteIfVersion(key string, version uint64) (uint64, bool)
+	IncrBy(key string, n int64, ttl time.Duration) (int64, error)
+	IncrByFloat(key string, f float64, ttl time.Duration) (float64, error)
+	InvalidatePrefix(p string) int
+
+	GetTTL(keys ...string) []float64
+	TTL(key string) (time.Duration, bool)
+	Expire(key string, d time.Duration) bool
+	Persist(key string) bool
+
+	Stats() engine.Stats
+
+	Snapshot(w io.Writer) (int, error)
+	Restore(r io.Reader) (int, error)
 }
+
+var _ EngineAPI = (*engine.Engine)(nil)
@@ -0,0 +1,345 @@
+// Package httpapi serves an engine over HTTP. Values are raw request and
+// response bodies, prefix listings are JSON lines and errors are JSON
+// objects:
+//
+//	GET    /v1/keys/{key}                  value, filled from origin on miss
+//	PUT    /v1/keys/{key}?ttl={seconds}    write, keeping its Content-Type
+//	DELETE /v1/keys/{key}                  invalidate
+//	GET    /v1/prefix/{p}?limit=&cursor=   entries as JSON lines
+//	DELETE /v1/prefix/{p}                  invalidate the whole prefix
+//	GET    /v1/snapshot                    snapshot of all rows, see engine.Snapshot
+//	PUT    /v1/snapshot                    restore a snapshot, see engine.Restore
+//
+// Values carry their engine row version as ETag. GET honours If-None-Match,
+// PUT and DELETE honour If-Match, for a single ETag.
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wv0m56/prefixed/engine"
+	"github.com/wv0m56/prefixed/plugin/client"
+)
+
+const (
+	keysPath     = "/v1/keys/"
+	prefixPath   = "/v1/prefix/"
+	snapshotPath = "/v1/snapshot"
+
+	maxBodyLen   = 64 * 1024 * 1024 // also capped by the engine's payload limit
+	defaultLimit = 100
+	maxLimit     = 1000
+
+	// NextCursorHeader holds the cursor of the next page of a prefix
+	// listing, it is absent on the last page.
+	NextCursorHeader = "X-Next-Cursor"
+
+	// JSONLinesType is the content type of prefix listings.
+	JSONLinesType = "application/x-ndjson"
+)
+
+// Error is the body of error responses.
+type Error struct {
+	// Code is a stable, machine readable error code, e.g. "not_found".
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ListEntry is a line of a prefix listing. TTL is in seconds and omitted for
+// rows without TTL.
+type ListEntry struct {
+	Key   string   `json:"key"`
+	Value []byte   `json:"value"`
+	TTL   *float64 `json:"ttl,omitempty"`
+}
+
+type handler struct {
+	e       client.EngineAPI
+	maxBody int64
+}
+
+// NewHandler returns an http.Handler serving e under /v1/. Request bodies
+// longer than the engine's MaxPayloadTotalSize, or than 64MB, are rejected.
+func NewHandler(e client.EngineAPI) http.Handler {
+
+	maxBody := int64(maxBodyLen)
+	if m := e.Stats().MaxPayloadTotalSize; m < maxBody {
+		maxBody = m
+	}
+	return &handler{e, maxBody}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	switch p := r.URL.Path; {
+
+	case strings.HasPrefix(p, keysPath) && len(p) > len(keysPath):
+		key := p[len(keysPath):]
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			h.get(w, r, key)
+		case http.MethodPut:
+			h.put(w, r, key)
+		case http.MethodDelete:
+			h.del(w, r, key)
+		default:
+			methodNotAllowed(w, "GET, HEAD, PUT, DELETE")
+		}
+
+	case strings.HasPrefix(p, prefixPath):
+		prefix := p[len(prefixPath):]
+		switch r.Method {
+		case http.MethodGet:
+			h.list(w, r, prefix)
+		case http.MethodDelete:
+			h.delPrefix(w, prefix)
+		default:
+			methodNotAllowed(w, "GET, DELETE")
+		}
+
+	case p == snapshotPath:
+		switch r.Method {
+		case http.MethodGet:
+			h.snapshot(w)
+		case http.MethodPut:
+			h.restore(w, r)
+		default:
+			methodNotAllowed(w, "GET, PUT")
+		}
+
+	default:
+		writeError(w, http.StatusNotFound, "not_found", "no route for "+p)
+	}
+}
+
+// get writes the value of key, with a max-age of its TTL if it has one. The
+// headers stored along the row, e.g. by origin, are written as well and its
+// content type defaults to application/octet-stream.
+func (h *handler) get(w http.ResponseWriter, r *http.Request, key string) {
+
+	v, m, version, err := h.e.GetWithMeta(key)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "origin_error", err.Error())
+		return
+	}
+
+	if inm, ok := parseETag(r.Header.Get("If-None-Match")); ok && inm == version {
+		w.Header().Set("ETag", formatETag(version))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	for k, hv := range m.Headers {
+		w.Header().Set(k, hv)
+	}
+	if m.ContentType != "" {
+		w.Header().Set("Content-Type", m.ContentType)
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	if !m.Created.IsZero() {
+		w.Header().Set("Last-Modified", m.Created.UTC().Format(http.TimeFormat))
+	}
+	if version != 0 {
+		w.Header().Set("ETag", formatETag(version))
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(v)))
+	if ttl := h.e.GetTTL(key)[0]; ttl >= 0 {
+		w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(math.Floor(ttl))))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(v)
+	}
+}
+
+// put writes the request body to key, expiring it after the ttl query
+// parameter in seconds if given. Its Content-Type is stored as metadata.
+func (h *handler) put(w http.ResponseWriter, r *http.Request, key string) {
+
+	var ttl time.Duration
+	if s := r.URL.Query().Get("ttl"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "bad_request", "ttl must be a positive number of seconds")
+			return
+		}
+		ttl = time.Duration(n) * time.Second
+	}
+
+	if r.ContentLength > h.maxBody {
+		writeError(w, http.StatusRequestEntityTooLarge, "too_large", "value too large")
+		return
+	}
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBody))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "too_large", err.Error())
+		return
+	}
+
+	m := engine.Meta{ContentType: r.Header.Get("Content-Type")}
+	var version uint64
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		cur, ok := parseETag(ifMatch)
+		if !ok {
+			writeError(w, http.StatusPreconditionFailed, "precondition_failed", "version mismatch")
+			return
+		}
+		if version, ok = h.e.CompareAndSwapWithMeta(key, cur, b, ttl, m); !ok {
+			writeError(w, http.StatusPreconditionFailed, "precondition_failed", "version mismatch")
+			return
+		}
+	} else {
+		version = h.e.SetWithMeta(key, b, ttl, m)
+	}
+
+	w.Header().Set("ETag", formatETag(version))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) del(w http.ResponseWriter, r *http.Request, key string) {
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		if h.e.Invalidate(key) == 0 {
+			writeError(w, http.StatusNotFound, "not_found", "no such key")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	version, _ := parseETag(ifMatch)
+	if cur, ok := h.e.DeleteIfVersion(key, version); ok {
+		w.WriteHeader(http.StatusNoContent)
+	} else if cur == 0 {
+		writeError(w, http.StatusNotFound, "not_found", "no such key")
+	} else {
+		writeError(w, http.StatusPreconditionFailed, "precondition_failed", "version mismatch")
+	}
+}
+
+// formatETag returns the strong ETag of a row version.
+func formatETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// parseETag parses an ETag returned by formatETag, weak or not. ok is false
+// for anything else, lists of ETags included.
+func parseETag(s string) (version uint64, ok bool) {
+
+	s = strings.TrimPrefix(strings.TrimSpace(s), "W/")
+	if len(s) < 3 || s[0] != '"' || s[len(s)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.ParseUint(s[1:len(s)-1], 10, 64)
+	return version, err == nil && version != 0
+}
+
+// list writes the entries under prefix as JSON lines. The cursor is the
+// number of entries already listed, so like RESP SCAN a listing misses keys
+// inserted before the cursor and repeats keys if keys before it are deleted
+// meanwhile. Cache fills are never triggered.
+func (h *handler) list(w http.ResponseWriter, r *http.Request, prefix string) {
+
+	q := r.URL.Query()
+	limit, cursor := defaultLimit, 0
+	var err error
+
+	if s := q.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxLimit {
+			writeError(w, http.StatusBadRequest, "bad_request",
+				"limit must be between 1 and "+strconv.Itoa(maxLimit))
+			return
+		}
+	}
+	if s := q.Get("cursor"); s != "" {
+		if cursor, err = strconv.Atoi(s); err != nil || cursor < 0 {
+			writeError(w, http.StatusBadRequest, "bad_request", "invalid cursor")
+			return
+		}
+	}
+
+	es := h.e.EntriesByPrefix(prefix, cursor, limit)
+	keys := make([]string, len(es))
+	for i, en := range es {
+		keys[i] = en.Key
+	}
+	ttls := h.e.GetTTL(keys...)
+
+	w.Header().Set("Content-Type", JSONLinesType)
+	if len(es) == limit {
+		w.Header().Set(NextCursorHeader, strconv.Itoa(cursor+limit))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	for i, en := range es {
+		le := ListEntry{Key: en.Key, Value: en.Val}
+		if ttls[i] >= 0 {
+			le.TTL = &ttls[i]
+		}
+		if err := enc.Encode(&le); err != nil {
+			return
+		}
+	}
+}
+
+// delPrefix refuses the empty prefix, which would wipe the whole cache.
+func (h *handler) delPrefix(w http.ResponseWriter, prefix string) {
+
+	if prefix == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "refusing to delete the empty prefix")
+		return
+	}
+
+	n := h.e.InvalidatePrefix(prefix)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Deleted int `json:"deleted"`
+	}{n})
+}
+
+// snapshot streams all rows, metadata included. An error past the first
+// bytes can only be told by a truncated snapshot.
+func (h *handler) snapshot(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", JSONLinesType)
+	_, _ = h.e.Snapshot(w)
+}
+
+// restore writes the rows of the snapshot in the request body. Rows restored
+// before an error remain in the cache.
+func (h *handler) restore(w http.ResponseWriter, r *http.Request) {
+
+	n, err := h.e.Restore(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("restored %d rows: %v", n, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Restored int `json:"restored"`
+	}{n})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "allowed methods: "+allow)
+}
+
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error Error `json:"error"`
+	}{Error{code, msg}})
+}
@@ -0,0 +1,238 @@
+package httpapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/clock"
+	"github.com/wv0m56/prefixed/engine"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+func newTestHandler(t *testing.T) (http.Handler, *engine.Engine) {
+
+	opts := engine.OptionsDefault
+	opts.O = &fake.NoDelayOrigin{}
+	opts.Clock = clock.NewManual(time.Now())
+	e, err := engine.NewEngine(&opts)
+	assert.Nil(t, err)
+	return NewHandler(e), e
+}
+
+// do serves a request with header given as name, value pairs.
+func do(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	var body struct{ Error Error }
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.NotEmpty(t, body.Error.Message)
+	return body.Error.Code
+}
+
+func TestKeys(t *testing.T) {
+
+	h, e := newTestHandler(t)
+
+	// filled from origin
+	w := do(h, "GET", "/v1/keys/user:1", "")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "user:1", w.Body.String())
+	assert.Equal(t, "6", w.Header().Get("Content-Length"))
+	assert.Equal(t, "", w.Header().Get("Cache-Control"))
+
+	w = do(h, "GET", "/v1/keys/bench%20error", "")
+	assert.Equal(t, 502, w.Code)
+	assert.Equal(t, "origin_error", errorCode(t, w))
+
+	w = do(h, "PUT", "/v1/keys/a/b?ttl=100", "hello")
+	assert.Equal(t, 204, w.Code)
+	w = do(h, "GET", "/v1/keys/a/b", "")
+	assert.Equal(t, "hello", w.Body.String())
+	assert.Equal(t, "max-age=100", w.Header().Get("Cache-Control"))
+	w = do(h, "HEAD", "/v1/keys/a/b", "")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "", w.Body.String())
+	assert.Equal(t, "application/octet-stream", w.Header().Get("Content-Type"))
+	assert.NotEqual(t, "", w.Header().Get("Last-Modified"))
+
+	// the content type is kept
+	w = do(h, "PUT", "/v1/keys/json", `{}`, "Content-Type", "application/json")
+	assert.Equal(t, 204, w.Code)
+	w = do(h, "GET", "/v1/keys/json", "")
+	assert.Equal(t, "{}", w.Body.String())
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	w = do(h, "PUT", "/v1/keys/a?ttl=-1", "x")
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, "bad_request", errorCode(t, w))
+	assert.False(t, e.Has("a"))
+
+	w = do(h, "DELETE", "/v1/keys/a/b", "")
+	assert.Equal(t, 204, w.Code)
+	w = do(h, "DELETE", "/v1/keys/a/b", "")
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, "not_found", errorCode(t, w))
+
+	w = do(h, "POST", "/v1/keys/a", "")
+	assert.Equal(t, 405, w.Code)
+	assert.Equal(t, "method_not_allowed", errorCode(t, w))
+	assert.Equal(t, "GET, HEAD, PUT, DELETE", w.Header().Get("Allow"))
+
+	w = do(h, "GET", "/v1/keys/", "")
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, "not_found", errorCode(t, w))
+
+	// too large
+	h.(*handler).maxBody = 4
+	w = do(h, "PUT", "/v1/keys/big", "hello")
+	assert.Equal(t, 413, w.Code)
+	assert.Equal(t, "too_large", errorCode(t, w))
+	assert.False(t, e.Has("big"))
+}
+
+func TestETag(t *testing.T) {
+
+	h, e := newTestHandler(t)
+
+	w := do(h, "GET", "/v1/keys/a", "")
+	etag := w.Header().Get("ETag")
+	v, _ := e.Version("a")
+	assert.Equal(t, formatETag(v), etag)
+
+	w = do(h, "GET", "/v1/keys/a", "", "If-None-Match", etag)
+	assert.Equal(t, 304, w.Code)
+	assert.Equal(t, "", w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	w = do(h, "GET", "/v1/keys/a", "", "If-None-Match", "W/"+etag)
+	assert.Equal(t, 304, w.Code)
+	w = do(h, "GET", "/v1/keys/a", "", "If-None-Match", `"1", "2"`)
+	assert.Equal(t, 200, w.Code)
+
+	// conditional writes
+	w = do(h, "PUT", "/v1/keys/a", "x", "If-Match", `"12345"`)
+	assert.Equal(t, 412, w.Code)
+	assert.Equal(t, "precondition_failed", errorCode(t, w))
+	w = do(h, "PUT", "/v1/keys/nobody", "x", "If-Match", etag)
+	assert.Equal(t, 412, w.Code)
+	assert.False(t, e.Has("nobody"))
+	w = do(h, "PUT", "/v1/keys/a", "x", "If-Match", etag)
+	assert.Equal(t, 204, w.Code)
+	etag2 := w.Header().Get("ETag")
+	assert.NotEqual(t, etag, etag2)
+
+	// read once, modified or not
+	hits := e.Stats().Hits
+	w = do(h, "GET", "/v1/keys/a", "", "If-None-Match", etag)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "x", w.Body.String())
+	assert.Equal(t, etag2, w.Header().Get("ETag"))
+	assert.Equal(t, hits+1, e.Stats().Hits)
+
+	w = do(h, "DELETE", "/v1/keys/a", "", "If-Match", etag)
+	assert.Equal(t, 412, w.Code)
+	assert.Equal(t, "precondition_failed", errorCode(t, w))
+	w = do(h, "DELETE", "/v1/keys/a", "", "If-Match", etag2)
+	assert.Equal(t, 204, w.Code)
+	w = do(h, "DELETE", "/v1/keys/a", "", "If-Match", etag2)
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestPrefix(t *testing.T) {
+
+	h, e := newTestHandler(t)
+	for _, k := range []string{"a:1", "a:2", "a:3", "b:1"} {
+		e.Set(k, []byte("v"+k))
+	}
+	e.SetWithTTL("a:2", []byte("va:2"), time.Minute)
+
+	list := func(target string) ([]ListEntry, *httptest.ResponseRecorder) {
+		w := do(h, "GET", target, "")
+		var es []ListEntry
+		sc := bufio.NewScanner(w.Body)
+		for sc.Scan() {
+			var le ListEntry
+			assert.Nil(t, json.Unmarshal(sc.Bytes(), &le))
+			es = append(es, le)
+		}
+		return es, w
+	}
+
+	es, w := list("/v1/prefix/a:?limit=2")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, JSONLinesType, w.Header().Get("Content-Type"))
+	assert.Equal(t, "2", w.Header().Get(NextCursorHeader))
+	assert.Equal(t, 2, len(es))
+	assert.Equal(t, ListEntry{Key: "a:1", Value: []byte("va:1")}, es[0])
+	assert.Equal(t, "a:2", es[1].Key)
+	assert.Equal(t, 60.0, *es[1].TTL)
+
+	es, w = list("/v1/prefix/a:?limit=2&cursor=2")
+	assert.Equal(t, "", w.Header().Get(NextCursorHeader))
+	assert.Equal(t, []ListEntry{{Key: "a:3", Value: []byte("va:3")}}, es)
+
+	es, _ = list("/v1/prefix/")
+	assert.Equal(t, 4, len(es))
+
+	w = do(h, "GET", "/v1/prefix/a:?limit=0", "")
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, "bad_request", errorCode(t, w))
+	w = do(h, "GET", "/v1/prefix/a:?cursor=x", "")
+	assert.Equal(t, "bad_request", errorCode(t, w))
+
+	w = do(h, "DELETE", "/v1/prefix/a:", "")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "{\"deleted\":3}\n", w.Body.String())
+	assert.Equal(t, []string{"b:1"}, e.KeysByPrefix("", 0, 0))
+
+	w = do(h, "DELETE", "/v1/prefix/", "")
+	assert.Equal(t, 400, w.Code)
+	assert.True(t, e.Has("b:1"))
+}
+
+func TestSnapshot(t *testing.T) {
+
+	h, e := newTestHandler(t)
+	e.SetWithMeta("a", []byte("va"), time.Minute, engine.Meta{ContentType: "text/plain", Flags: 7})
+	e.Set("b", []byte("vb"))
+
+	w := do(h, "GET", "/v1/snapshot", "")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, JSONLinesType, w.Header().Get("Content-Type"))
+	snap := w.Body.String()
+
+	h2, e2 := newTestHandler(t)
+	w = do(h2, "PUT", "/v1/snapshot", snap)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "{\"restored\":2}\n", w.Body.String())
+
+	b, m, _, err := e2.GetWithMeta("a")
+	assert.Nil(t, err)
+	assert.Equal(t, "va", string(b))
+	assert.Equal(t, "text/plain", m.ContentType)
+	assert.Equal(t, uint32(7), m.Flags)
+	ttl, ok := e2.TTL("a")
+	assert.True(t, ok)
+	assert.InDelta(t, time.Minute, ttl, float64(time.Second)) // clocks of e and e2 differ
+
+	w = do(h2, "PUT", "/v1/snapshot", "garbage")
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, "bad_request", errorCode(t, w))
+
+	w = do(h2, "POST", "/v1/snapshot", "")
+	assert.Equal(t, 405, w.Code)
+}
@@ -0,0 +1,79 @@
+package httpapi
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/wv0m56/prefixed/plugin/client"
+)
+
+func init() {
+	client.Register("http", func(addr string) (client.ClientPlugin, error) {
+		return NewServer(addr), nil
+	})
+}
+
+// Server serves the handler of NewHandler over HTTP. It implements
+// client.ClientPlugin. A Server serves a single engine, the one of the first
+// call to Serve or ServeListener.
+type Server struct {
+	mu sync.Mutex
+	hs *http.Server
+}
+
+// NewServer returns a Server listening on the TCP address addr once served.
+func NewServer(addr string) *Server {
+	return &Server{hs: &http.Server{Addr: addr}}
+}
+
+// Serve listens on the address given to NewServer and calls ServeListener.
+func (s *Server) Serve(ctx context.Context, eng client.EngineAPI) error {
+	ln, err := net.Listen("tcp", s.hs.Addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeListener(ctx, eng, ln)
+}
+
+// ServeListener serves eng on ln until ln fails, ctx is done, or Shutdown or
+// Close is called, in which cases it returns nil.
+func (s *Server) ServeListener(ctx context.Context, eng client.EngineAPI, ln net.Listener) error {
+
+	s.mu.Lock()
+	if s.hs.Handler == nil {
+		s.hs.Handler = NewHandler(eng)
+	}
+	s.mu.Unlock()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.hs.Close()
+		case <-stop:
+		}
+	}()
+
+	if err := s.hs.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops all listeners and waits for in-flight requests, closing the
+// remaining connections once ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.hs.Shutdown(ctx); err != nil {
+		s.hs.Close()
+		return err
+	}
+	return nil
+}
+
+// Close closes all listeners and connections right away.
+func (s *Server) Close() error {
+	return s.hs.Close()
+}
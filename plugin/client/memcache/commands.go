@@ -0,0 +1,331 @@
+package memcache
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wv0m56/prefixed/engine"
+	"github.com/wv0m56/prefixed/plugin/client"
+)
+
+const (
+	maxLine     = 8 * 1024
+	maxKeyLen   = 250
+	maxValueLen = 1024 * 1024 // memcached's default item size limit
+
+	// exptimes above 30 days are absolute unix times
+	maxRelativeExptime = 60 * 60 * 24 * 30
+)
+
+var errLineTooLong = errors.New("line too long")
+
+// command handles a command line split into words. A returned error closes
+// the connection.
+type command func(s *Server, c *conn, args []string) error
+
+var commands = map[string]command{
+	"get":     get,
+	"gets":    get,
+	"set":     store,
+	"add":     store,
+	"replace": store,
+	"cas":     store,
+	"delete":  del,
+	"touch":   touch,
+	"version": version,
+	"quit":    quit,
+	"mg":      metaGet,
+	"ms":      metaSet,
+	"md":      metaDelete,
+	"mn":      metaNoop,
+}
+
+func (s *Server) dispatch(c *conn, line []byte) error {
+
+	args := strings.Fields(string(line))
+	if len(args) == 0 {
+		c.w.WriteString("ERROR\r\n")
+		return nil
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		c.w.WriteString("ERROR\r\n")
+		return nil
+	}
+	return cmd(s, c, args)
+}
+
+// readLine returns a line without its trailing \r\n or \n.
+func (c *conn) readLine() ([]byte, error) {
+
+	var line []byte
+	for {
+		chunk, isPrefix, err := c.r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxLine {
+			return nil, errLineTooLong
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+// readData reads a data block of n bytes followed by \r\n. ok is false if the
+// block isn't terminated properly, in which case an error reply was written
+// and the rest of the line was skipped.
+func (c *conn) readData(n int) (b []byte, ok bool, err error) {
+
+	b = make([]byte, n+2)
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		return nil, false, err
+	}
+	if b[n] != '\r' || b[n+1] != '\n' {
+		// skip the rest of the line so that the next command is read whole
+		if b[n+1] != '\n' {
+			if _, err := c.readLine(); err != nil && err != errLineTooLong {
+				return nil, false, err
+			}
+		}
+		c.clientError("bad data chunk")
+		return nil, false, nil
+	}
+	return b[:n], true, nil
+}
+
+// readValue reads the data block announced by a storage command. ok is false
+// if the value can't be stored, in which case an error reply was written and
+// the block was skipped if possible.
+func (c *conn) readValue(n int) (b []byte, ok bool, err error) {
+
+	if n > c.maxValue {
+		if _, err := c.r.Discard(n + 2); err != nil {
+			return nil, false, err
+		}
+		c.w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return nil, false, nil
+	}
+	return c.readData(n)
+}
+
+func (c *conn) clientError(msg string) {
+	c.w.WriteString("CLIENT_ERROR ")
+	c.w.WriteString(msg)
+	c.w.WriteString("\r\n")
+}
+
+func (c *conn) reply(s string, noreply bool) {
+	if !noreply {
+		c.w.WriteString(s)
+		c.w.WriteString("\r\n")
+	}
+}
+
+func validKey(k string) bool {
+	return len(k) > 0 && len(k) <= maxKeyLen
+}
+
+// ttlOf converts a memcached exptime into a TTL, 0 meaning none. expired
+// tells whether the item should be gone right away.
+func ttlOf(exptime int64) (ttl time.Duration, expired bool) {
+	switch {
+	case exptime < 0:
+		return 0, true
+	case exptime == 0:
+		return 0, false
+	case exptime > maxRelativeExptime:
+		ttl = time.Until(time.Unix(exptime, 0))
+		return ttl, ttl <= 0
+	default:
+		return time.Duration(exptime) * time.Second, false
+	}
+}
+
+// write performs the storage operation op, one of set, add, replace and cas,
+// and returns the version of the row, see engine.CompareAndSwap, and whether
+// it was stored. A past exptime writes nothing: the row is invalidated as if
+// it had been stored and expired right away.
+func write(e client.EngineAPI, op, key string, version uint64, b []byte, exptime int64, m engine.Meta) (uint64, bool) {
+
+	ttl, expired := ttlOf(exptime)
+	if expired {
+		switch op {
+		case "add":
+			return 0, !e.Has(key)
+		case "cas":
+			return e.DeleteIfVersion(key, version)
+		default:
+			n := e.Invalidate(key)
+			return 0, op == "set" || n == 1
+		}
+	}
+
+	switch op {
+	case "add":
+		return e.Add(key, b, ttl, m)
+	case "replace":
+		return e.Replace(key, b, ttl, m)
+	case "cas":
+		return e.CompareAndSwapWithMeta(key, version, b, ttl, m)
+	}
+	return e.SetWithMeta(key, b, ttl, m), true
+}
+
+// get serves get and gets, the latter also replying CAS values. Misses go
+// through the engine's cache fill, keys which can't be filled are left out.
+func get(s *Server, c *conn, args []string) error {
+
+	if len(args) < 2 {
+		c.w.WriteString("ERROR\r\n")
+		return nil
+	}
+
+	for _, k := range args[1:] {
+
+		if !validKey(k) {
+			c.clientError("bad command line format")
+			return nil
+		}
+
+		b, m, v, err := c.e.GetWithMeta(k)
+		if err != nil {
+			continue
+		}
+
+		c.w.WriteString("VALUE ")
+		c.w.WriteString(k)
+		c.w.WriteString(" ")
+		c.w.WriteString(strconv.FormatUint(uint64(m.Flags), 10))
+		c.w.WriteString(" ")
+		c.w.WriteString(strconv.Itoa(len(b)))
+		if args[0] == "gets" {
+			c.w.WriteString(" ")
+			c.w.WriteString(strconv.FormatUint(v, 10))
+		}
+		c.w.WriteString("\r\n")
+		c.w.Write(b)
+		c.w.WriteString("\r\n")
+	}
+	c.w.WriteString("END\r\n")
+	return nil
+}
+
+// store serves set, add, replace and cas:
+//
+//	<command> <key> <flags> <exptime> <bytes> [noreply]
+//	cas <key> <flags> <exptime> <bytes> <cas unique> [noreply]
+func store(s *Server, c *conn, args []string) error {
+
+	nargs := 5
+	if args[0] == "cas" {
+		nargs = 6
+	}
+	if len(args) != nargs && (len(args) != nargs+1 || args[nargs] != "noreply") {
+		c.w.WriteString("ERROR\r\n")
+		return nil
+	}
+	noreply := len(args) == nargs+1
+
+	flags, err1 := strconv.ParseUint(args[2], 10, 32)
+	exptime, err2 := strconv.ParseInt(args[3], 10, 64)
+	n, err3 := strconv.Atoi(args[4])
+	var version uint64
+	var err4 error
+	if args[0] == "cas" {
+		version, err4 = strconv.ParseUint(args[5], 10, 64)
+	}
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || n < 0 || !validKey(args[1]) {
+		c.clientError("bad command line format")
+		return nil
+	}
+
+	b, ok, err := c.readValue(n)
+	if err != nil || !ok {
+		return err
+	}
+
+	v, ok := write(c.e, args[0], args[1], version, b, exptime, engine.Meta{Flags: uint32(flags)})
+
+	switch {
+	case ok:
+		c.reply("STORED", noreply)
+	case args[0] != "cas":
+		c.reply("NOT_STORED", noreply)
+	case v != 0:
+		c.reply("EXISTS", noreply)
+	default:
+		c.reply("NOT_FOUND", noreply)
+	}
+	return nil
+}
+
+// del serves delete <key> [noreply].
+func del(s *Server, c *conn, args []string) error {
+
+	if len(args) != 2 && (len(args) != 3 || args[2] != "noreply") {
+		c.clientError("bad command line format.  Usage: delete <key> [noreply]")
+		return nil
+	}
+
+	if c.e.Invalidate(args[1]) == 1 {
+		c.reply("DELETED", len(args) == 3)
+	} else {
+		c.reply("NOT_FOUND", len(args) == 3)
+	}
+	return nil
+}
+
+// touch serves touch <key> <exptime> [noreply].
+func touch(s *Server, c *conn, args []string) error {
+
+	if len(args) != 3 && (len(args) != 4 || args[3] != "noreply") {
+		c.w.WriteString("ERROR\r\n")
+		return nil
+	}
+
+	exptime, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		c.clientError("invalid exptime argument")
+		return nil
+	}
+
+	if setExptime(c.e, args[1], exptime) {
+		c.reply("TOUCHED", len(args) == 4)
+	} else {
+		c.reply("NOT_FOUND", len(args) == 4)
+	}
+	return nil
+}
+
+// setExptime applies a memcached exptime to key. It returns false if key is
+// not in the cache.
+func setExptime(e client.EngineAPI, key string, exptime int64) bool {
+
+	ttl, expired := ttlOf(exptime)
+	switch {
+	case expired:
+		return e.Invalidate(key) == 1
+	case ttl == 0:
+		e.Persist(key)
+		return e.Has(key)
+	default:
+		return e.Expire(key, ttl)
+	}
+}
+
+func version(_ *Server, c *conn, _ []string) error {
+	c.w.WriteString("VERSION prefixed\r\n")
+	return nil
+}
+
+func quit(_ *Server, c *conn, _ []string) error {
+	c.quit = true
+	return nil
+}
@@ -0,0 +1,253 @@
+package memcache
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/wv0m56/prefixed/engine"
+)
+
+// metaFlags are the flags of a meta command, each a single character
+// optionally followed by a token, e.g. "v", "T30" or "Oabc".
+type metaFlags map[byte]string
+
+func parseMetaFlags(args []string) (metaFlags, bool) {
+	fs := metaFlags{}
+	for _, a := range args {
+		if len(a) == 0 {
+			return nil, false
+		}
+		fs[a[0]] = a[1:]
+	}
+	return fs, true
+}
+
+func (fs metaFlags) has(f byte) bool {
+	_, ok := fs[f]
+	return ok
+}
+
+// ret appends the k and O flags to the return flags of a reply, which are
+// echoed whatever the command.
+func (fs metaFlags) ret(key string, ret []string) []string {
+	if fs.has('k') {
+		ret = append(ret, "k"+key)
+	}
+	if o, ok := fs['O']; ok {
+		ret = append(ret, "O"+o)
+	}
+	return ret
+}
+
+// metaReply writes code followed by return flags, unless q asks to suppress
+// code.
+func (c *conn) metaReply(fs metaFlags, code string, ret []string, quiet ...string) {
+
+	if fs.has('q') {
+		for _, q := range quiet {
+			if q == code {
+				return
+			}
+		}
+	}
+
+	c.w.WriteString(code)
+	for _, r := range ret {
+		c.w.WriteString(" ")
+		c.w.WriteString(r)
+	}
+	c.w.WriteString("\r\n")
+}
+
+// metaGet serves mg <key> <flags>*. Supported flags are c, f, k, O, q, s, t,
+// T and v. Misses go through the engine's cache fill, keys which can't be
+// filled are replied EN.
+func metaGet(s *Server, c *conn, args []string) error {
+
+	if len(args) < 2 || !validKey(args[1]) {
+		c.clientError("bad command line format")
+		return nil
+	}
+	fs, ok := parseMetaFlags(args[2:])
+	if !ok {
+		c.clientError("invalid flag")
+		return nil
+	}
+	key := args[1]
+
+	var exptime int64
+	t, touch := fs['T']
+	if touch {
+		var err error
+		if exptime, err = strconv.ParseInt(t, 10, 64); err != nil {
+			c.clientError("bad token in command line format")
+			return nil
+		}
+	}
+
+	b, m, v, err := c.e.GetWithMeta(key)
+	if err != nil {
+		c.metaReply(fs, "EN", nil, "EN")
+		return nil
+	}
+	if touch {
+		setExptime(c.e, key, exptime)
+	}
+
+	var ret []string
+	if fs.has('f') {
+		ret = append(ret, "f"+strconv.FormatUint(uint64(m.Flags), 10))
+	}
+	if fs.has('c') {
+		ret = append(ret, "c"+strconv.FormatUint(v, 10))
+	}
+	if fs.has('s') {
+		ret = append(ret, "s"+strconv.Itoa(len(b)))
+	}
+	if fs.has('t') {
+		ttl := int64(-1)
+		if d, ok := c.e.TTL(key); ok {
+			ttl = int64(math.Max(0, math.Round(d.Seconds())))
+		}
+		ret = append(ret, "t"+strconv.FormatInt(ttl, 10))
+	}
+	ret = fs.ret(key, ret)
+
+	if !fs.has('v') {
+		c.metaReply(fs, "HD", ret)
+		return nil
+	}
+
+	c.metaReply(fs, "VA "+strconv.Itoa(len(b)), ret)
+	c.w.Write(b)
+	c.w.WriteString("\r\n")
+	return nil
+}
+
+// metaSet serves ms <key> <datalen> <flags>*. Supported flags are c, C, F,
+// k, M with modes S, E and R, O, q and T. C compares with modes S and R only.
+func metaSet(s *Server, c *conn, args []string) error {
+
+	if len(args) < 3 || !validKey(args[1]) {
+		c.clientError("bad command line format")
+		return nil
+	}
+	n, err := strconv.Atoi(args[2])
+	if err != nil || n < 0 {
+		c.clientError("bad data chunk")
+		return nil
+	}
+
+	b, ok, err := c.readValue(n)
+	if err != nil || !ok {
+		return err
+	}
+
+	fs, ok := parseMetaFlags(args[3:])
+	if !ok {
+		c.clientError("invalid flag")
+		return nil
+	}
+	var version uint64
+	if cas, ok := fs['C']; ok {
+		if version, err = strconv.ParseUint(cas, 10, 64); err != nil {
+			c.clientError("bad token in command line format")
+			return nil
+		}
+	}
+
+	var m engine.Meta
+	if f, ok := fs['F']; ok {
+		flags, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			c.clientError("bad token in command line format")
+			return nil
+		}
+		m.Flags = uint32(flags)
+	}
+
+	var exptime int64
+	if t, ok := fs['T']; ok {
+		if exptime, err = strconv.ParseInt(t, 10, 64); err != nil {
+			c.clientError("bad token in command line format")
+			return nil
+		}
+	}
+	var op string
+	switch mode := strings.ToUpper(fs['M']); {
+	case fs.has('C') && (mode == "" || mode == "S" || mode == "R"):
+		op = "cas"
+	case mode == "" || mode == "S":
+		op = "set"
+	case mode == "E" && !fs.has('C'):
+		op = "add"
+	case mode == "R":
+		op = "replace"
+	default:
+		c.clientError("invalid mode for ms")
+		return nil
+	}
+
+	key := args[1]
+	v, ok := write(c.e, op, key, version, b, exptime, m)
+
+	var ret []string
+	if ok && fs.has('c') {
+		ret = append(ret, "c"+strconv.FormatUint(v, 10))
+	}
+	ret = fs.ret(key, ret)
+
+	switch {
+	case ok:
+		c.metaReply(fs, "HD", ret, "HD")
+	case !fs.has('C'):
+		c.metaReply(fs, "NS", ret)
+	case v != 0:
+		c.metaReply(fs, "EX", ret)
+	default:
+		c.metaReply(fs, "NF", ret)
+	}
+	return nil
+}
+
+// metaDelete serves md <key> <flags>*. Supported flags are C, k, O and q.
+func metaDelete(s *Server, c *conn, args []string) error {
+
+	if len(args) < 2 || !validKey(args[1]) {
+		c.clientError("bad command line format")
+		return nil
+	}
+	fs, ok := parseMetaFlags(args[2:])
+	if !ok {
+		c.clientError("invalid flag")
+		return nil
+	}
+
+	key := args[1]
+	code := "NF"
+	if cas, ok := fs['C']; ok {
+		version, err := strconv.ParseUint(cas, 10, 64)
+		if err != nil {
+			c.clientError("bad token in command line format")
+			return nil
+		}
+		if v, ok := c.e.DeleteIfVersion(key, version); ok {
+			code = "HD"
+		} else if v != 0 {
+			code = "EX"
+		}
+	} else if c.e.Invalidate(key) == 1 {
+		code = "HD"
+	}
+
+	c.metaReply(fs, code, fs.ret(key, nil), "HD", "NF")
+	return nil
+}
+
+// metaNoop serves mn, which quiet mode clients send to learn that all prior
+// commands were processed.
+func metaNoop(_ *Server, c *conn, _ []string) error {
+	c.w.WriteString("MN\r\n")
+	return nil
+}
@@ -0,0 +1,76 @@
+// Package memcache serves an engine over the memcached text protocol,
+// including the meta commands, so that existing memcached clients can talk to
+// it. Client flags are stored as engine row metadata, CAS values are engine
+// row versions.
+package memcache
+
+import (
+	"bufio"
+	"net"
+
+	"github.com/wv0m56/prefixed/plugin/client"
+)
+
+func init() {
+	client.Register("memcache", func(addr string) (client.ClientPlugin, error) {
+		return NewServer(addr), nil
+	})
+}
+
+// Server serves an engine to memcached clients. It implements
+// client.ClientPlugin.
+type Server struct {
+	*client.TCPServer
+}
+
+// NewServer returns a Server listening on the TCP address addr once served.
+func NewServer(addr string) *Server {
+	s := &Server{}
+	s.TCPServer = client.NewTCPServer(addr, s.serveConn)
+	return s
+}
+
+// valueLimit returns the longest value accepted when serving eng.
+func valueLimit(eng client.EngineAPI) int {
+	if m := eng.Stats().MaxPayloadTotalSize; m < int64(maxValueLen) {
+		return int(m)
+	}
+	return maxValueLen
+}
+
+// conn is the state of a client connection.
+type conn struct {
+	e        client.EngineAPI
+	r        *bufio.Reader
+	w        *bufio.Writer
+	maxValue int
+	quit     bool
+}
+
+func (s *Server) serveConn(nc net.Conn, eng client.EngineAPI) {
+
+	c := &conn{eng, bufio.NewReader(nc), bufio.NewWriter(nc), valueLimit(eng), false}
+
+	for !c.quit {
+
+		line, err := c.readLine()
+		if err == errLineTooLong {
+			c.w.WriteString("CLIENT_ERROR line too long\r\n")
+			c.w.Flush()
+			return
+		} else if err != nil {
+			return
+		}
+
+		if err := s.dispatch(c, line); err != nil {
+			return
+		}
+
+		// pipelined commands are replied to in one go
+		if c.r.Buffered() == 0 || c.quit {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
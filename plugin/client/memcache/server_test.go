@@ -0,0 +1,282 @@
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/engine"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+// testClient is a raw memcached client reading replies line by line.
+type testClient struct {
+	t  *testing.T
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+// do sends req and returns the reply lines up to and including the line
+// starting with last, data blocks following VALUE and VA lines included.
+func (c *testClient) do(req, last string) []string {
+
+	_, err := io.WriteString(c.nc, req)
+	assert.Nil(c.t, err)
+
+	var lines []string
+	for {
+		line, err := c.r.ReadString('\n')
+		if !assert.Nil(c.t, err) {
+			return lines
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+		lines = append(lines, line)
+		if strings.HasPrefix(line, "VALUE ") || strings.HasPrefix(line, "VA ") {
+			data, err := c.r.ReadString('\n')
+			assert.Nil(c.t, err)
+			lines = append(lines, strings.TrimSuffix(data, "\r\n"))
+		}
+		if strings.HasPrefix(line, last) {
+			return lines
+		}
+	}
+}
+
+// errOrigin fails to fetch keys starting with "err", memcached keys can't
+// hold the space of fake.NoDelayOrigin's failing key.
+type errOrigin struct{ fake.NoDelayOrigin }
+
+func (o *errOrigin) Fetch(key string, timeout time.Duration) (io.ReadCloser, *time.Time) {
+	if strings.HasPrefix(key, "err") {
+		return nil, nil
+	}
+	return o.NoDelayOrigin.Fetch(key, timeout)
+}
+
+// smallEngine reports a payload limit below the one of memcached values, which
+// engine options don't allow.
+type smallEngine struct{ *engine.Engine }
+
+func (smallEngine) Stats() engine.Stats {
+	return engine.Stats{MaxPayloadTotalSize: 1000}
+}
+
+func newTestServer(t *testing.T) (*Server, *engine.Engine, string) {
+
+	opts := engine.OptionsDefault
+	opts.O = &errOrigin{}
+	e, err := engine.NewEngine(&opts)
+	assert.Nil(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	s := NewServer("")
+	go s.ServeListener(context.Background(), e, ln)
+	return s, e, ln.Addr().String()
+}
+
+// dial connects to addr, a missing reply fails the test after a while rather
+// than hanging it.
+func dial(t *testing.T, addr string) *testClient {
+	nc, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	nc.SetDeadline(time.Now().Add(5 * time.Second))
+	return &testClient{t, nc, bufio.NewReader(nc)}
+}
+
+func TestCommands(t *testing.T) {
+
+	s, e, addr := newTestServer(t)
+	defer s.Close()
+	c := dial(t, addr)
+
+	assert.Equal(t, []string{"VERSION prefixed"}, c.do("version\r\n", "VERSION"))
+	assert.Equal(t, []string{"ERROR"}, c.do("nope\r\n", "ERROR"))
+
+	// get fills from origin, keys which can't be filled are left out
+	assert.Equal(t, []string{"VALUE a 0 1", "a", "VALUE b 0 1", "b", "END"},
+		c.do("get a err b\r\n", "END"))
+
+	assert.Equal(t, []string{"STORED"}, c.do("set k 42 0 5\r\nhello\r\n", "STORED"))
+	lines := c.do("gets k\r\n", "END")
+	assert.Equal(t, "hello", lines[1])
+	assert.True(t, strings.HasPrefix(lines[0], "VALUE k 42 5 "))
+	assert.NotEqual(t, "VALUE k 42 5 0", lines[0])
+
+	assert.Equal(t, []string{"NOT_STORED"}, c.do("add k 0 0 1\r\nx\r\n", "NOT_STORED"))
+	assert.Equal(t, []string{"STORED"}, c.do("add k2 1 100 1\r\nx\r\n", "STORED"))
+	ttl, ok := e.TTL("k2")
+	assert.True(t, ok)
+	assert.Equal(t, 100*time.Second, ttl.Round(time.Second))
+	assert.Equal(t, []string{"NOT_STORED"}, c.do("replace k3 0 0 1\r\nx\r\n", "NOT_STORED"))
+	assert.False(t, e.Has("k3"))
+	assert.Equal(t, []string{"STORED"}, c.do("replace k 7 0 3\r\nbye\r\n", "STORED"))
+	assert.Equal(t, []string{"VALUE k 7 3", "bye", "END"}, c.do("get k\r\n", "END"))
+
+	// cas values are row versions
+	cas := strings.Fields(c.do("gets k\r\n", "END")[0])[4]
+	assert.Equal(t, []string{"STORED"}, c.do("cas k 8 0 2 "+cas+"\r\nhi\r\n", "STORED"))
+	assert.Equal(t, []string{"EXISTS"}, c.do("cas k 9 0 2 "+cas+"\r\nho\r\n", "EXISTS"))
+	assert.Equal(t, []string{"NOT_FOUND"}, c.do("cas nobody 0 0 1 "+cas+"\r\nx\r\n", "NOT_FOUND"))
+	assert.Equal(t, []string{"VALUE k 8 2", "hi", "END"}, c.do("get k\r\n", "END"))
+	assert.Equal(t, []string{"ERROR"}, c.do("cas k 0 0 1\r\n", "ERROR"))
+
+	// negative exptimes expire right away, nothing is written
+	gone := e.Watch("gone")
+	defer gone.Close()
+	assert.Equal(t, []string{"STORED"}, c.do("set gone 0 -1 1\r\nx\r\n", "STORED"))
+	assert.False(t, e.Has("gone"))
+	assert.Equal(t, []string{"NOT_STORED"}, c.do("replace gone 0 -1 1\r\nx\r\n", "NOT_STORED"))
+	e.Set("gone", []byte("v"))
+	assert.Equal(t, []string{"NOT_STORED"}, c.do("add gone 0 -1 1\r\nx\r\n", "NOT_STORED"))
+	assert.Equal(t, []string{"STORED"}, c.do("replace gone 0 -1 1\r\nx\r\n", "STORED"))
+	assert.False(t, e.Has("gone"))
+	assert.Equal(t, engine.EventSet, (<-gone.C).Reason)
+	assert.Equal(t, engine.EventInvalidate, (<-gone.C).Reason)
+	assert.Equal(t, 0, len(gone.C))
+
+	assert.Equal(t, []string{"TOUCHED"}, c.do("touch k 50\r\n", "TOUCHED"))
+	ttl, ok = e.TTL("k")
+	assert.True(t, ok)
+	assert.Equal(t, 50*time.Second, ttl.Round(time.Second))
+	assert.Equal(t, []string{"TOUCHED"}, c.do("touch k 0\r\n", "TOUCHED"))
+	_, ok = e.TTL("k")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"NOT_FOUND"}, c.do("touch nobody 10\r\n", "NOT_FOUND"))
+
+	assert.Equal(t, []string{"DELETED"}, c.do("delete k\r\n", "DELETED"))
+	assert.Equal(t, []string{"NOT_FOUND"}, c.do("delete k\r\n", "NOT_FOUND"))
+
+	// noreply, pipelined
+	assert.Equal(t, []string{"VALUE n 3 1", "1", "END"},
+		c.do("set n 3 0 1 noreply\r\n1\r\ndelete nobody noreply\r\nget n\r\n", "END"))
+
+	// malformed
+	assert.Equal(t, []string{"CLIENT_ERROR bad command line format"}, c.do("set k x 0 1\r\n", "CLIENT_ERROR"))
+	assert.Equal(t, []string{"CLIENT_ERROR bad data chunk"}, c.do("set k 0 0 1\r\nxy\r\n", "CLIENT_ERROR"))
+	assert.Equal(t, []string{"CLIENT_ERROR bad command line format"},
+		c.do("get "+strings.Repeat("k", 251)+"\r\n", "CLIENT_ERROR"))
+	assert.Equal(t, []string{"SERVER_ERROR object too large for cache"},
+		c.do(fmt.Sprintf("set big 0 0 %d\r\n%s\r\n", maxValueLen+1, strings.Repeat("x", maxValueLen+1)), "SERVER_ERROR"))
+
+	// capped by the payload limit of the engine
+	assert.Equal(t, maxValueLen, valueLimit(e))
+	assert.Equal(t, 1000, valueLimit(smallEngine{e}))
+
+	_, err := io.WriteString(c.nc, "quit\r\n")
+	assert.Nil(t, err)
+	_, err = c.r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestMetaCommands(t *testing.T) {
+
+	s, e, addr := newTestServer(t)
+	defer s.Close()
+	c := dial(t, addr)
+
+	assert.Equal(t, []string{"VA 1 f0 s1 t-1 ka", "a"}, c.do("mg a v f s t k\r\n", "VA"))
+	assert.Equal(t, []string{"EN"}, c.do("mg err:1\r\n", "EN"))
+
+	lines := c.do("ms k 2 F5 T60 c Oop\r\nhi\r\n", "HD")
+	assert.True(t, strings.HasPrefix(lines[0], "HD c"))
+	assert.True(t, strings.HasSuffix(lines[0], " Oop"))
+	cas := strings.Fields(lines[0])[1]
+
+	assert.Equal(t, []string{"VA 2 f5 " + cas + " t60", "hi"}, c.do("mg k v f c t\r\n", "VA"))
+	assert.Equal(t, []string{"HD"}, c.do("mg k T10\r\n", "HD"))
+	ttl, _ := e.TTL("k")
+	assert.Equal(t, 10*time.Second, ttl.Round(time.Second))
+
+	assert.Equal(t, []string{"NS"}, c.do("ms k 1 ME\r\nx\r\n", "NS"))
+	assert.Equal(t, []string{"NS kk2"}, c.do("ms k2 1 MR k\r\nx\r\n", "NS"))
+	assert.Equal(t, []string{"HD"}, c.do("ms k2 1 ME\r\nx\r\n", "HD"))
+	assert.Equal(t, []string{"CLIENT_ERROR invalid mode for ms"}, c.do("ms k2 1 MA\r\nx\r\n", "CLIENT_ERROR"))
+
+	// past exptimes only invalidate
+	assert.Equal(t, []string{"HD"}, c.do("ms k2 1 MR T-1\r\ny\r\n", "HD"))
+	assert.False(t, e.Has("k2"))
+	assert.Equal(t, []string{"HD"}, c.do("ms k2 1 ME\r\nx\r\n", "HD"))
+
+	assert.Equal(t, []string{"HD Oz"}, c.do("md k2 Oz\r\n", "HD"))
+	assert.Equal(t, []string{"NF"}, c.do("md k2\r\n", "NF"))
+
+	// compare and swap
+	v1 := strings.TrimPrefix(c.do("ms k4 1 c\r\nx\r\n", "HD")[0], "HD c")
+	assert.Equal(t, []string{"EX"}, c.do("ms k4 1 C1\r\ny\r\n", "EX"))
+	assert.Equal(t, []string{"NF"}, c.do("ms nobody 1 C"+v1+"\r\ny\r\n", "NF"))
+	assert.Equal(t, []string{"CLIENT_ERROR invalid mode for ms"}, c.do("ms k4 1 C"+v1+" ME\r\ny\r\n", "CLIENT_ERROR"))
+	lines = c.do("ms k4 1 c MR C"+v1+"\r\ny\r\n", "HD")
+	v2 := strings.TrimPrefix(lines[0], "HD c")
+	assert.NotEqual(t, v1, v2)
+	assert.Equal(t, []string{"VA 1 c" + v2, "y"}, c.do("mg k4 v c\r\n", "VA"))
+	assert.Equal(t, []string{"EX"}, c.do("md k4 C"+v1+"\r\n", "EX"))
+	assert.Equal(t, []string{"HD"}, c.do("md k4 C"+v2+"\r\n", "HD"))
+	assert.Equal(t, []string{"NF"}, c.do("md k4 C"+v2+"\r\n", "NF"))
+
+	// a bad data chunk is skipped up to the end of its line
+	assert.Equal(t, []string{"CLIENT_ERROR bad data chunk", "MN"}, c.do("ms k3 1\r\nxyz\r\nmn\r\n", "MN"))
+
+	// quiet mode only replies failures, mn flushes
+	assert.Equal(t, []string{"NS", "MN"},
+		c.do("ms q1 1 q\r\nx\r\nms k 1 q ME\r\nx\r\nmd q1 q\r\nmd q1 q\r\nmg err:1 q\r\nmn\r\n", "MN"))
+	assert.False(t, e.Has("q1"))
+}
+
+func TestClose(t *testing.T) {
+
+	s, _, addr := newTestServer(t)
+	c := dial(t, addr)
+	assert.Equal(t, []string{"MN"}, c.do("mn\r\n", "MN"))
+
+	done := make(chan struct{})
+	go func() {
+		s.Close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked")
+	}
+
+	_, err := c.r.ReadByte()
+	assert.NotNil(t, err)
+	_, err = net.Dial("tcp", addr)
+	assert.NotNil(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	assert.Nil(t, s.ServeListener(context.Background(), nil, ln))
+}
+
+func TestShutdown(t *testing.T) {
+
+	s, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	// pipelined commands received before Shutdown are still replied to
+	_, err := io.WriteString(c.nc, "set a 0 0 1\r\nb\r\nget a\r\n")
+	assert.Nil(t, err)
+	line, err := c.r.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "STORED\r\n", line)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, s.Shutdown(ctx))
+
+	assert.Equal(t, []string{"VALUE a 0 1", "b", "END"}, c.do("", "END"))
+	_, err = c.r.ReadByte()
+	assert.NotNil(t, err)
+	_, err = net.Dial("tcp", addr)
+	assert.NotNil(t, err)
+}
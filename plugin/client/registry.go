@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory returns a frontend which listens on addr once served.
+type Factory func(addr string) (ClientPlugin, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes a frontend available under name, normally from the init
+// function of the package implementing it. It panics if name is already
+// registered or f is nil.
+func Register(name string, f Factory) {
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if f == nil {
+		panic("client: nil factory for " + name)
+	}
+	if _, ok := registry[name]; ok {
+		panic("client: frontend registered twice: " + name)
+	}
+	registry[name] = f
+}
+
+// New returns the frontend registered under name, listening on addr once
+// served.
+func New(name, addr string) (ClientPlugin, error) {
+
+	registryMu.RLock()
+	f, ok := registry[name]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("client: unknown frontend %q (forgotten import?)", name)
+	}
+	return f(addr)
+}
+
+// Registered returns the sorted names of the registered frontends.
+func Registered() []string {
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for n := range registry {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Group runs several frontends against one engine. Add all frontends before
+// calling Serve.
+type Group struct {
+	mu      sync.Mutex
+	plugins []namedPlugin
+}
+
+type namedPlugin struct {
+	name string
+	p    ClientPlugin
+}
+
+// Add adds p to g, name being used in errors.
+func (g *Group) Add(name string, p ClientPlugin) {
+	g.mu.Lock()
+	g.plugins = append(g.plugins, namedPlugin{name, p})
+	g.mu.Unlock()
+}
+
+// Serve serves eng with every frontend of g until ctx is done or Shutdown is
+// called. If a frontend fails, the others are stopped and its error is
+// returned.
+func (g *Group) Serve(ctx context.Context, eng EngineAPI) error {
+
+	ps := g.list()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errc := make(chan error, len(ps))
+	for _, np := range ps {
+		go func(np namedPlugin) {
+			err := np.p.Serve(ctx, eng)
+			if err != nil {
+				err = fmt.Errorf("%s: %v", np.name, err)
+			}
+			errc <- err
+		}(np)
+	}
+
+	var first error
+	for range ps {
+		if err := <-errc; err != nil && first == nil {
+			first = err
+			cancel()
+		}
+	}
+	return first
+}
+
+// Shutdown shuts every frontend of g down concurrently, see
+// ClientPlugin.Shutdown. It returns the first error.
+func (g *Group) Shutdown(ctx context.Context) error {
+
+	ps := g.list()
+	errc := make(chan error, len(ps))
+	for _, np := range ps {
+		go func(np namedPlugin) {
+			err := np.p.Shutdown(ctx)
+			if err != nil {
+				err = fmt.Errorf("%s: %v", np.name, err)
+			}
+			errc <- err
+		}(np)
+	}
+
+	var first error
+	for range ps {
+		if err := <-errc; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (g *Group) list() []namedPlugin {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]namedPlugin(nil), g.plugins...)
+}
+
+var _ ClientPlugin = (*Group)(nil)
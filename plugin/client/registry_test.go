@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubPlugin serves until ctx is done or it is shut down, failing right away
+// if fail is set.
+type stubPlugin struct {
+	fail     error
+	stop     chan struct{}
+	shutdown bool
+}
+
+func newStub(fail error) *stubPlugin {
+	return &stubPlugin{fail: fail, stop: make(chan struct{})}
+}
+
+func (p *stubPlugin) Serve(ctx context.Context, _ EngineAPI) error {
+	if p.fail != nil {
+		return p.fail
+	}
+	select {
+	case <-ctx.Done():
+	case <-p.stop:
+	}
+	return nil
+}
+
+func (p *stubPlugin) Shutdown(ctx context.Context) error {
+	p.shutdown = true
+	close(p.stop)
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+
+	stub := newStub(nil)
+	defer func() {
+		registryMu.Lock()
+		delete(registry, "stub")
+		registryMu.Unlock()
+	}()
+	Register("stub", func(addr string) (ClientPlugin, error) {
+		assert.Equal(t, ":1234", addr)
+		return stub, nil
+	})
+	assert.Panics(t, func() { Register("stub", nil) })
+	assert.Panics(t, func() {
+		Register("stub", func(string) (ClientPlugin, error) { return nil, nil })
+	})
+
+	assert.Contains(t, Registered(), "stub")
+
+	p, err := New("stub", ":1234")
+	assert.Nil(t, err)
+	assert.Equal(t, stub, p)
+
+	_, err = New("nope", ":1234")
+	assert.NotNil(t, err)
+}
+
+func TestGroup(t *testing.T) {
+
+	// Shutdown stops every frontend
+	var g Group
+	a, b := newStub(nil), newStub(nil)
+	g.Add("a", a)
+	g.Add("b", b)
+
+	errc := make(chan error)
+	go func() { errc <- g.Serve(context.Background(), nil) }()
+	assert.Nil(t, g.Shutdown(context.Background()))
+	assert.True(t, a.shutdown && b.shutdown)
+	assert.Nil(t, waitErr(t, errc))
+
+	// a failing frontend stops the others
+	g = Group{}
+	g.Add("ok", newStub(nil))
+	g.Add("bad", newStub(errors.New("boom")))
+	go func() { errc <- g.Serve(context.Background(), nil) }()
+	assert.EqualError(t, waitErr(t, errc), "bad: boom")
+
+	// as does ctx
+	g = Group{}
+	g.Add("ok", newStub(nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { errc <- g.Serve(ctx, nil) }()
+	cancel()
+	assert.Nil(t, waitErr(t, errc))
+}
+
+func waitErr(t *testing.T, errc chan error) error {
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("Serve didn't return")
+		return nil
+	}
+}
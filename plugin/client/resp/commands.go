@@ -0,0 +1,418 @@
+package resp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wv0m56/prefixed/engine"
+)
+
+// command describes a supported command. arity counts the command name, a
+// negative arity -n means at least n.
+type command struct {
+	arity int
+	fn    func(s *Server, c *conn, args [][]byte)
+}
+
+var commands = map[string]command{
+	"ping":        {-1, ping},
+	"hello":       {-1, hello},
+	"quit":        {1, quit},
+	"select":      {2, selectDB},
+	"command":     {-1, commandCmd},
+	"get":         {2, get},
+	"mget":        {-2, mget},
+	"set":         {-3, set},
+	"del":         {-2, del},
+	"incr":        {2, incr},
+	"decr":        {2, incr},
+	"incrby":      {3, incr},
+	"decrby":      {3, incr},
+	"incrbyfloat": {3, incrByFloat},
+	"expire":      {3, expire},
+	"ttl":         {2, ttl},
+	"pttl":        {2, ttl},
+	"persist":     {2, persist},
+	"scan":        {-2, scan},
+	"keys":        {2, keys},
+	"info":        {-1, info},
+}
+
+func (s *Server) dispatch(c *conn, args [][]byte) {
+
+	name := strings.ToLower(string(args[0]))
+	cmd, ok := commands[name]
+	if !ok {
+		c.wr.err(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return
+	}
+
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		c.wr.err(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return
+	}
+
+	cmd.fn(s, c, args)
+}
+
+func ping(_ *Server, c *conn, args [][]byte) {
+	switch len(args) {
+	case 1:
+		c.wr.simple("PONG")
+	case 2:
+		c.wr.bulk(args[1])
+	default:
+		c.wr.err("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func hello(_ *Server, c *conn, args [][]byte) {
+
+	if len(args) > 1 {
+		v, err := strconv.Atoi(string(args[1]))
+		if err != nil || (v != 2 && v != 3) {
+			c.wr.err("NOPROTO unsupported protocol version")
+			return
+		}
+		c.wr.proto = v
+	}
+
+	c.wr.mapHeader(4)
+	c.wr.bulkString("server")
+	c.wr.bulkString("prefixed")
+	c.wr.bulkString("proto")
+	c.wr.int(int64(c.wr.proto))
+	c.wr.bulkString("mode")
+	c.wr.bulkString("standalone")
+	c.wr.bulkString("role")
+	c.wr.bulkString("master")
+}
+
+func quit(_ *Server, c *conn, _ [][]byte) {
+	c.wr.simple("OK")
+	c.quit = true
+}
+
+// selectDB only knows database 0, some clients select it upon connecting.
+func selectDB(_ *Server, c *conn, args [][]byte) {
+	if string(args[1]) != "0" {
+		c.wr.err("ERR DB index is out of range")
+		return
+	}
+	c.wr.simple("OK")
+}
+
+// commandCmd replies with no command documentation, enough for redis-cli.
+func commandCmd(_ *Server, c *conn, _ [][]byte) {
+	c.wr.array(0)
+}
+
+// get triggers a cache fill upon cache miss. Fill errors are replied as
+// errors, not as nil.
+func get(s *Server, c *conn, args [][]byte) {
+	b, err := c.e.GetCopy(string(args[1]))
+	if err != nil {
+		c.wr.err("ERR " + err.Error())
+		return
+	}
+	c.wr.bulk(b)
+}
+
+// mget replies nil for keys which can't be filled.
+func mget(s *Server, c *conn, args [][]byte) {
+	c.wr.array(len(args) - 1)
+	for _, k := range args[1:] {
+		if b, err := c.e.GetCopy(string(k)); err != nil {
+			c.wr.null()
+		} else {
+			c.wr.bulk(b)
+		}
+	}
+}
+
+// set supports the EX and PX options.
+func set(s *Server, c *conn, args [][]byte) {
+
+	var ttl time.Duration
+	for i := 3; i < len(args); i++ {
+
+		opt := strings.ToLower(string(args[i]))
+		if (opt != "ex" && opt != "px") || ttl != 0 || i+1 == len(args) {
+			c.wr.err("ERR syntax error")
+			return
+		}
+
+		i++
+		n, err := strconv.ParseInt(string(args[i]), 10, 64)
+		if err != nil || n <= 0 {
+			c.wr.err("ERR invalid expire time in 'set' command")
+			return
+		}
+
+		if opt == "ex" {
+			ttl = time.Duration(n) * time.Second
+		} else {
+			ttl = time.Duration(n) * time.Millisecond
+		}
+	}
+
+	c.e.SetWithTTL(string(args[1]), args[2], ttl)
+	c.wr.simple("OK")
+}
+
+func del(s *Server, c *conn, args [][]byte) {
+	c.wr.int(int64(c.e.Invalidate(stringArgs(args[1:])...)))
+}
+
+// incr serves INCR, DECR, INCRBY and DECRBY.
+func incr(s *Server, c *conn, args [][]byte) {
+
+	name := strings.ToLower(string(args[0]))
+	n := int64(1)
+	if len(args) == 3 {
+		var err error
+		if n, err = strconv.ParseInt(string(args[2]), 10, 64); err != nil {
+			c.wr.err("ERR value is not an integer or out of range")
+			return
+		}
+	}
+	if strings.HasPrefix(name, "decr") {
+		if n == math.MinInt64 {
+			c.wr.err("ERR decrement would overflow")
+			return
+		}
+		n = -n
+	}
+
+	v, err := c.e.IncrBy(string(args[1]), n, 0)
+	switch err {
+	case nil:
+		c.wr.int(v)
+	case engine.ErrOverflow:
+		c.wr.err("ERR increment or decrement would overflow")
+	default:
+		c.wr.err("ERR value is not an integer or out of range")
+	}
+}
+
+func incrByFloat(s *Server, c *conn, args [][]byte) {
+
+	f, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil {
+		c.wr.err("ERR value is not a valid float")
+		return
+	}
+
+	v, err := c.e.IncrByFloat(string(args[1]), f, 0)
+	switch err {
+	case nil:
+		c.wr.bulkString(strconv.FormatFloat(v, 'f', -1, 64))
+	case engine.ErrOverflow:
+		c.wr.err("ERR increment would produce NaN or Infinity")
+	default:
+		c.wr.err("ERR value is not a valid float")
+	}
+}
+
+// expire deletes key right away given a non-positive TTL, like Redis does.
+func expire(s *Server, c *conn, args [][]byte) {
+
+	n, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		c.wr.err("ERR value is not an integer or out of range")
+		return
+	}
+
+	key := string(args[1])
+	var ok bool
+	if n <= 0 {
+		ok = c.e.Invalidate(key) == 1
+	} else {
+		ok = c.e.Expire(key, time.Duration(n)*time.Second)
+	}
+	c.wr.int(boolInt(ok))
+}
+
+// ttl replies -2 if key is not in the cache, -1 if it has no TTL. It serves
+// PTTL as well, in milliseconds.
+func ttl(s *Server, c *conn, args [][]byte) {
+
+	unit := time.Second
+	if strings.ToLower(string(args[0])) == "pttl" {
+		unit = time.Millisecond
+	}
+
+	key := string(args[1])
+	d, ok := c.e.TTL(key)
+	switch {
+	case ok:
+		c.wr.int(int64(math.Max(0, math.Round(float64(d)/float64(unit)))))
+	case c.e.Has(key):
+		c.wr.int(-1)
+	default:
+		c.wr.int(-2)
+	}
+}
+
+func persist(s *Server, c *conn, args [][]byte) {
+	c.wr.int(boolInt(c.e.Persist(string(args[1]))))
+}
+
+// scan takes the number of keys already returned as cursor. Keys are sorted,
+// so a SCAN iteration misses keys inserted before the cursor and returns keys
+// twice if keys before the cursor are deleted meanwhile.
+func scan(s *Server, c *conn, args [][]byte) {
+
+	cursor, err := strconv.Atoi(string(args[1]))
+	if err != nil || cursor < 0 {
+		c.wr.err("ERR invalid cursor")
+		return
+	}
+
+	pattern, count := "*", 10
+	for i := 2; i < len(args); i += 2 {
+
+		if i+1 == len(args) {
+			c.wr.err("ERR syntax error")
+			return
+		}
+
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = string(args[i+1])
+		case "count":
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil || count < 1 {
+				c.wr.err("ERR syntax error")
+				return
+			}
+		default:
+			c.wr.err("ERR syntax error")
+			return
+		}
+	}
+
+	prefix, exact, ok := parsePattern(pattern)
+	if !ok {
+		c.wr.err("ERR only prefix* patterns are supported")
+		return
+	}
+
+	ks := c.e.KeysByPrefix(prefix, cursor, count)
+	next := 0
+	if len(ks) == count {
+		next = cursor + count
+	}
+
+	c.wr.array(2)
+	c.wr.bulkString(strconv.Itoa(next))
+	writeKeys(c, ks, prefix, exact)
+}
+
+func keys(s *Server, c *conn, args [][]byte) {
+
+	prefix, exact, ok := parsePattern(string(args[1]))
+	if !ok {
+		c.wr.err("ERR only prefix* patterns are supported")
+		return
+	}
+	writeKeys(c, c.e.KeysByPrefix(prefix, 0, 0), prefix, exact)
+}
+
+// writeKeys writes ks as an array, only keeping pattern itself if exact.
+func writeKeys(c *conn, ks []string, pattern string, exact bool) {
+
+	if exact {
+		var match []string
+		for _, k := range ks {
+			if k == pattern {
+				match = append(match, k)
+			}
+		}
+		ks = match
+	}
+
+	c.wr.array(len(ks))
+	for _, k := range ks {
+		c.wr.bulkString(k)
+	}
+}
+
+// parsePattern supports glob patterns made of a prefix followed by a single
+// trailing "*", and patterns without any special character, which match
+// exactly.
+func parsePattern(p string) (prefix string, exact, ok bool) {
+
+	const special = `*?[\`
+	if strings.HasSuffix(p, "*") && !strings.ContainsAny(p[:len(p)-1], special) {
+		return p[:len(p)-1], false, true
+	}
+	if !strings.ContainsAny(p, special) {
+		return p, true, true
+	}
+	return "", false, false
+}
+
+// info replies with the server, stats, memory and keyspace sections, or only
+// the one asked for.
+func info(s *Server, c *conn, args [][]byte) {
+
+	section := "all"
+	if len(args) > 1 {
+		section = strings.ToLower(string(args[1]))
+	}
+	all := section == "all" || section == "default" || section == "everything"
+
+	st := c.e.Stats()
+	var b strings.Builder
+
+	if all || section == "server" {
+		b.WriteString("# Server\r\n")
+		fmt.Fprintf(&b, "server_name:prefixed\r\n")
+		fmt.Fprintf(&b, "resp_proto:%d\r\n\r\n", c.wr.proto)
+	}
+
+	if all || section == "stats" {
+		b.WriteString("# Stats\r\n")
+		fmt.Fprintf(&b, "keyspace_hits:%d\r\n", st.Hits)
+		fmt.Fprintf(&b, "keyspace_misses:%d\r\n", st.Misses)
+		fmt.Fprintf(&b, "evicted_keys:%d\r\n", st.Evictions)
+		fmt.Fprintf(&b, "expired_keys:%d\r\n", st.Expirations)
+		fmt.Fprintf(&b, "cache_fills:%d\r\n", st.Fills)
+		fmt.Fprintf(&b, "cache_fill_errors:%d\r\n", st.FillErrors)
+		fmt.Fprintf(&b, "cache_fills_in_flight:%d\r\n\r\n", st.InFlightFills)
+	}
+
+	if all || section == "memory" {
+		b.WriteString("# Memory\r\n")
+		fmt.Fprintf(&b, "used_memory_payload:%d\r\n", st.PayloadSize)
+		fmt.Fprintf(&b, "maxmemory:%d\r\n\r\n", st.MaxPayloadTotalSize)
+	}
+
+	if all || section == "keyspace" {
+		b.WriteString("# Keyspace\r\n")
+		if st.Len > 0 {
+			fmt.Fprintf(&b, "db0:keys=%d,expires=%d\r\n", st.Len, st.TTLCount)
+		}
+	}
+
+	c.wr.bulkString(b.String())
+}
+
+func stringArgs(args [][]byte) []string {
+	ss := make([]string, len(args))
+	for i, a := range args {
+		ss[i] = string(a)
+	}
+	return ss
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
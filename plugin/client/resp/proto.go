@@ -0,0 +1,188 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+const (
+	maxArrayLen = 1024 * 1024
+	maxBulkLen  = 64 * 1024 * 1024 // also capped by the engine's payload limit
+	maxInline   = 64 * 1024
+
+	// arguments are read in chunks of up to bulkChunk bytes so that memory
+	// grows with the data actually sent rather than with announced lengths
+	bulkChunk = 64 * 1024
+)
+
+var errProtocol = errors.New("protocol error")
+
+// reader reads commands sent by clients, either as RESP arrays of bulk strings
+// or as inline commands, i.e. space separated words on a line.
+type reader struct {
+	r       *bufio.Reader
+	maxBulk int
+}
+
+func (rd *reader) readCommand() ([][]byte, error) {
+
+	b, err := rd.r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if b[0] != '*' {
+		line, err := rd.readLine()
+		if err != nil {
+			return nil, err
+		}
+		return bytes.Fields(line), nil
+	}
+
+	line, err := rd.readLine()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > maxArrayLen {
+		return nil, errProtocol
+	}
+
+	args := make([][]byte, 0, minInt(n, 16))
+	for i := 0; i < n; i++ {
+
+		line, err := rd.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errProtocol
+		}
+		sz, err := strconv.Atoi(string(line[1:]))
+		if err != nil || sz < 0 || sz > rd.maxBulk {
+			return nil, errProtocol
+		}
+
+		arg, err := rd.readBulk(sz)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readBulk reads sz bytes followed by \r\n.
+func (rd *reader) readBulk(sz int) ([]byte, error) {
+
+	arg := make([]byte, 0, minInt(sz, bulkChunk)+2)
+	for len(arg) < sz+2 {
+		n := minInt(sz+2-len(arg), bulkChunk)
+		if cap(arg)-len(arg) < n {
+			grown := make([]byte, len(arg), minInt(2*cap(arg)+n, sz+2))
+			copy(grown, arg)
+			arg = grown
+		}
+		if _, err := io.ReadFull(rd.r, arg[len(arg):len(arg)+n]); err != nil {
+			return nil, err
+		}
+		arg = arg[:len(arg)+n]
+	}
+
+	if arg[sz] != '\r' || arg[sz+1] != '\n' {
+		return nil, errProtocol
+	}
+	return arg[:sz], nil
+}
+
+// readLine returns a line without its trailing \r\n or \n.
+func (rd *reader) readLine() ([]byte, error) {
+
+	var line []byte
+	for {
+		chunk, isPrefix, err := rd.r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxInline {
+			return nil, errProtocol
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+// writer writes replies in RESP2 or RESP3. Errors are sticky in the underlying
+// bufio.Writer and surface upon Flush.
+type writer struct {
+	w     *bufio.Writer
+	proto int // 2 or 3
+}
+
+func (wr *writer) simple(s string) {
+	wr.w.WriteByte('+')
+	wr.w.WriteString(s)
+	wr.w.WriteString("\r\n")
+}
+
+func (wr *writer) err(s string) {
+	wr.w.WriteByte('-')
+	wr.w.WriteString(s)
+	wr.w.WriteString("\r\n")
+}
+
+func (wr *writer) int(n int64) {
+	wr.w.WriteByte(':')
+	wr.w.WriteString(strconv.FormatInt(n, 10))
+	wr.w.WriteString("\r\n")
+}
+
+func (wr *writer) bulk(b []byte) {
+	wr.w.WriteByte('$')
+	wr.w.WriteString(strconv.Itoa(len(b)))
+	wr.w.WriteString("\r\n")
+	wr.w.Write(b)
+	wr.w.WriteString("\r\n")
+}
+
+func (wr *writer) bulkString(s string) {
+	wr.bulk([]byte(s))
+}
+
+func (wr *writer) null() {
+	if wr.proto == 3 {
+		wr.w.WriteString("_\r\n")
+	} else {
+		wr.w.WriteString("$-1\r\n")
+	}
+}
+
+func (wr *writer) array(n int) {
+	wr.w.WriteByte('*')
+	wr.w.WriteString(strconv.Itoa(n))
+	wr.w.WriteString("\r\n")
+}
+
+// mapHeader announces n key value pairs, as a map in RESP3 and as a flat
+// array in RESP2.
+func (wr *writer) mapHeader(n int) {
+	if wr.proto == 3 {
+		wr.w.WriteByte('%')
+		wr.w.WriteString(strconv.Itoa(n))
+		wr.w.WriteString("\r\n")
+	} else {
+		wr.array(2 * n)
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,81 @@
+// Package resp serves an engine over the Redis serialization protocol (RESP2
+// and RESP3), so that existing Redis clients can talk to it. It registers
+// itself as the "resp" frontend of package client.
+package resp
+
+import (
+	"bufio"
+	"net"
+
+	"github.com/wv0m56/prefixed/plugin/client"
+)
+
+func init() {
+	client.Register("resp", func(addr string) (client.ClientPlugin, error) {
+		return NewServer(addr), nil
+	})
+}
+
+// Server serves an engine to RESP clients. Connections start in RESP2 and
+// switch to RESP3 upon HELLO 3. Arguments longer than the engine's
+// MaxPayloadTotalSize, or than 64MB, are protocol errors. It implements
+// client.ClientPlugin.
+type Server struct {
+	*client.TCPServer
+}
+
+// NewServer returns a Server listening on the TCP address addr once served.
+func NewServer(addr string) *Server {
+	s := &Server{}
+	s.TCPServer = client.NewTCPServer(addr, s.serveConn)
+	return s
+}
+
+// bulkLimit returns the longest argument accepted when serving eng.
+func bulkLimit(eng client.EngineAPI) int {
+	if m := eng.Stats().MaxPayloadTotalSize; m < int64(maxBulkLen) {
+		return int(m)
+	}
+	return maxBulkLen
+}
+
+// conn is the state of a client connection.
+type conn struct {
+	e    client.EngineAPI
+	rd   *reader
+	wr   *writer
+	quit bool
+}
+
+func (s *Server) serveConn(nc net.Conn, eng client.EngineAPI) {
+
+	c := &conn{
+		eng,
+		&reader{bufio.NewReader(nc), bulkLimit(eng)},
+		&writer{bufio.NewWriter(nc), 2},
+		false,
+	}
+
+	for !c.quit {
+
+		args, err := c.rd.readCommand()
+		if err == errProtocol {
+			c.wr.err("ERR Protocol error")
+			c.wr.w.Flush()
+			return
+		} else if err != nil {
+			return
+		}
+
+		if len(args) > 0 {
+			s.dispatch(c, args)
+		}
+
+		// pipelined commands are replied to in one go
+		if c.rd.r.Buffered() == 0 || c.quit {
+			if err := c.wr.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
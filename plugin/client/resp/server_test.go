@@ -0,0 +1,350 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/engine"
+	"github.com/wv0m56/prefixed/plugin/client"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+// testClient is a raw RESP client decoding replies into strings, int64s, nils,
+// []interface{}, map[string]interface{} and errors.
+type testClient struct {
+	t  *testing.T
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+func (c *testClient) do(args ...string) interface{} {
+	c.send(args...)
+	return c.reply()
+}
+
+func (c *testClient) send(args ...string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	_, err := io.WriteString(c.nc, b.String())
+	assert.Nil(c.t, err)
+}
+
+func (c *testClient) reply() interface{} {
+
+	line, err := c.r.ReadString('\n')
+	if !assert.Nil(c.t, err) {
+		return nil
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	body := line[1:]
+
+	switch line[0] {
+	case '+':
+		return body
+	case '-':
+		return fmt.Errorf("%s", body)
+	case ':':
+		n, _ := strconv.ParseInt(body, 10, 64)
+		return n
+	case '_':
+		return nil
+	case '$':
+		n, _ := strconv.Atoi(body)
+		if n < 0 {
+			return nil
+		}
+		b := make([]byte, n+2)
+		_, err := io.ReadFull(c.r, b)
+		assert.Nil(c.t, err)
+		return string(b[:n])
+	case '*':
+		n, _ := strconv.Atoi(body)
+		a := []interface{}{}
+		for i := 0; i < n; i++ {
+			a = append(a, c.reply())
+		}
+		return a
+	case '%':
+		n, _ := strconv.Atoi(body)
+		m := map[string]interface{}{}
+		for i := 0; i < n; i++ {
+			k := c.reply().(string)
+			m[k] = c.reply()
+		}
+		return m
+	}
+	c.t.Fatalf("unexpected reply %q", line)
+	return nil
+}
+
+func newTestServer(t *testing.T) (*Server, *engine.Engine, string) {
+
+	opts := engine.OptionsDefault
+	opts.O = &fake.NoDelayOrigin{}
+	e, err := engine.NewEngine(&opts)
+	assert.Nil(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	s := NewServer("")
+	go s.ServeListener(context.Background(), e, ln)
+	return s, e, ln.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	nc, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	return &testClient{t, nc, bufio.NewReader(nc)}
+}
+
+func TestCommands(t *testing.T) {
+
+	s, e, addr := newTestServer(t)
+	defer s.Close()
+	c := dial(t, addr)
+
+	assert.Equal(t, "PONG", c.do("PING"))
+	assert.Equal(t, "hi", c.do("ping", "hi"))
+	assert.Equal(t, []interface{}{}, c.do("COMMAND", "DOCS"))
+	assert.Equal(t, "OK", c.do("SELECT", "0"))
+	assert.Error(t, c.do("SELECT", "1").(error))
+	assert.Equal(t, "ERR unknown command 'NOPE'", c.do("NOPE").(error).Error())
+	assert.Equal(t, "ERR wrong number of arguments for 'get' command", c.do("GET").(error).Error())
+
+	// GET fills from origin
+	assert.Equal(t, "user:1", c.do("GET", "user:1"))
+	assert.Error(t, c.do("GET", "bench error").(error))
+	assert.Equal(t, []interface{}{"user:1", nil, "user:2"}, c.do("MGET", "user:1", "bench error", "user:2"))
+
+	assert.Equal(t, "OK", c.do("SET", "user:3", "three"))
+	assert.Equal(t, "OK", c.do("SET", "user:4", "four", "EX", "100"))
+	assert.Equal(t, "OK", c.do("SET", "user:5", "five", "px", "100000"))
+	assert.Error(t, c.do("SET", "k", "v", "EX").(error))
+	assert.Error(t, c.do("SET", "k", "v", "EX", "0").(error))
+	assert.Error(t, c.do("SET", "k", "v", "NX").(error))
+	assert.Equal(t, "three", c.do("GET", "user:3"))
+
+	assert.Equal(t, int64(100), c.do("TTL", "user:4"))
+	assert.Equal(t, int64(100), c.do("TTL", "user:5"))
+	assert.Equal(t, int64(-1), c.do("TTL", "user:3"))
+	assert.InDelta(t, 100000, c.do("PTTL", "user:4"), 50) // wall clock
+	assert.Equal(t, int64(-2), c.do("PTTL", "nobody"))
+	assert.Equal(t, int64(-2), c.do("TTL", "nobody"))
+	assert.Equal(t, int64(1), c.do("EXPIRE", "user:3", "50"))
+	assert.Equal(t, int64(50), c.do("TTL", "user:3"))
+	assert.Equal(t, int64(0), c.do("EXPIRE", "nobody", "50"))
+	assert.Equal(t, int64(1), c.do("PERSIST", "user:3"))
+	assert.Equal(t, int64(0), c.do("PERSIST", "user:3"))
+	assert.Equal(t, int64(-1), c.do("TTL", "user:3"))
+
+	assert.Equal(t, []interface{}{"user:1", "user:2", "user:3", "user:4", "user:5"}, c.do("KEYS", "user:*"))
+	assert.Equal(t, []interface{}{"user:2"}, c.do("KEYS", "user:2"))
+	assert.Error(t, c.do("KEYS", "user:?").(error))
+
+	assert.Equal(t, int64(2), c.do("DEL", "user:1", "user:2", "nobody"))
+	assert.Equal(t, int64(1), c.do("EXPIRE", "user:5", "-1"))
+	assert.False(t, e.Has("user:5"))
+
+	assert.Equal(t, int64(1), c.do("INCR", "n"))
+	assert.Equal(t, int64(11), c.do("INCRBY", "n", "10"))
+	assert.Equal(t, int64(10), c.do("DECR", "n"))
+	assert.Equal(t, int64(-5), c.do("DECRBY", "n", "15"))
+	assert.Equal(t, "-4.5", c.do("INCRBYFLOAT", "n", "0.5"))
+	assert.Error(t, c.do("INCR", "n").(error))
+	assert.Error(t, c.do("INCR", "user:3").(error))
+	assert.Error(t, c.do("INCRBY", "n", "x").(error))
+	assert.Error(t, c.do("DECRBY", "m", "-9223372036854775808").(error))
+	assert.Error(t, c.do("INCRBYFLOAT", "user:3", "1").(error))
+	assert.Error(t, c.do("INCRBYFLOAT", "n", "inf").(error))
+	assert.Equal(t, "-4.5", c.do("GET", "n"))
+
+	assert.Equal(t, "OK", c.do("QUIT"))
+	_, err := c.r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestScan(t *testing.T) {
+
+	s, e, addr := newTestServer(t)
+	defer s.Close()
+	c := dial(t, addr)
+
+	for i := 0; i < 25; i++ {
+		e.Set(fmt.Sprintf("a:%02d", i), nil)
+		e.Set(fmt.Sprintf("b:%02d", i), nil)
+	}
+
+	var all []interface{}
+	cursor := "0"
+	for {
+		r := c.do("SCAN", cursor, "MATCH", "a:*", "COUNT", "10").([]interface{})
+		all = append(all, r[1].([]interface{})...)
+		if cursor = r[0].(string); cursor == "0" {
+			break
+		}
+	}
+	assert.Equal(t, 25, len(all))
+	assert.Equal(t, "a:00", all[0])
+	assert.Equal(t, "a:24", all[24])
+
+	r := c.do("SCAN", "0").([]interface{})
+	assert.Equal(t, "10", r[0])
+	assert.Equal(t, 10, len(r[1].([]interface{})))
+
+	assert.Error(t, c.do("SCAN", "x").(error))
+	assert.Error(t, c.do("SCAN", "0", "MATCH").(error))
+	assert.Error(t, c.do("SCAN", "0", "MATCH", "*a").(error))
+}
+
+func TestProtocols(t *testing.T) {
+
+	s, e, addr := newTestServer(t)
+	defer s.Close()
+	c := dial(t, addr)
+
+	// inline commands and pipelining
+	_, err := io.WriteString(c.nc, "PING\r\nSET k v\r\nGET k\r\n")
+	assert.Nil(t, err)
+	assert.Equal(t, "PONG", c.reply())
+	assert.Equal(t, "OK", c.reply())
+	assert.Equal(t, "v", c.reply())
+
+	// RESP2 null
+	e.Set("x", nil)
+	c.send("MGET", "x", "bench error")
+	assert.Equal(t, []interface{}{"", nil}, c.reply())
+
+	// RESP3
+	assert.Error(t, c.do("HELLO", "4").(error))
+	h := c.do("HELLO", "3").(map[string]interface{})
+	assert.Equal(t, int64(3), h["proto"])
+	_, err = io.WriteString(c.nc, "*3\r\n$4\r\nMGET\r\n$1\r\nx\r\n$11\r\nbench error\r\n")
+	assert.Nil(t, err)
+	line, _ := c.r.ReadString('\n')
+	assert.Equal(t, "*2\r\n", line)
+	assert.Equal(t, "", c.reply())
+	line, _ = c.r.ReadString('\n')
+	assert.Equal(t, "_\r\n", line)
+
+	info := c.do("INFO").(string)
+	assert.Contains(t, info, "# Stats\r\n")
+	assert.Contains(t, info, "db0:keys=2,expires=0\r\n")
+	assert.Contains(t, info, "resp_proto:3\r\n")
+	assert.NotContains(t, c.do("INFO", "memory").(string), "# Stats")
+
+	// protocol errors close the connection
+	_, err = io.WriteString(c.nc, "*1\r\n+PING\r\n")
+	assert.Nil(t, err)
+	assert.Equal(t, "ERR Protocol error", c.reply().(error).Error())
+	_, err = c.r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestBulkLimits(t *testing.T) {
+
+	s, _, addr := newTestServer(t)
+	defer s.Close()
+	c := dial(t, addr)
+
+	// read in chunks
+	big := strings.Repeat("x", 3*bulkChunk+7)
+	assert.Equal(t, "OK", c.do("SET", "big", big))
+	assert.Equal(t, big, c.do("GET", "big"))
+
+	// rejected upon the announced length, before any data
+	_, err := io.WriteString(c.nc, fmt.Sprintf("*2\r\n$3\r\nGET\r\n$%d\r\n", maxBulkLen+1))
+	assert.Nil(t, err)
+	assert.Equal(t, "ERR Protocol error", c.reply().(error).Error())
+
+	// capped by the payload limit of the engine
+	opts := engine.OptionsDefault
+	opts.MaxPayloadTotalSize = 10 * 1000 * 1000
+	e, err := engine.NewEngine(&opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 10*1000*1000, bulkLimit(e))
+}
+
+func TestClose(t *testing.T) {
+
+	s, _, addr := newTestServer(t)
+	c := dial(t, addr)
+	assert.Equal(t, "PONG", c.do("PING"))
+
+	done := make(chan struct{})
+	go func() {
+		s.Close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked")
+	}
+
+	_, err := c.r.ReadByte()
+	assert.NotNil(t, err)
+	_, err = net.Dial("tcp", addr)
+	assert.NotNil(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	assert.Nil(t, s.ServeListener(context.Background(), nil, ln))
+}
+
+func TestShutdown(t *testing.T) {
+
+	s, _, addr := newTestServer(t)
+	c := dial(t, addr)
+	assert.Equal(t, "PONG", c.do("PING"))
+
+	// pipelined commands received before Shutdown are still replied to
+	_, err := io.WriteString(c.nc, "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\nb\r\n*2\r\n$3\r\nGET\r\n$1\r\na\r\n")
+	assert.Nil(t, err)
+	assert.Equal(t, "OK", c.reply())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, s.Shutdown(ctx))
+
+	assert.Equal(t, "b", c.reply())
+	_, err = c.r.ReadByte()
+	assert.NotNil(t, err)
+	_, err = net.Dial("tcp", addr)
+	assert.NotNil(t, err)
+}
+
+func TestServeContext(t *testing.T) {
+
+	opts := engine.OptionsDefault
+	opts.O = &fake.NoDelayOrigin{}
+	e, err := engine.NewEngine(&opts)
+	assert.Nil(t, err)
+
+	p, err := client.New("resp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() { errc <- p.Serve(ctx, e) }()
+
+	cancel()
+	select {
+	case err := <-errc:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Serve didn't return")
+	}
+}
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnHandler serves eng to the client of nc until the client leaves or
+// reading from nc fails. Upon TCPServer.Shutdown, reads fail once the input
+// already buffered by the handler is consumed, so that pending commands are
+// still replied to. nc is closed after the handler returns.
+type ConnHandler func(nc net.Conn, eng EngineAPI)
+
+// TCPServer is the accept loop and connection tracking of frontends serving
+// one connection per goroutine, e.g. RESP or memcached. It implements
+// ClientPlugin, and is meant to be embedded.
+type TCPServer struct {
+	addr   string
+	handle ConnHandler
+
+	mu     sync.Mutex
+	lns    map[net.Listener]struct{}
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewTCPServer returns a TCPServer listening on the TCP address addr once
+// served, handing each connection to h.
+func NewTCPServer(addr string, h ConnHandler) *TCPServer {
+	return &TCPServer{
+		addr:   addr,
+		handle: h,
+		lns:    map[net.Listener]struct{}{},
+		conns:  map[net.Conn]struct{}{},
+	}
+}
+
+// Serve listens on the address given to NewTCPServer and calls ServeListener.
+func (s *TCPServer) Serve(ctx context.Context, eng EngineAPI) error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeListener(ctx, eng, ln)
+}
+
+// ServeListener accepts connections on ln, serving eng to each of them in its
+// own goroutine, until ln fails, ctx is done, or Shutdown or Close is called,
+// in which cases it returns nil, including when called afterwards. It closes
+// ln before returning.
+func (s *TCPServer) ServeListener(ctx context.Context, eng EngineAPI, ln net.Listener) error {
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return nil
+	}
+	s.lns[ln] = struct{}{}
+	s.mu.Unlock()
+
+	stop := make(chan struct{})
+	defer func() {
+		close(stop)
+		s.mu.Lock()
+		delete(s.lns, ln)
+		s.mu.Unlock()
+		ln.Close()
+	}()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-stop:
+		}
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn, eng)
+	}
+}
+
+func (s *TCPServer) serveConn(nc net.Conn, eng EngineAPI) {
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, nc)
+		s.mu.Unlock()
+		nc.Close()
+		s.wg.Done()
+	}()
+
+	s.handle(nc, eng)
+}
+
+// Shutdown stops all listeners and lets connections finish the commands they
+// already received, closing them afterwards. Connections remaining once ctx
+// is done are closed right away.
+func (s *TCPServer) Shutdown(ctx context.Context) error {
+
+	s.mu.Lock()
+	s.closed = true
+	for ln := range s.lns {
+		ln.Close()
+	}
+	for c := range s.conns {
+		// buffered commands are still served, see ConnHandler
+		c.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+// Close stops all listeners, closes all connections and waits for their
+// goroutines to return.
+func (s *TCPServer) Close() error {
+
+	s.mu.Lock()
+	s.closed = true
+	for ln := range s.lns {
+		ln.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+var _ ClientPlugin = (*TCPServer)(nil)
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// echo replies to each line until reading fails.
+func echo(nc net.Conn, _ EngineAPI) {
+	r := bufio.NewReader(nc)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		nc.Write([]byte(line))
+	}
+}
+
+func TestTCPServer(t *testing.T) {
+
+	s := NewTCPServer("127.0.0.1:0", echo)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	errc := make(chan error, 1)
+	go func() { errc <- s.ServeListener(context.Background(), nil, ln) }()
+
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer nc.Close()
+	_, err = nc.Write([]byte("hi\n"))
+	assert.Nil(t, err)
+	line, err := bufio.NewReader(nc).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "hi\n", line)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, s.Shutdown(ctx))
+	assert.Nil(t, <-errc)
+
+	// Serve after Shutdown returns right away
+	assert.Nil(t, s.Serve(context.Background(), nil))
+}
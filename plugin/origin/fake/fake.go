@@ -116,7 +116,7 @@ func (_ *zeroesPayloadReadCloser) Close() error {
 }
 
 func (tbrc *zeroesPayloadReadCloser) Read(p []byte) (int, error) {
-	return tbrc.Read(p)
+	return tbrc.Reader.Read(p)
 }
 
 // An origin which returns data with random expiry and random
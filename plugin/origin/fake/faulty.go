@@ -0,0 +1,166 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/wv0m56/prefixed/plugin/origin"
+)
+
+// FaultyOrigin wraps O (NoDelayOrigin if nil) and injects faults for chaos
+// testing the cache fill path. Every rate is a probability in [0, 1].
+// NilRate, ErrorRate and PartialRate are mutually exclusive and evaluated in
+// that order from a single draw, so their sum should not exceed 1.
+// CloseErrorRate and PastExpiryRate are drawn independently.
+// A FaultyOrigin must not be copied after first use.
+type FaultyOrigin struct {
+	O origin.Origin
+
+	// NilRate is the rate at which Fetch returns a nil ReadCloser.
+	NilRate float64
+
+	// ErrorRate is the rate at which the first Read fails.
+	ErrorRate float64
+
+	// PartialRate is the rate at which the stream fails after yielding half
+	// of the payload.
+	PartialRate float64
+
+	// CloseErrorRate is the rate at which Close returns an error.
+	CloseErrorRate float64
+
+	// PastExpiryRate is the rate at which the returned expiry is one hour in
+	// the past.
+	PastExpiryRate float64
+
+	// Latency, if not nil, is called on every Fetch to draw the delay before
+	// the payload is available. A delay longer than the fetch timeout makes
+	// the stream fail with context.DeadlineExceeded.
+	Latency func() time.Duration
+
+	// Seed seeds the random source on first use. Equal seeds give equal
+	// sequences of faults for equal sequences of calls.
+	Seed int64
+
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (fo *FaultyOrigin) Fetch(key string, timeout time.Duration) (io.ReadCloser, *time.Time) {
+
+	fo.mu.Lock()
+	if fo.r == nil {
+		fo.r = rand.New(rand.NewSource(fo.Seed))
+	}
+	draw := fo.r.Float64()
+	closeErr := fo.r.Float64() < fo.CloseErrorRate
+	pastExpiry := fo.r.Float64() < fo.PastExpiryRate
+	fo.mu.Unlock()
+
+	if fo.Latency != nil {
+		d := fo.Latency()
+		if d > timeout {
+			time.Sleep(timeout)
+			return &faultyReadCloser{partialReadCloser{nil, context.DeadlineExceeded}, closeErr}, nil
+		}
+		time.Sleep(d)
+	}
+
+	o := fo.O
+	if o == nil {
+		o = &NoDelayOrigin{}
+	}
+	rc, exp := o.Fetch(key, timeout)
+
+	if pastExpiry {
+		t := time.Now().Add(-time.Hour)
+		exp = &t
+	}
+
+	switch {
+
+	case draw < fo.NilRate:
+		if rc != nil {
+			_ = rc.Close()
+		}
+		return nil, exp
+
+	case draw < fo.NilRate+fo.ErrorRate:
+		if rc != nil {
+			_ = rc.Close()
+		}
+		return &faultyReadCloser{partialReadCloser{nil, errors.New("fake injected error")}, closeErr}, exp
+
+	case draw < fo.NilRate+fo.ErrorRate+fo.PartialRate:
+		if rc == nil {
+			return nil, exp
+		}
+		b, err := ioutil.ReadAll(rc)
+		_ = rc.Close()
+		if err == nil {
+			err = errors.New("fake injected partial read")
+		}
+		return &faultyReadCloser{partialReadCloser{b[:len(b)/2], err}, closeErr}, exp
+	}
+
+	if rc == nil || !closeErr {
+		return rc, exp
+	}
+	return &closeErrReadCloser{rc}, exp
+}
+
+// faultyReadCloser is a partialReadCloser optionally failing on Close.
+type faultyReadCloser struct {
+	partialReadCloser
+	closeErr bool
+}
+
+func (frc *faultyReadCloser) Close() error {
+	if frc.closeErr {
+		return errors.New("fake injected close error")
+	}
+	return nil
+}
+
+type closeErrReadCloser struct {
+	io.ReadCloser
+}
+
+func (cerc *closeErrReadCloser) Close() error {
+	_ = cerc.ReadCloser.Close()
+	return errors.New("fake injected close error")
+}
+
+// UniformLatency returns a latency distribution for FaultyOrigin drawing
+// uniformly from [min, max), or always min if max <= min. It is safe for
+// concurrent use.
+func UniformLatency(min, max time.Duration) func() time.Duration {
+	if max <= min {
+		return func() time.Duration { return min }
+	}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	mu := sync.Mutex{}
+	return func() time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		return min + time.Duration(r.Int63n(int64(max-min)))
+	}
+}
+
+// ExponentialLatency returns a latency distribution for FaultyOrigin drawing
+// from an exponential distribution with the given mean, which produces the
+// long tail typical of network fetches. It is safe for concurrent use.
+func ExponentialLatency(mean time.Duration) func() time.Duration {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	mu := sync.Mutex{}
+	return func() time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		return time.Duration(r.ExpFloat64() * float64(mean))
+	}
+}
@@ -0,0 +1,21 @@
+package fake
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUniformLatency(t *testing.T) {
+
+	l := UniformLatency(time.Millisecond, 3*time.Millisecond)
+	for i := 0; i < 100; i++ {
+		d := l()
+		assert.True(t, d >= time.Millisecond && d < 3*time.Millisecond)
+	}
+
+	// degenerate ranges don't panic
+	assert.Equal(t, time.Millisecond, UniformLatency(time.Millisecond, time.Millisecond)())
+	assert.Equal(t, 2*time.Millisecond, UniformLatency(2*time.Millisecond, time.Millisecond)())
+}
@@ -0,0 +1,184 @@
+package fake
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/wv0m56/prefixed/plugin/origin"
+)
+
+// Recording is a single fetch captured by a Recorder, one JSON object per line
+// in the recording file. TTL is relative to the start of the fetch so that
+// replays produce expiries in the future. Val holds whatever was read before
+// Err (if any) occurred.
+type Recording struct {
+	Key     string         `json:"key"`
+	Val     []byte         `json:"val,omitempty"`
+	TTL     *time.Duration `json:"ttl,omitempty"`
+	Latency time.Duration  `json:"latency"`
+	Err     string         `json:"err,omitempty"`
+	NilRC   bool           `json:"nil_rc,omitempty"`
+}
+
+// Recorder wraps an Origin and writes every fetch going through it to w as a
+// Recording. The stream returned by the wrapped origin is drained inside
+// Fetch so that its latency and outcome can be recorded.
+type Recorder struct {
+	o   origin.Origin
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+func NewRecorder(o origin.Origin, w io.Writer) *Recorder {
+	return &Recorder{o: o, enc: json.NewEncoder(w)}
+}
+
+func (r *Recorder) Fetch(key string, timeout time.Duration) (io.ReadCloser, *time.Time) {
+
+	start := time.Now()
+	rc, exp := r.o.Fetch(key, timeout)
+
+	rec := &Recording{Key: key}
+	if exp != nil {
+		ttl := exp.Sub(start)
+		rec.TTL = &ttl
+	}
+
+	var err error
+	if rc == nil {
+		rec.NilRC = true
+	} else {
+		rec.Val, err = ioutil.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			rec.Err = err.Error()
+		}
+	}
+	rec.Latency = time.Since(start)
+
+	r.mu.Lock()
+	if r.err == nil {
+		r.err = r.enc.Encode(rec)
+	}
+	r.mu.Unlock()
+
+	if rc == nil {
+		return nil, exp
+	}
+	return &partialReadCloser{rec.Val, err}, exp
+}
+
+// Err returns the first error encountered while writing recordings.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Replayer serves recordings written by a Recorder back deterministically.
+// Fetches of the same key replay that key's recordings in the order they were
+// recorded, the last one being repeated once all have been served. Fetching a
+// key absent from the recordings returns a stream which fails on Read.
+type Replayer struct {
+	mu          sync.Mutex
+	recs        map[string][]*Recording
+	withLatency bool
+}
+
+// NewReplayer reads all recordings from r. If withLatency is true, Fetch
+// sleeps for the recorded latency, failing with context.DeadlineExceeded if it
+// is longer than the fetch timeout.
+func NewReplayer(r io.Reader, withLatency bool) (*Replayer, error) {
+
+	rp := &Replayer{recs: map[string][]*Recording{}, withLatency: withLatency}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 64*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		rec := &Recording{}
+		if err := json.Unmarshal(sc.Bytes(), rec); err != nil {
+			return nil, fmt.Errorf("recording line %d: %v", line, err)
+		}
+		rp.recs[rec.Key] = append(rp.recs[rec.Key], rec)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return rp, nil
+}
+
+func (rp *Replayer) Fetch(key string, timeout time.Duration) (io.ReadCloser, *time.Time) {
+
+	rp.mu.Lock()
+	recs := rp.recs[key]
+	var rec *Recording
+	if len(recs) > 0 {
+		rec = recs[0]
+		if len(recs) > 1 {
+			rp.recs[key] = recs[1:]
+		}
+	}
+	rp.mu.Unlock()
+
+	if rec == nil {
+		return &partialReadCloser{nil, errors.New("no recording for key " + key)}, nil
+	}
+
+	start := time.Now()
+	if rp.withLatency {
+		if rec.Latency > timeout {
+			time.Sleep(timeout)
+			return &partialReadCloser{nil, context.DeadlineExceeded}, nil
+		}
+		time.Sleep(rec.Latency)
+	}
+
+	var exp *time.Time
+	if rec.TTL != nil {
+		t := start.Add(*rec.TTL)
+		exp = &t
+	}
+
+	if rec.NilRC {
+		return nil, exp
+	}
+
+	var err error
+	if rec.Err != "" {
+		err = errors.New(rec.Err)
+	}
+	return &partialReadCloser{rec.Val, err}, exp
+}
+
+// partialReadCloser yields b, then fails with err. A nil err means io.EOF.
+type partialReadCloser struct {
+	b   []byte
+	err error
+}
+
+func (prc *partialReadCloser) Read(p []byte) (int, error) {
+	if len(prc.b) == 0 {
+		if prc.err != nil {
+			return 0, prc.err
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, prc.b)
+	prc.b = prc.b[n:]
+	return n, nil
+}
+
+func (_ *partialReadCloser) Close() error {
+	return nil
+}
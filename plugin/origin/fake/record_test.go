@@ -0,0 +1,75 @@
+package fake
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/plugin/origin"
+)
+
+func TestRecordReplay(t *testing.T) {
+
+	buf := bytes.NewBuffer(nil)
+	rec := NewRecorder(&ExpiringOrigin{}, buf)
+	b, exp, err := fetch(rec, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", string(b))
+	assert.NotNil(t, exp)
+
+	rec = NewRecorder(&NoDelayOrigin{}, buf)
+	fetch(rec, "b")
+	_, _, err = fetch(rec, "bench error")
+	assert.NotNil(t, err)
+	assert.Nil(t, rec.Err())
+
+	rec = NewRecorder(&DelayedOrigin{}, buf)
+	fetch(rec, "b")
+
+	rp, err := NewReplayer(bytes.NewReader(buf.Bytes()), false)
+	assert.Nil(t, err)
+
+	b, exp, err = fetch(rp, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", string(b))
+	assert.True(t, exp.After(time.Now().Add(23*time.Hour)))
+
+	_, _, err = fetch(rp, "bench error")
+	assert.Equal(t, "fake bench error", err.Error())
+
+	_, _, err = fetch(rp, "never recorded")
+	assert.NotNil(t, err)
+
+	// replay without latency is instant, second fetch of "b" replays the
+	// DelayedOrigin recording, a third one repeats it
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		b, exp, err = fetch(rp, "b")
+		assert.Nil(t, err)
+		assert.Nil(t, exp)
+		assert.Equal(t, "b", string(b))
+	}
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+
+	// with latency
+	rp, err = NewReplayer(bytes.NewReader(buf.Bytes()), true)
+	assert.Nil(t, err)
+	fetch(rp, "b")
+	start = time.Now()
+	_, _, err = fetch(rp, "b")
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	rc, _ := rp.Fetch("b", 10*time.Millisecond)
+	_, err = ioutil.ReadAll(rc)
+	assert.Equal(t, "context deadline exceeded", err.Error())
+}
+
+func fetch(o origin.Origin, key string) ([]byte, *time.Time, error) {
+	rc, exp := o.Fetch(key, time.Second)
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	return b, exp, err
+}
@@ -11,3 +11,19 @@ import (
 type Origin interface {
 	Fetch(key string, timeout time.Duration) (rc io.ReadCloser, expiry *time.Time)
 }
+
+// Reporter is optionally implemented by an Origin (typically a middleware
+// wrapping another Origin) which keeps state worth exposing to operators.
+// Report returns a flat snapshot of metric names to their current values.
+type Reporter interface {
+	Report() map[string]float64
+}
+
+// MetaOrigin is optionally implemented by an Origin able to describe the data
+// it fetches, e.g. with the content type and headers of an HTTP response. The
+// cache engine then stores them as metadata along the row. Middlewares
+// wrapping a MetaOrigin hide it unless they implement MetaOrigin themselves.
+type MetaOrigin interface {
+	Origin
+	FetchWithMeta(key string, timeout time.Duration) (rc io.ReadCloser, expiry *time.Time, contentType string, headers map[string]string)
+}
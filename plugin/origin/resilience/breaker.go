@@ -0,0 +1,206 @@
+package resilience
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/wv0m56/prefixed/plugin/origin"
+)
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets every fetch through.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen fails every fetch with ErrCircuitOpen.
+	BreakerOpen
+
+	// BreakerHalfOpen lets a single probe fetch through. The circuit closes if
+	// the probe succeeds and opens again if it fails.
+	BreakerHalfOpen
+)
+
+func (bs BreakerState) String() string {
+	switch bs {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker is an Origin middleware which stops calling the wrapped origin
+// after a number of consecutive failures, failing fast with ErrCircuitOpen
+// for a cooldown period before probing the origin again.
+// A fetch succeeds once its stream has been read up to io.EOF and fails on
+// any other read error or on a nil ReadCloser.
+type CircuitBreaker struct {
+	o         origin.Origin
+	threshold int
+	cooldown  time.Duration
+
+	mu           sync.Mutex
+	state        BreakerState
+	failures     int       // consecutive
+	openedAt     time.Time // when the circuit last opened
+	probeExpires time.Time // a probe not reporting back by then is a failure
+	probe        uint64    // id of the current probe, 0 is never a probe
+	trips        uint64
+	rejected     uint64
+}
+
+// NewCircuitBreaker wraps o in a CircuitBreaker which opens after threshold
+// consecutive failures and stays open for cooldown. It panics if threshold < 1
+// or cooldown is not positive.
+func NewCircuitBreaker(o origin.Origin, threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold < 1 {
+		panic("circuit breaker threshold must be >= 1")
+	}
+	if cooldown <= 0 {
+		panic("circuit breaker cooldown must be positive")
+	}
+	return &CircuitBreaker{o: o, threshold: threshold, cooldown: cooldown}
+}
+
+func (cb *CircuitBreaker) Fetch(key string, timeout time.Duration) (io.ReadCloser, *time.Time) {
+
+	probe, ok := cb.allow(timeout)
+	if !ok {
+		return &errReadCloser{ErrCircuitOpen}, nil
+	}
+	done := func(err error) { cb.done(probe, err) }
+
+	rc, exp := cb.o.Fetch(key, timeout)
+	if rc == nil {
+		done(errors.New("nil ReadCloser from Fetch"))
+		return nil, exp
+	}
+	return &observedReadCloser{rc: rc, done: done}, exp
+}
+
+// allow tells whether a fetch may go through, returning the id of the probe if
+// it is one and 0 otherwise.
+func (cb *CircuitBreaker) allow(timeout time.Duration) (uint64, bool) {
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	now := time.Now()
+	switch cb.state {
+
+	case BreakerOpen:
+		if now.Before(cb.openedAt.Add(cb.cooldown)) {
+			cb.rejected++
+			return 0, false
+		}
+		cb.state = BreakerHalfOpen
+		cb.probeExpires = now.Add(timeout)
+		cb.probe++
+		return cb.probe, true
+
+	case BreakerHalfOpen:
+		if now.Before(cb.probeExpires) {
+			cb.rejected++
+			return 0, false
+		}
+		// the previous probe never reported back
+		cb.probeExpires = now.Add(timeout)
+		cb.probe++
+		return cb.probe, true
+	}
+
+	return 0, true
+}
+
+// done records the outcome of a fetch. Only the current probe decides whether
+// a half-open circuit closes, outcomes of fetches started before the circuit
+// opened are ignored until then.
+func (cb *CircuitBreaker) done(probe uint64, err error) {
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch {
+
+	case cb.state == BreakerHalfOpen && probe == cb.probe:
+		if err == nil {
+			cb.state = BreakerClosed
+			cb.failures = 0
+		} else {
+			cb.trip()
+		}
+
+	case cb.state == BreakerClosed:
+		if err == nil {
+			cb.failures = 0
+		} else if cb.failures++; cb.failures >= cb.threshold {
+			cb.trip()
+		}
+	}
+}
+
+// trip opens the circuit. Needs cb.mu.
+func (cb *CircuitBreaker) trip() {
+	cb.state = BreakerOpen
+	cb.openedAt = time.Now()
+	cb.trips++
+}
+
+// State returns the current state of the circuit.
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// Report implements origin.Reporter. breaker_state is 0, 1 or 2 for closed,
+// open and half-open respectively.
+func (cb *CircuitBreaker) Report() map[string]float64 {
+	cb.mu.Lock()
+	own := map[string]float64{
+		"breaker_state":          float64(cb.state),
+		"breaker_failures":       float64(cb.failures),
+		"breaker_trips_total":    float64(cb.trips),
+		"breaker_rejected_total": float64(cb.rejected),
+	}
+	cb.mu.Unlock()
+	return report(cb.o, own)
+}
+
+// observedReadCloser calls done exactly once with the outcome of the stream:
+// nil on io.EOF, the read error otherwise. Closing the stream before either
+// happened counts as a failure.
+type observedReadCloser struct {
+	rc       io.ReadCloser
+	done     func(error)
+	reported bool
+}
+
+func (orc *observedReadCloser) Read(p []byte) (int, error) {
+	n, err := orc.rc.Read(p)
+	if err == io.EOF {
+		orc.report(nil)
+	} else if err != nil {
+		orc.report(err)
+	}
+	return n, err
+}
+
+func (orc *observedReadCloser) Close() error {
+	orc.report(errors.New("stream closed before EOF"))
+	return orc.rc.Close()
+}
+
+func (orc *observedReadCloser) report(err error) {
+	if !orc.reported {
+		orc.reported = true
+		orc.done(err)
+	}
+}
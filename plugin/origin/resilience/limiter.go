@@ -0,0 +1,95 @@
+package resilience
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wv0m56/prefixed/plugin/origin"
+)
+
+// Limiter is an Origin middleware which bounds the number of in-flight
+// fetches. A fetch occupies a slot from the call to Fetch until its stream
+// returns an error (io.EOF included) or is closed. A fetch which cannot get a
+// slot before its timeout elapses fails with ErrTooManyFetches.
+type Limiter struct {
+	o   origin.Origin
+	sem chan struct{}
+
+	rejected uint64 // atomic
+}
+
+// NewLimiter wraps o in a Limiter allowing at most n in-flight fetches.
+// It panics if n < 1.
+func NewLimiter(o origin.Origin, n int) *Limiter {
+	if n < 1 {
+		panic("limiter must allow at least 1 in-flight fetch")
+	}
+	return &Limiter{o: o, sem: make(chan struct{}, n)}
+}
+
+func (l *Limiter) Fetch(key string, timeout time.Duration) (io.ReadCloser, *time.Time) {
+
+	start := time.Now()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+
+	case l.sem <- struct{}{}:
+
+	case <-t.C:
+		atomic.AddUint64(&l.rejected, 1)
+		return &errReadCloser{ErrTooManyFetches}, nil
+	}
+
+	left := timeout - time.Since(start)
+	if left <= 0 {
+		<-l.sem
+		atomic.AddUint64(&l.rejected, 1)
+		return &errReadCloser{ErrTooManyFetches}, nil
+	}
+
+	rc, exp := l.o.Fetch(key, left)
+	if rc == nil {
+		<-l.sem
+		return nil, exp
+	}
+	return &releasingReadCloser{rc: rc, release: func() { <-l.sem }}, exp
+}
+
+// InFlight returns the number of fetches currently holding a slot.
+func (l *Limiter) InFlight() int {
+	return len(l.sem)
+}
+
+// Report implements origin.Reporter.
+func (l *Limiter) Report() map[string]float64 {
+	return report(l.o, map[string]float64{
+		"limiter_in_flight":      float64(len(l.sem)),
+		"limiter_limit":          float64(cap(l.sem)),
+		"limiter_rejected_total": float64(atomic.LoadUint64(&l.rejected)),
+	})
+}
+
+// releasingReadCloser calls release exactly once, as soon as the stream ends
+// or is closed, whichever comes first.
+type releasingReadCloser struct {
+	rc      io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (rrc *releasingReadCloser) Read(p []byte) (int, error) {
+	n, err := rrc.rc.Read(p)
+	if err != nil {
+		rrc.once.Do(rrc.release)
+	}
+	return n, err
+}
+
+func (rrc *releasingReadCloser) Close() error {
+	rrc.once.Do(rrc.release)
+	return rrc.rc.Close()
+}
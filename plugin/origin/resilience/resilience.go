@@ -0,0 +1,68 @@
+// Package resilience implements Origin middlewares which protect the cache
+// engine and its backend from each other: retries with jittered exponential
+// backoff, a circuit breaker and a limit on in-flight fetches. Every
+// middleware wraps an origin.Origin and is itself an origin.Origin, so they
+// can be stacked, e.g. NewRetry(NewCircuitBreaker(NewLimiter(o, 64), 5,
+// time.Second), 3, 10*time.Millisecond, 100*time.Millisecond).
+package resilience
+
+import (
+	"errors"
+	"io/ioutil"
+	"time"
+
+	"github.com/wv0m56/prefixed/plugin/origin"
+)
+
+var (
+	// ErrCircuitOpen is returned (via Read) by a CircuitBreaker which fails
+	// fast because the wrapped origin is deemed unhealthy.
+	ErrCircuitOpen = errors.New("circuit breaker open")
+
+	// ErrTooManyFetches is returned (via Read) by a Limiter which could not
+	// acquire a fetch slot before the fetch timeout elapsed.
+	ErrTooManyFetches = errors.New("too many in-flight fetches")
+)
+
+// errReadCloser fails every Read with err. Origins can only report errors
+// through the returned stream, so this is how middlewares fail a fetch.
+type errReadCloser struct {
+	err error
+}
+
+func (erc *errReadCloser) Read(_ []byte) (int, error) {
+	return 0, erc.err
+}
+
+func (_ *errReadCloser) Close() error {
+	return nil
+}
+
+// fetchAll fetches key from o and drains the returned stream into memory.
+func fetchAll(o origin.Origin, key string, timeout time.Duration) ([]byte, *time.Time, error) {
+
+	rc, exp := o.Fetch(key, timeout)
+	if rc == nil {
+		return nil, nil, errors.New("nil ReadCloser from Fetch")
+	}
+
+	b, err := ioutil.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, exp, nil
+}
+
+// report merges the report of the wrapped origin (if any) into own. Metrics
+// of the outer middleware win on name collision.
+func report(o origin.Origin, own map[string]float64) map[string]float64 {
+	if r, ok := o.(origin.Reporter); ok {
+		for k, v := range r.Report() {
+			if _, dup := own[k]; !dup {
+				own[k] = v
+			}
+		}
+	}
+	return own
+}
@@ -0,0 +1,202 @@
+package resilience
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wv0m56/prefixed/plugin/origin"
+	"github.com/wv0m56/prefixed/plugin/origin/fake"
+)
+
+// flakyOrigin fails the first failN fetches, then serves key as the payload.
+type flakyOrigin struct {
+	mu     sync.Mutex
+	failN  int
+	called int
+}
+
+func (fo *flakyOrigin) Fetch(key string, _ time.Duration) (io.ReadCloser, *time.Time) {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	fo.called++
+	if fo.called <= fo.failN {
+		return &errReadCloser{errors.New("flaky")}, nil
+	}
+	return ioutil.NopCloser(bytes.NewReader([]byte(key))), nil
+}
+
+func (fo *flakyOrigin) calls() int {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	return fo.called
+}
+
+func TestRetry(t *testing.T) {
+
+	fo := &flakyOrigin{failN: 2}
+	r := NewRetry(fo, 3, time.Millisecond, 5*time.Millisecond)
+
+	b, err := readAll(r, "abc", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", string(b))
+	assert.Equal(t, 3, fo.calls())
+
+	fo = &flakyOrigin{failN: 5}
+	r = NewRetry(fo, 3, time.Millisecond, 5*time.Millisecond)
+	_, err = readAll(r, "abc", time.Second)
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, fo.calls())
+
+	rep := r.Report()
+	assert.Equal(t, 1.0, rep["retry_fetches_total"])
+	assert.Equal(t, 2.0, rep["retry_retries_total"])
+	assert.Equal(t, 1.0, rep["retry_exhausted_total"])
+
+	// backoff never sleeps past the deadline
+	fo = &flakyOrigin{failN: 5}
+	r = NewRetry(fo, 10, 50*time.Millisecond, 50*time.Millisecond)
+	start := time.Now()
+	_, err = readAll(r, "abc", 20*time.Millisecond)
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < 40*time.Millisecond)
+
+	// the n-th retry sleeps less than min(maxDelay, baseDelay*2^(n-1))
+	r = NewRetry(fo, 10, time.Millisecond, 5*time.Millisecond)
+	for n, limit := range []time.Duration{1, 2, 4, 5, 5} {
+		for i := 0; i < 100; i++ {
+			assert.True(t, r.backoff(n+1) < limit*time.Millisecond)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+
+	fo := &flakyOrigin{failN: 3}
+	cb := NewCircuitBreaker(fo, 2, 20*time.Millisecond)
+
+	_, err := readAll(cb, "a", time.Second)
+	assert.NotNil(t, err)
+	assert.Equal(t, BreakerClosed, cb.State())
+	_, err = readAll(cb, "a", time.Second)
+	assert.NotNil(t, err)
+	assert.Equal(t, BreakerOpen, cb.State())
+
+	// fails fast without calling origin
+	_, err = readAll(cb, "a", time.Second)
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.Equal(t, 2, fo.calls())
+
+	// failed probe reopens the circuit
+	time.Sleep(25 * time.Millisecond)
+	_, err = readAll(cb, "a", time.Second)
+	assert.NotNil(t, err)
+	assert.NotEqual(t, ErrCircuitOpen, err)
+	assert.Equal(t, BreakerOpen, cb.State())
+
+	// successful probe closes it
+	time.Sleep(25 * time.Millisecond)
+	b, err := readAll(cb, "a", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", string(b))
+	assert.Equal(t, BreakerClosed, cb.State())
+
+	rep := cb.Report()
+	assert.Equal(t, 0.0, rep["breaker_state"])
+	assert.Equal(t, 2.0, rep["breaker_trips_total"])
+	assert.Equal(t, 1.0, rep["breaker_rejected_total"])
+}
+
+func TestCircuitBreakerStaleOutcomes(t *testing.T) {
+
+	fo := &flakyOrigin{}
+	cb := NewCircuitBreaker(fo, 1, 20*time.Millisecond)
+
+	// a slow fetch started while closed
+	slow, _ := cb.Fetch("slow", time.Second)
+
+	fo.mu.Lock()
+	fo.failN = fo.called + 1
+	fo.mu.Unlock()
+	_, err := readAll(cb, "a", time.Second)
+	assert.NotNil(t, err)
+	assert.Equal(t, BreakerOpen, cb.State())
+
+	// succeeding during the cooldown doesn't close the circuit
+	b, err := ioutil.ReadAll(slow)
+	assert.Nil(t, err)
+	assert.Equal(t, "slow", string(b))
+	assert.Equal(t, BreakerOpen, cb.State())
+
+	// nor while a probe is in flight
+	time.Sleep(25 * time.Millisecond)
+	slow, _ = cb.Fetch("slow", time.Second) // the probe
+	_, err = readAll(cb, "a", time.Second)
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.Equal(t, BreakerHalfOpen, cb.State())
+	_, err = ioutil.ReadAll(slow)
+	assert.Nil(t, err)
+	assert.Equal(t, BreakerClosed, cb.State())
+}
+
+func TestLimiter(t *testing.T) {
+
+	l := NewLimiter(&fake.DelayedOrigin{}, 2) // 100ms per fetch
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := readAll(l, "a", time.Second)
+			assert.Nil(t, err)
+			wg.Done()
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 2, l.InFlight())
+	_, err := readAll(l, "b", 30*time.Millisecond)
+	assert.Equal(t, ErrTooManyFetches, err)
+
+	wg.Wait()
+	assert.Equal(t, 0, l.InFlight())
+	assert.Equal(t, 1.0, l.Report()["limiter_rejected_total"])
+
+	// no time left for the wrapped origin
+	_, err = readAll(l, "c", 0)
+	assert.Equal(t, ErrTooManyFetches, err)
+	assert.Equal(t, 0, l.InFlight())
+}
+
+func TestStackedReport(t *testing.T) {
+
+	o := NewRetry(NewCircuitBreaker(NewLimiter(&fake.NoDelayOrigin{}, 4), 1, time.Second),
+		2, time.Millisecond, time.Millisecond)
+
+	b, err := readAll(o, "abc", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", string(b))
+
+	rep := o.Report()
+	for _, name := range []string{
+		"retry_fetches_total", "breaker_state", "limiter_in_flight", "limiter_limit",
+	} {
+		_, ok := rep[name]
+		assert.True(t, ok, name)
+	}
+	assert.Equal(t, 0.0, rep["limiter_in_flight"])
+}
+
+func readAll(o origin.Origin, key string, timeout time.Duration) ([]byte, error) {
+	rc, _ := o.Fetch(key, timeout)
+	if rc == nil {
+		return nil, errors.New("nil ReadCloser")
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
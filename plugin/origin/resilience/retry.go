@@ -0,0 +1,102 @@
+package resilience
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/wv0m56/prefixed/plugin/origin"
+)
+
+// Retry is an Origin middleware which retries failed fetches with full-jitter
+// exponential backoff. A fetch has failed if the wrapped origin returns a nil
+// ReadCloser or if reading the stream returns an error, so Retry buffers the
+// whole payload before handing it to the engine. All attempts, including
+// backoff sleeps, share the timeout passed into Fetch.
+type Retry struct {
+	o           origin.Origin
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+
+	fetches, retries, exhausted uint64 // atomic
+}
+
+// NewRetry wraps o in a Retry making at most maxAttempts attempts per fetch.
+// The n-th retry, counting from 1, sleeps a random duration in
+// [0, min(maxDelay, baseDelay*2^(n-1))), so the first one sleeps up to
+// baseDelay.
+// It panics if maxAttempts < 1 or if the delays are not positive.
+func NewRetry(o origin.Origin, maxAttempts int, baseDelay, maxDelay time.Duration) *Retry {
+	if maxAttempts < 1 {
+		panic("retry max attempts must be >= 1")
+	}
+	if baseDelay <= 0 || maxDelay < baseDelay {
+		panic("retry delays must be positive and baseDelay <= maxDelay")
+	}
+	return &Retry{o: o, maxAttempts: maxAttempts, baseDelay: baseDelay, maxDelay: maxDelay}
+}
+
+func (r *Retry) Fetch(key string, timeout time.Duration) (io.ReadCloser, *time.Time) {
+
+	atomic.AddUint64(&r.fetches, 1)
+	deadline := time.Now().Add(timeout)
+
+	var err error
+	for attempt := 0; attempt < r.maxAttempts; attempt++ {
+
+		if attempt > 0 {
+
+			sleep := r.backoff(attempt)
+			if time.Now().Add(sleep).After(deadline) {
+				break
+			}
+			time.Sleep(sleep)
+			atomic.AddUint64(&r.retries, 1)
+		}
+
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			break
+		}
+
+		var b []byte
+		var exp *time.Time
+		b, exp, err = fetchAll(r.o, key, remaining)
+		if err == nil {
+			return ioutil.NopCloser(bytes.NewReader(b)), exp
+		}
+
+		if errors.Is(err, ErrCircuitOpen) {
+			break // pointless to hammer an open circuit
+		}
+	}
+
+	atomic.AddUint64(&r.exhausted, 1)
+	if err == nil {
+		err = errors.New("retry: fetch timeout elapsed")
+	}
+	return &errReadCloser{err}, nil
+}
+
+// backoff returns the sleep before attempt, the attempt-th retry.
+func (r *Retry) backoff(attempt int) time.Duration {
+	d := r.baseDelay << uint(attempt-1)
+	if d > r.maxDelay || d <= 0 { // d <= 0 on overflow
+		d = r.maxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// Report implements origin.Reporter.
+func (r *Retry) Report() map[string]float64 {
+	return report(r.o, map[string]float64{
+		"retry_fetches_total":   float64(atomic.LoadUint64(&r.fetches)),
+		"retry_retries_total":   float64(atomic.LoadUint64(&r.retries)),
+		"retry_exhausted_total": float64(atomic.LoadUint64(&r.exhausted)),
+	})
+}
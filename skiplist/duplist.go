@@ -46,26 +46,18 @@ func (d *Duplist) DelElement(de *DupElement) {
 	}
 }
 
+// iterSearch finds de and the elements left of it at every level. Elements
+// with duplicate keys can only be told apart by walking level 0, so the search
+// lands before the leftmost duplicate of de.key then walks right until de.
 func (d *Duplist) iterSearch(de *DupElement) (left []*DupElement, iter *DupElement) {
-	left = make([]*DupElement, d.maxHeight)
-
-	for h := d.maxHeight - 1; h >= 0; h-- {
 
-		if h == d.maxHeight-1 || left[h+1] == nil {
-			iter = d.front[h]
-		} else {
-			left[h] = left[h+1]
-			iter = left[h].nexts[h]
-		}
+	left, iter = d.search(de.key)
 
-		for {
-			if iter == nil || iter == de || de.key.Before(iter.key) {
-				break
-			} else {
-				left[h] = iter
-				iter = iter.nexts[h]
-			}
+	for iter != nil && iter != de && iter.key.Equal(de.key) {
+		for h := 0; h < len(iter.nexts); h++ {
+			left[h] = iter
 		}
+		iter = iter.nexts[0]
 	}
 
 	return
@@ -155,10 +147,11 @@ func (d *Duplist) reassignLeftAtIndex(i int, left []*DupElement, de *DupElement)
 
 func (d *Duplist) DelFirst() {
 
-	for i := 0; i < d.maxHeight; i++ {
-		if d.front[i] == nil || d.front[i] != d.front[0] {
-			continue
-		}
-		d.front[i] = d.front[i].nexts[i]
+	first := d.front[0] // front[0] moves on during the loop
+	if first == nil {
+		return
+	}
+	for i := 0; i < len(first.nexts); i++ {
+		d.front[i] = first.nexts[i]
 	}
 }
@@ -1,6 +1,7 @@
 package skiplist
 
 import (
+	"strconv"
 	"testing"
 	"time"
 
@@ -67,6 +68,49 @@ func TestDuplist(t *testing.T) {
 	assert.Equal(t, "", vals)
 }
 
+func TestDuplistDelDuplicates(t *testing.T) {
+
+	d := NewDuplist(8)
+	now := time.Now()
+	var els []*DupElement
+	for i := 0; i < 1000; i++ {
+		els = append(els, d.Insert(now.Add(time.Duration(i%3)), strconv.Itoa(i)))
+	}
+
+	// delete in insertion order, i.e. right to left among duplicates
+	for i, el := range els {
+		d.DelElement(el)
+		n := 0
+		for it := d.First(); it != nil; it = it.Next() {
+			n++
+			assert.NotEqual(t, el, it)
+		}
+		assert.Equal(t, len(els)-i-1, n)
+	}
+	assert.Nil(t, d.First())
+}
+
+func TestDuplistDelFirstTall(t *testing.T) {
+
+	d := NewDuplist(8)
+	now := time.Now()
+	for i := 0; i < 1000; i++ {
+		d.Insert(now.Add(time.Duration(i)), strconv.Itoa(i))
+	}
+
+	// every level must stop referring to deleted elements
+	for i := 0; i < 1000; i++ {
+		first := d.First()
+		d.DelFirst()
+		for h := range d.front {
+			assert.NotEqual(t, first, d.front[h])
+		}
+	}
+	for h := range d.front {
+		assert.Nil(t, d.front[h])
+	}
+}
+
 func BenchmarkDuplistInsert(b *testing.B) {
 
 	N := 1000 * 10
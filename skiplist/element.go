@@ -6,11 +6,14 @@ import (
 
 // An Element is a KV node in the skiplist. Internally, it holds the height information
 // determined by a series of coin flips and pointers to the next element at each level
-// up to its height. Once created, an element's key and value are immutable.
+// up to its height. Once created, an element's key, value, metadata and
+// version are immutable.
 type Element struct {
-	key   string
-	val   []byte
-	nexts []*Element
+	key     string
+	val     []byte
+	meta    *Meta
+	version uint64
+	nexts   []*Element
 }
 
 func (e *Element) Key() string {
@@ -33,14 +36,30 @@ func (e *Element) ValCopy() []byte {
 	return nil
 }
 
+// Version returns the version of the element, see Skiplist.Upsert.
+func (e *Element) Version() uint64 {
+	return e.version
+}
+
+// Meta returns the metadata of the element, nil if it has none. It must not
+// be modified, see Meta.Copy.
+func (e *Element) Meta() *Meta {
+	return e.meta
+}
+
+// size is what the element accounts for in the payload size.
+func (e *Element) size() int64 {
+	return int64(len(e.val)) + e.meta.Size()
+}
+
 // Next returns the next element using the 0th level pointer.
 func (e *Element) Next() *Element {
 	return e.nexts[0]
 }
 
-func newElem(key string, val []byte, maxHeight int) *Element {
+func newElem(key string, val []byte, meta *Meta, version uint64, maxHeight int) *Element {
 	lvl := 1 + addHeight(maxHeight)
-	return &Element{key, val, make([]*Element, lvl)}
+	return &Element{key, val, meta, version, make([]*Element, lvl)}
 }
 
 func addHeight(maxHeight int) int {
@@ -0,0 +1,56 @@
+package skiplist
+
+import (
+	"time"
+)
+
+// metaFixedSize is the size accounted for the fixed size fields of a Meta.
+const metaFixedSize = 4 + 8 + 8
+
+// Meta is optional metadata of an element, stored along its value. Like the
+// value it is immutable once upserted, and its Size counts towards the
+// payload size of the skiplist.
+type Meta struct {
+	// ContentType is the media type of the value, e.g. "application/json".
+	ContentType string `json:"content_type,omitempty"`
+
+	// Flags are opaque, e.g. memcached client flags.
+	Flags uint32 `json:"flags,omitempty"`
+
+	// Created is when the value was written.
+	Created time.Time `json:"created"`
+
+	// Headers are arbitrary name/value pairs, e.g. provided by an origin.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Size returns the number of bytes m accounts for. A nil Meta has size 0.
+func (m *Meta) Size() int64 {
+
+	if m == nil {
+		return 0
+	}
+
+	n := int64(metaFixedSize + len(m.ContentType))
+	for k, v := range m.Headers {
+		n += int64(len(k) + len(v))
+	}
+	return n
+}
+
+// Copy returns a deep copy of m, or nil if m is nil.
+func (m *Meta) Copy() *Meta {
+
+	if m == nil {
+		return nil
+	}
+
+	c := *m
+	if m.Headers != nil {
+		c.Headers = make(map[string]string, len(m.Headers))
+		for k, v := range m.Headers {
+			c.Headers[k] = v
+		}
+	}
+	return &c
+}
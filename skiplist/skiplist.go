@@ -10,6 +10,7 @@ type Skiplist struct {
 	front            []*Element
 	len, payloadSize int64
 	maxHeight        int
+	version          uint64 // last version handed out, see Upsert
 }
 
 // NewSkiplist returns Skiplist with a height of maxHeight. maxHeight must be
@@ -22,7 +23,7 @@ func NewSkiplist(maxHeight int) *Skiplist {
 }
 
 // Init must be called on a skiplist created without calling NewSkiplist().
-// It empties the skiplist.
+// It empties the skiplist. Versions handed out by Upsert keep increasing.
 func (s *Skiplist) Init(maxHeight int) {
 	s.front = make([]*Element, maxHeight)
 	s.len = 0
@@ -39,7 +40,8 @@ func (s *Skiplist) Len() int64 {
 	return s.len
 }
 
-// PayloadSize returns the total sum of len(Val) from all elements.
+// PayloadSize returns the total sum of len(Val) and of the metadata sizes
+// from all elements, see Meta.Size.
 func (s *Skiplist) PayloadSize() int64 {
 	return s.payloadSize
 }
@@ -50,14 +52,17 @@ func (s *Skiplist) First() *Element {
 }
 
 // Upsert searches for insert position and insert into that position.
-// It overwrites existing key if it already exists.
-// Upsert does nothing if key == "".
-func (s *Skiplist) Upsert(key string, val []byte) {
+// It overwrites existing key if it already exists, metadata included. meta
+// may be nil. The element gets a version greater than any version handed out
+// before by s, which Upsert returns. Upsert does nothing and returns 0 if
+// key == "".
+func (s *Skiplist) Upsert(key string, val []byte, meta *Meta) uint64 {
 
 	if key == "" {
-		return
+		return 0
 	}
-	e := newElem(key, val, s.maxHeight)
+	s.version++
+	e := newElem(key, val, meta, s.version, s.maxHeight)
 
 	if s.len == 0 {
 
@@ -67,8 +72,7 @@ func (s *Skiplist) Upsert(key string, val []byte) {
 
 		s.searchAndUpsert(e)
 	}
-
-	s.len++
+	return e.version
 }
 
 // Get finds an Element by key according to the comma-ok idiom.
@@ -82,6 +86,13 @@ func (s *Skiplist) Get(key string) (*Element, bool) {
 	return nil, false
 }
 
+// Seek returns the first element whose key is >= key, or nil if there is no
+// such element. Iterate from there with Next.
+func (s *Skiplist) Seek(key string) *Element {
+	_, it := s.search(key)
+	return it
+}
+
 // GetByPrefix returns a slice of Elements whose keys are prefixed by p.
 // It returns nil if no such thing is found.
 func (s *Skiplist) GetByPrefix(p string) (es []*Element) {
@@ -112,13 +123,9 @@ func (s *Skiplist) Del(key string) *Element {
 func (s *Skiplist) DelByPrefix(p string) {
 
 	left, it := s.search(p)
-	for ; strings.HasPrefix(it.key, p); it = it.Next() {
+	for ; it != nil && strings.HasPrefix(it.key, p); it = it.Next() {
 		s.del(left, it)
-		if it.Next() == nil {
-			return
-		}
 	}
-	return
 }
 
 func (s *Skiplist) del(left []*Element, e *Element) {
@@ -126,7 +133,7 @@ func (s *Skiplist) del(left []*Element, e *Element) {
 	for i := 0; i < len(e.nexts); i++ {
 		s.reassignLeftAtIndex(i, left, e.nexts[i])
 	}
-	s.payloadSize -= int64(len(e.val))
+	s.payloadSize -= e.size()
 	s.len--
 }
 
@@ -193,12 +200,13 @@ func (s *Skiplist) insertBetween(left []*Element, e, right *Element) {
 
 		s.reassignLeftAtIndex(i, left, e)
 	}
-	s.payloadSize += int64(len(e.val))
+	s.payloadSize += e.size()
+	s.len++
 }
 
 func (s *Skiplist) replace(left []*Element, e, right *Element) {
 
-	s.payloadSize -= int64(len(right.val))
+	s.payloadSize -= right.size()
 
 	for i := 0; i < max(len(e.nexts), len(right.nexts)); i++ {
 
@@ -219,7 +227,7 @@ func (s *Skiplist) replace(left []*Element, e, right *Element) {
 			s.reassignLeftAtIndex(i, left, right.nexts[i])
 		}
 	}
-	s.payloadSize += int64(len(e.val))
+	s.payloadSize += e.size()
 }
 
 func (s *Skiplist) takeNextsFromLeftAtIndex(i int, left []*Element, e *Element) {
@@ -24,7 +24,7 @@ func TestInsertsInternal(t *testing.T) {
 	skip := NewSkiplist(32)
 
 	// "tokyo"
-	skip.Upsert("tokyo", nil)
+	skip.Upsert("tokyo", nil, nil)
 	assert.Equal(t, 1, int(skip.Len()))
 	first := skip.First()
 	assert.NotNil(t, first)
@@ -46,7 +46,7 @@ func TestInsertsInternal(t *testing.T) {
 	}
 
 	// "zulu"
-	skip.Upsert("zulu", nil)
+	skip.Upsert("zulu", nil, nil)
 	assert.Equal(t, 2, int(skip.Len()))
 	first = skip.First()
 	assert.Equal(t, "tokyo", first.Key())
@@ -65,7 +65,7 @@ func TestInsertsInternal(t *testing.T) {
 	assert.Equal(t, "zulu", first.Next().Key())
 
 	// "angola"
-	skip.Upsert("angola", nil)
+	skip.Upsert("angola", nil, nil)
 	first = skip.First()
 	assert.Equal(t, "angola", first.Key())
 	next := first.Next()
@@ -74,18 +74,40 @@ func TestInsertsInternal(t *testing.T) {
 	assert.Equal(t, "zulu", next.Key())
 
 	// ""
-	skip.Upsert("", nil)
+	skip.Upsert("", nil, nil)
 	assert.Equal(t, 3, int(skip.Len()))
 	assert.Equal(t, 0, int(skip.PayloadSize()))
 
+	// overwriting doesn't add an element
+	skip.Upsert("zulu", []byte("z"), nil)
+	assert.Equal(t, 3, int(skip.Len()))
+	assert.Equal(t, 1, int(skip.PayloadSize()))
+	skip.Upsert("zulu", nil, nil)
+	assert.Equal(t, 0, int(skip.PayloadSize()))
+
 	// payload size
-	skip.Upsert("aaaaaaaaaaaa", []byte("aaaaaaaaaaaa"))
+	skip.Upsert("aaaaaaaaaaaa", []byte("aaaaaaaaaaaa"), nil)
 	assert.Equal(t, 12, int(skip.PayloadSize()))
-	skip.Upsert("123", []byte("123"))
+	skip.Upsert("123", []byte("123"), nil)
 	assert.Equal(t, 15, int(skip.PayloadSize()))
-	skip.Upsert("123", []byte("12345"))
+	skip.Upsert("123", []byte("12345"), nil)
 	assert.Equal(t, 17, int(skip.PayloadSize()))
 
+	// metadata counts too, and goes away when overwritten without any
+	m := &Meta{ContentType: "text/plain", Headers: map[string]string{"a": "bc"}}
+	assert.Equal(t, int64(metaFixedSize+10+3), m.Size())
+	skip.Upsert("123", []byte("12345"), m)
+	assert.Equal(t, 17+int(m.Size()), int(skip.PayloadSize()))
+	el, _ := skip.Get("123")
+	assert.Equal(t, m, el.Meta())
+	v := el.Version()
+	assert.Equal(t, v+1, skip.Upsert("123", []byte("12345"), nil))
+	assert.Equal(t, 17, int(skip.PayloadSize()))
+	el, _ = skip.Get("123")
+	assert.Nil(t, el.Meta())
+	assert.Equal(t, v+1, el.Version())
+	assert.Zero(t, skip.Upsert("", nil, nil))
+
 	// init
 	skip.Init(32)
 	assert.Nil(t, skip.First())
@@ -105,7 +127,7 @@ func TestInsertsInternal(t *testing.T) {
 		"korea", "korea", "browser", "panic"}
 
 	for _, v := range strs {
-		skip.Upsert(v, nil)
+		skip.Upsert(v, nil, nil)
 	}
 
 	var appended string
@@ -133,12 +155,12 @@ func TestInsertsInternal(t *testing.T) {
 	assert.Equal(t, "python", it.Key())
 
 	// GetByPrefix
-	skip.Upsert("cartoon", nil)
-	skip.Upsert("carnival", nil)
-	skip.Upsert("carnivore", nil)
-	skip.Upsert("caravan", nil)
-	skip.Upsert("caricature", nil)
-	skip.Upsert("cargo", nil)
+	skip.Upsert("cartoon", nil, nil)
+	skip.Upsert("carnival", nil, nil)
+	skip.Upsert("carnivore", nil, nil)
+	skip.Upsert("caravan", nil, nil)
+	skip.Upsert("caricature", nil, nil)
+	skip.Upsert("cargo", nil, nil)
 
 	es := skip.GetByPrefix("car")
 
@@ -158,16 +180,23 @@ func TestInsertsInternal(t *testing.T) {
 	es = skip.GetByPrefix("carni")
 	assert.Equal(t, 2, len(es))
 
+	// Seek
+	assert.Equal(t, "car", skip.Seek("car").Key())
+	assert.Equal(t, "carnival", skip.Seek("carn").Key())
+	assert.Equal(t, "cartoon", skip.Seek("carnivores").Key())
+	assert.Equal(t, skip.First(), skip.Seek(""))
+	assert.Nil(t, skip.Seek("~"))
+
 	// Del and DelByPrefix
 	skip.Init(32)
-	skip.Upsert("park", []byte("park"))
-	skip.Upsert("animal", []byte("animal"))
-	skip.Upsert("moon", []byte("moon"))
-	skip.Upsert("noon", []byte("noon"))
-	skip.Upsert("lock", []byte("lock"))
-	skip.Upsert("low", []byte("low"))
-	skip.Upsert("lonely", []byte("lonely"))
-	skip.Upsert("loop", []byte("loop"))
+	skip.Upsert("park", []byte("park"), nil)
+	skip.Upsert("animal", []byte("animal"), nil)
+	skip.Upsert("moon", []byte("moon"), nil)
+	skip.Upsert("noon", []byte("noon"), nil)
+	skip.Upsert("lock", []byte("lock"), nil)
+	skip.Upsert("low", []byte("low"), nil)
+	skip.Upsert("lonely", []byte("lonely"), nil)
+	skip.Upsert("loop", []byte("loop"), nil)
 	assert.Equal(t, int64(8), skip.Len())
 	assert.Equal(t, int64(35), skip.PayloadSize())
 	e = skip.Del("animal")
@@ -179,6 +208,10 @@ func TestInsertsInternal(t *testing.T) {
 	assert.Equal(t, int64(3), skip.Len())
 	assert.Equal(t, int64(35-6-17), skip.PayloadSize())
 	assert.Nil(t, skip.Del("batman"))
+	skip.DelByPrefix("zz") // past the last key
+	skip.DelByPrefix("p")  // up to the last key
+	assert.Equal(t, int64(2), skip.Len())
+	NewSkiplist(4).DelByPrefix("") // empty
 
 	// Val
 	e, ok = skip.Get("moon")
@@ -202,13 +235,13 @@ func BenchmarkInserts(b *testing.B) {
 	N := 1000 * 10
 	skip := NewSkiplist(int(math.Floor(math.Log2(float64(N / 2)))))
 	for i := 0; i < N; i++ {
-		skip.Upsert(strconv.Itoa(rand.Int()), nil)
+		skip.Upsert(strconv.Itoa(rand.Int()), nil, nil)
 	}
 
 	k := strconv.Itoa(rand.Int())
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		skip.Upsert(k, nil)
+		skip.Upsert(k, nil, nil)
 	}
 }
 
@@ -219,10 +252,10 @@ func BenchmarkGet(b *testing.B) {
 	N := 1000 * 10
 	skip := NewSkiplist(int(math.Floor(math.Log2(float64(N / 2)))))
 	for i := 0; i < N; i++ {
-		skip.Upsert(strconv.Itoa(rand.Int()), nil)
+		skip.Upsert(strconv.Itoa(rand.Int()), nil, nil)
 	}
 
-	skip.Upsert("85811", nil)
+	skip.Upsert("85811", nil, nil)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		skip.Get("85811")
@@ -236,7 +269,7 @@ func BenchmarkGetByPrefix(b *testing.B) {
 	N := 1000 * 10
 	skip := NewSkiplist(int(math.Floor(math.Log2(float64(N / 2)))))
 	for i := 0; i < N; i++ {
-		skip.Upsert(strconv.Itoa(rand.Int()), nil)
+		skip.Upsert(strconv.Itoa(rand.Int()), nil, nil)
 	}
 
 	b.ResetTimer()
@@ -252,9 +285,9 @@ func BenchmarkDel(b *testing.B) {
 	N := 1000 * 10
 	skip := NewSkiplist(int(math.Floor(math.Log2(float64(N / 2)))))
 	for i := 0; i < N; i++ {
-		skip.Upsert(strconv.Itoa(rand.Int()), nil)
+		skip.Upsert(strconv.Itoa(rand.Int()), nil, nil)
 	}
-	skip.Upsert("8787128", nil)
+	skip.Upsert("8787128", nil, nil)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {